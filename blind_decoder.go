@@ -17,8 +17,10 @@ package cbor
 
 import (
 	"fmt"
+	"math"
 	"math/big"
 	"reflect"
+	"unicode/utf8"
 )
 
 // function used to decode extended tag info
@@ -56,6 +58,50 @@ func RegisterTagExtensionFn(tagInfo uint64, fn handleTagDecFn) error {
 	return extensionTagDec.register(tagInfo, fn)
 }
 
+// Go types registered by the user to decode the content of a given tag
+type tagTypesMap map[uint64]reflect.Type
+
+// global tag types register
+var tagTypesDec tagTypesMap = make(tagTypesMap)
+
+// Registers the Go type of proto as the type to use when a data item
+// with the given tag is decoded into an interface, if proto is a pointer
+// then the content of the tag is decoded into a newly allocated value
+func RegisterTagType(tagInfo uint64, proto interface{}) error {
+	if _, ok := tagTypesDec[tagInfo]; ok {
+		return fmt.Errorf("0x%x tag information is already registered", tagInfo)
+	}
+	tagTypesDec[tagInfo] = reflect.TypeOf(proto)
+	return nil
+}
+
+// decodes the content of a tag into a new value of the registered type t,
+// every tag counts as a nesting level as its content can be another tag
+func (dec *Decoder) decodeTagType(t reflect.Type) (interface{}, error) {
+	if err := dec.enterContainer(); err != nil {
+		return nil, err
+	}
+	defer dec.leaveContainer()
+	if _, _, err := dec.parser.parseInformation(); err != nil {
+		return nil, err
+	}
+	v := reflect.New(t).Elem()
+	if err := dec.decode(v); err != nil {
+		return nil, err
+	}
+	return v.Interface(), nil
+}
+
+// decodes a definite or indefinite byte string as a []byte or as
+// a string if the decoder is configured to do so and it's valid UTF-8
+func (dec *Decoder) blindBytes() (interface{}, reflect.Kind) {
+	b := dec.decodeBytes()
+	if dec.bytesAsString && utf8.Valid(b) {
+		return string(b), reflect.String
+	}
+	return b, byteString
+}
+
 // decodes into v scanning the CBOR data that comes in the encoded data
 func (dec *Decoder) blind() (v interface{}, vk reflect.Kind, err error) {
 	header := dec.parser.header
@@ -69,19 +115,20 @@ func (dec *Decoder) blind() (v interface{}, vk reflect.Kind, err error) {
 	case absoluteTrue:
 		vk = reflect.Bool
 		v = true
-	case absoluteFloat16, absoluteFloat32:
+	case absoluteFloat16:
 		vk = reflect.Float32
-		if info == cborFloat16 {
-			v = dec.decodeFloat16()
-		} else {
-			v = dec.decodeInt32()
-		}
+		v = dec.decodeFloat16()
+	case absoluteFloat32:
+		vk = reflect.Float32
+		v = dec.decodeFloat32()
 	case absoluteFloat64:
 		vk = reflect.Float64
 		v = dec.decodeFloat64()
+	case absoluteSimple:
+		vk = simpleValue
+		v = dec.decodeSimple()
 	case absoluteIndefiniteBytes:
-		vk = byteString
-		v = dec.decodeBytes()
+		v, vk = dec.blindBytes()
 	case absoluteIndefiniteString:
 		vk = reflect.String
 		v = dec.decodeString()
@@ -106,7 +153,11 @@ func (dec *Decoder) blind() (v interface{}, vk reflect.Kind, err error) {
 		v = dec.decodeDecimalFraction()
 	case absoluteBigFloat:
 		vk = bigFloat
-		v = dec.decodeBigFloat()
+		if dec.exactFloats {
+			v = dec.decodeExactBigFloat()
+		} else {
+			v = dec.decodeBigFloat()
+		}
 	case absoluteBase64Url:
 		vk = base64Url
 		v = dec.decodeBase64Url()
@@ -163,8 +214,7 @@ func (dec *Decoder) blind() (v interface{}, vk reflect.Kind, err error) {
 		}
 		// byte strings
 		if header >= absoluteBytes && header < absoluteString {
-			vk = byteString
-			v = dec.decodeBytes()
+			v, vk = dec.blindBytes()
 		}
 		// unicode string
 		if header >= absoluteString && header < absoluteArray {
@@ -179,6 +229,11 @@ func (dec *Decoder) blind() (v interface{}, vk reflect.Kind, err error) {
 		if header >= absoluteMap && header < absoluteTag {
 			vk = reflect.Map
 		}
+		// simple values
+		if header >= absoluteNoContent && header < absoluteFalse {
+			vk = simpleValue
+			v = dec.decodeSimple()
+		}
 		// tags
 		if header >= absoluteTag && header < absoluteNoContent {
 			tagInfo := dec.parser.buflen()
@@ -199,6 +254,14 @@ func (dec *Decoder) blind() (v interface{}, vk reflect.Kind, err error) {
 				vk = MIME
 				v = dec.decodeMime()
 			default:
+				// lookup in the user registered tag types
+				if t, ok := tagTypesDec[tagInfo]; ok {
+					vk = registeredType
+					if v, err = dec.decodeTagType(t); err != nil {
+						return nil, 0, err
+					}
+					break
+				}
 				// lookup in the extended user defined tags
 				fn, err := extensionTagDec.lookup(tagInfo)
 				if err == nil {
@@ -207,7 +270,12 @@ func (dec *Decoder) blind() (v interface{}, vk reflect.Kind, err error) {
 						return nil, 0, err
 					}
 				} else {
-					vk = reflect.Ptr
+					vk = unknownTag
+					content, err := dec.decodeTagType(typeInterface)
+					if err != nil {
+						return nil, 0, err
+					}
+					v = Tag{Number: tagInfo, Content: content}
 				}
 			}
 		}
@@ -216,5 +284,37 @@ func (dec *Decoder) blind() (v interface{}, vk reflect.Kind, err error) {
 	if vk == 0 {
 		return nil, 0, fmt.Errorf("blind: Unrecognized header 0x%x", header)
 	}
+	if dec.wideInts && header < absoluteBytes {
+		v, vk = widenInt(v, vk)
+	} else if dec.intsAsInt64 && header < absoluteBytes {
+		v, vk = normalizeInt(v, vk)
+	}
 	return v, vk, nil
 }
+
+// converts unsigned integers into uint64 and signed ones into int64
+func widenInt(v interface{}, vk reflect.Kind) (interface{}, reflect.Kind) {
+	rv := reflect.ValueOf(v)
+	switch vk {
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return rv.Uint(), reflect.Uint64
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), reflect.Int64
+	}
+	return v, vk
+}
+
+// converts any integer into an int64 or an uint64 if it doesn't fit
+func normalizeInt(v interface{}, vk reflect.Kind) (interface{}, reflect.Kind) {
+	rv := reflect.ValueOf(v)
+	switch vk {
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n := rv.Uint(); n > math.MaxInt64 {
+			return n, reflect.Uint64
+		}
+		return int64(rv.Uint()), reflect.Int64
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), reflect.Int64
+	}
+	return v, vk
+}
@@ -0,0 +1,152 @@
+// A Golang RFC7049 implementation
+// Copyright (C) 2015 Oscar Campos
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Canonicalize decodes the given (possibly non canonical) CBOR data item
+// and re-encodes it following the section 3.9 Canonical CBOR rules of the
+// RFC7049: integers and lengths use the shortest form, indefinite length
+// items are converted into definite ones, map keys are sorted (shorter
+// keys first, then bytewise) and floats use the shortest precision that
+// keeps their value untouched
+func Canonicalize(data []byte) ([]byte, error) {
+	p := NewParser(bytes.NewReader(data))
+	tz := newTokenizer(p, defaultMaxDepth)
+	t, err := tz.next()
+	var out []byte
+	if err == nil {
+		out, err = canonicalizeToken(tz, t)
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = NewParseErr("unexpected end of data")
+		}
+		return nil, err
+	}
+	p.startItem()
+	if _, err := p.scan1(); err != io.EOF {
+		return nil, NewCanonicalModeError(
+			"trailing data after the first data item")
+	}
+	return out, nil
+}
+
+// returns back the data item started by t in its canonical form
+func canonicalizeToken(tz *tokenizer, t token) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	c := NewComposer(buf)
+	switch t.Major {
+	case cborUnsignedInt, cborNegativeInt:
+		if _, err := c.composeUint(t.Arg, t.Major); err != nil {
+			return nil, err
+		}
+	case cborByteString, cborTextString:
+		data, err := tz.join(t)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.composeBytes(data, t.Major); err != nil {
+			return nil, err
+		}
+	case cborDataArray, cborTag:
+		items, err := canonicalizeItems(tz)
+		if err != nil {
+			return nil, err
+		}
+		n := t.Arg
+		if t.Major == cborDataArray {
+			n = uint64(len(items))
+		}
+		if _, err := c.composeUint(n, t.Major); err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			c.write(item)
+		}
+	case cborDataMap:
+		items, err := canonicalizeItems(tz)
+		if err != nil {
+			return nil, err
+		}
+		pairs := make([][2][]byte, len(items)/2)
+		for i := range pairs {
+			pairs[i] = [2][]byte{items[i*2], items[i*2+1]}
+		}
+		sortPairs(pairs)
+		for i := 1; i < len(pairs); i++ {
+			if bytes.Equal(pairs[i-1][0], pairs[i][0]) {
+				return nil, NewCanonicalModeError(
+					fmt.Sprintf("duplicated map key 0x%x", pairs[i][0]))
+			}
+		}
+		if _, err := c.composeUint(uint64(len(pairs)), t.Major); err != nil {
+			return nil, err
+		}
+		for _, pair := range pairs {
+			c.write(pair[0])
+			c.write(pair[1])
+		}
+	case cborNC:
+		if err := canonicalizeSimple(c, t); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// returns back the canonical form of every element of an array, every
+// key and value of a map or the content of a tag, definite or not
+func canonicalizeItems(tz *tokenizer) ([][]byte, error) {
+	var items [][]byte
+	err := tz.each(func(_ int, t token) error {
+		item, err := canonicalizeToken(tz, t)
+		items = append(items, item)
+		return err
+	})
+	return items, err
+}
+
+// writes simple values and floats in its canonical form, floats
+// are written using the shortest precision that keeps its value
+func canonicalizeSimple(c *Composer, t token) error {
+	switch t.Info {
+	case absoluteFloat16 & 0x1f, absoluteFloat32 & 0x1f, absoluteFloat64 & 0x1f:
+		f, _ := t.Float()
+		return c.composeShortestFloat(f)
+	}
+	return c.composeSimple(Simple(t.Arg))
+}
+
+// sorts encoded map keys and values by its keys in canonical order
+func sortPairs(pairs [][2][]byte) {
+	sort.Slice(pairs, func(i, j int) bool {
+		return canonicalLess(pairs[i][0], pairs[j][0])
+	})
+}
+
+// reports whether the canonical encoded key a sorts before b
+func canonicalLess(a, b []byte) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return bytes.Compare(a, b) < 0
+}
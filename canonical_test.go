@@ -0,0 +1,185 @@
+// A Golang RFC7049 implementation
+// Copyright (C) 2015 Oscar Campos
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestCanonicalize(t *testing.T) {
+	cases := []struct{ in, out string }{
+		// non minimal integers
+		{"1b0000000000000001", "01"},
+		{"1a00000018", "1818"},
+		{"390000", "20"},
+		{"d9000c1801", "cc01"},
+		// indefinite length items
+		{"9f0102ff", "820102"},
+		{"bf61610161629f03ffff", "a261610161628103"},
+		{"5f42010243030405ff", "450102030405"},
+		{"7f61616162ff", "626162"},
+		// unsorted map keys, shorter keys go first
+		{"a362616101616202616103", "a361610361620262616101"},
+		{"a2190100010a02", "a20a0219010001"},
+		// shortest floats
+		{"fb3ff0000000000000", "f93c00"},
+		{"fb3ff199999999999a", "fb3ff199999999999a"},
+		{"fb3fb0000000000000", "f92c00"},
+		{"fa47c35000", "fa47c35000"},
+		{"fb7ff8000000000000", "f97e00"},
+		{"fbfff0000000000000", "f9fc00"},
+		// simple values
+		{"f5", "f5"},
+		{"f8ff", "f8ff"},
+	}
+	for _, c := range cases {
+		in, _ := hex.DecodeString(c.in)
+		out, err := Canonicalize(in)
+		check(err)
+		expect(hex.EncodeToString(out), c.out, t, "TestCanonicalize")
+		again, err := Canonicalize(out)
+		check(err)
+		expect(bytes.Equal(again, out), true, t, "TestCanonicalize")
+	}
+}
+
+func TestCanonicalizeLongString(t *testing.T) {
+	s := bytes.Repeat([]byte{0x61}, 30)
+	in := append([]byte{0x7a, 0x00, 0x00, 0x00, 0x1e}, s...)
+	out, err := Canonicalize(in)
+	check(err)
+	expect(hex.EncodeToString(out[:2]), "781e", t, "TestCanonicalizeLongString")
+	expect(bytes.Equal(out[2:], s), true, t, "TestCanonicalizeLongString")
+}
+
+func TestCanonicalizeErrors(t *testing.T) {
+	for _, in := range []string{"a201020103", "0102", "ff", "9f01", "bf01ff", "18",
+		"5b7fffffffffffffff", "815b7fffffffffffffff", "5bffffffffffffffff",
+		"5f5bffffffffffffffffff", "bb8000000000000000"} {
+		data, _ := hex.DecodeString(in)
+		_, err := Canonicalize(data)
+		expect(err != nil, true, t, "TestCanonicalizeErrors")
+	}
+}
+
+// RFC 8949 Appendix A examples and its canonical encodings, the keys of
+// every map have the same length so the RFC7049 length-first order
+// used by the canonical mode gives the same encodings than the RFC 8949
+// bytewise order
+var rfc8949Vectors = []struct {
+	value interface{}
+	hex   string
+}{
+	// integers
+	{0, "00"},
+	{1, "01"},
+	{10, "0a"},
+	{23, "17"},
+	{24, "1818"},
+	{25, "1819"},
+	{100, "1864"},
+	{1000, "1903e8"},
+	{1000000, "1a000f4240"},
+	{1000000000000, "1b000000e8d4a51000"},
+	{uint64(18446744073709551615), "1bffffffffffffffff"},
+	{rfc8949BigInt("18446744073709551616"), "c249010000000000000000"},
+	{rfc8949BigInt("-18446744073709551617"), "c349010000000000000000"},
+	{-1, "20"},
+	{-10, "29"},
+	{-100, "3863"},
+	{-1000, "3903e7"},
+	// floats
+	{0.0, "f90000"},
+	{math.Copysign(0, -1), "f98000"},
+	{1.0, "f93c00"},
+	{1.1, "fb3ff199999999999a"},
+	{1.5, "f93e00"},
+	{65504.0, "f97bff"},
+	{100000.0, "fa47c35000"},
+	{3.4028234663852886e+38, "fa7f7fffff"},
+	{1.0e+300, "fb7e37e43c8800759c"},
+	{5.960464477539063e-8, "f90001"},
+	{0.00006103515625, "f90400"},
+	{-4.0, "f9c400"},
+	{-4.1, "fbc010666666666666"},
+	{math.Inf(1), "f97c00"},
+	{math.NaN(), "f97e00"},
+	{math.Inf(-1), "f9fc00"},
+	{float32(1.5), "f93e00"},
+	// simple values
+	{false, "f4"},
+	{true, "f5"},
+	{nil, "f6"},
+	{Simple(16), "f0"},
+	{Simple(255), "f8ff"},
+	// tags
+	{time.Unix(1363896240, 0), "c11a514b67b0"},
+	{Tag{Number: 23, Content: []byte{0x01, 0x02, 0x03, 0x04}}, "d74401020304"},
+	{Tag{Number: 24, Content: []byte("dIETF")}, "d818456449455446"},
+	{Tag{Number: 32, Content: "http://www.example.com"},
+		"d82076687474703a2f2f7777772e6578616d706c652e636f6d"},
+	// byte and text strings
+	{[]byte{}, "40"},
+	{[]byte{0x01, 0x02, 0x03, 0x04}, "4401020304"},
+	{"", "60"},
+	{"a", "6161"},
+	{"IETF", "6449455446"},
+	{"\"\\", "62225c"},
+	{"\u00fc", "62c3bc"},
+	{"\u6c34", "63e6b0b4"},
+	{"\U00010151", "64f0908591"},
+	// arrays
+	{[]int{}, "80"},
+	{[]int{1, 2, 3}, "83010203"},
+	{[]interface{}{1, []int{2, 3}, []int{4, 5}}, "8301820203820405"},
+	{[]int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25},
+		"98190102030405060708090a0b0c0d0e0f101112131415161718181819"},
+	// maps
+	{map[int]int{}, "a0"},
+	{map[int]int{1: 2, 3: 4}, "a201020304"},
+	{map[string]interface{}{"a": 1, "b": []int{2, 3}}, "a26161016162820203"},
+	{[]interface{}{"a", map[string]string{"b": "c"}}, "826161a161626163"},
+	{map[string]string{"a": "A", "b": "B", "c": "C", "d": "D", "e": "E"},
+		"a56161614161626142616361436164614461656145"},
+}
+
+func rfc8949BigInt(s string) big.Int {
+	n, _ := new(big.Int).SetString(s, 10)
+	return *n
+}
+
+func TestCanonicalRFC8949Vectors(t *testing.T) {
+	for _, v := range rfc8949Vectors {
+		buf := bytes.NewBuffer(nil)
+		check(NewEncoder(buf, WithCanonical()).Encode(v.value))
+		expect(hex.EncodeToString(buf.Bytes()), v.hex, t, "TestCanonicalRFC8949Vectors")
+
+		data, _ := hex.DecodeString(v.hex)
+		d := NewDecoder(bytes.NewReader(data))
+		_, err := d.DecodeValue()
+		check(err)
+		expect(d.More(), false, t, "TestCanonicalRFC8949Vectors")
+
+		out, err := Canonicalize(data)
+		check(err)
+		expect(hex.EncodeToString(out), v.hex, t, "TestCanonicalRFC8949Vectors")
+	}
+}
@@ -18,6 +18,7 @@
 package cbor
 
 import (
+	"fmt"
 	"math"
 	"math/big"
 	"reflect"
@@ -92,9 +93,23 @@ const (
 	cborTextBase64         = 0x22
 	cborRegexp             = 0x23
 	cborMime               = 0x24
-	cborSelfDescribe       = 0xd9d9f7
+	cborSelfDescribe       = 0xd9f7
 )
 
+// IP addresses and prefixes tags (RFC9164)
+const (
+	cborIPv4 = 0x34
+	cborIPv6 = 0x36
+)
+
+// Mathematical finite set tag
+const cborSet = 0x102
+
+// returns true for the map[K]struct{} types used as sets
+func isSetType(t reflect.Type) bool {
+	return t.Kind() == reflect.Map && t.Elem().Kind() == reflect.Struct && t.Elem().NumField() == 0
+}
+
 // this is being used to break indefinite streams
 const cborBreak byte = 0xff
 
@@ -150,8 +165,160 @@ const (
 	URI
 	tagRegexp
 	MIME
+	simpleValue
+	registeredType
+	unknownTag
 )
 
+// Simple is a CBOR simple value (major type 7) other than the
+// false, true, null and undefined ones that has no Go equivalent
+type Simple uint8
+
+// RawMessage is a raw encoded CBOR data item, it can be used
+// to delay the decoding of a value or to precompute its encoding
+type RawMessage []byte
+
+// Base64URLBytes, Base64Bytes and Base16Bytes are byte strings that are
+// encoded with the tags 21, 22 and 23 respectively, those tags tell to
+// CBOR to JSON converters what base encoding they should use for them
+type (
+	Base64URLBytes []byte
+	Base64Bytes    []byte
+	Base16Bytes    []byte
+)
+
+// Tag is a semantic tag that the library doesn't know how to process,
+// it holds the tag number and its blindly decoded content so it can
+// be observed by the application and encoded back as it was
+type Tag struct {
+	Number  uint64
+	Content interface{}
+}
+
+// options of a `cbor` struct field tag, the first comma separated
+// element of the tag is the field name and the rest are options
+type tagOptions string
+
+// splits a `cbor` struct field tag into its name and its options
+func parseTag(tag string) (string, tagOptions) {
+	if i := strings.Index(tag, ","); i != -1 {
+		return tag[:i], tagOptions(tag[i+1:])
+	}
+	return tag, tagOptions("")
+}
+
+// returns the cbor tag of a struct field, the json tag is
+// used instead when json is true and there is no cbor tag
+func fieldTag(field reflect.StructField, json bool) string {
+	tag, ok := field.Tag.Lookup("cbor")
+	if !ok && json {
+		tag = field.Tag.Get("json")
+	}
+	return tag
+}
+
+// returns true if the given option is present in the tag options
+func (o tagOptions) Contains(option string) bool {
+	if len(o) == 0 {
+		return false
+	}
+	for _, opt := range strings.Split(string(o), ",") {
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the value of a `name=value` option and true if it's present
+func (o tagOptions) Value(name string) (string, bool) {
+	if len(o) == 0 {
+		return "", false
+	}
+	for _, opt := range strings.Split(string(o), ",") {
+		if strings.HasPrefix(opt, name+"=") {
+			return opt[len(name)+1:], true
+		}
+	}
+	return "", false
+}
+
+// DecimalFraction is an exact decimal number (tag 4) that
+// represents the value Mantissa * 10 ^ Exponent
+type DecimalFraction struct {
+	Exponent int64
+	Mantissa *big.Int
+}
+
+// Rat returns the exact value of the decimal fraction as a *big.Rat,
+// it fails if the exponent is out of the MaxRatExponent range
+func (d DecimalFraction) Rat() (*big.Rat, error) {
+	if d.Mantissa == nil {
+		return new(big.Rat), nil
+	}
+	if d.Exponent > MaxRatExponent || d.Exponent < -MaxRatExponent {
+		return nil, fmt.Errorf(
+			"decimal fraction exponent %d is out of the range [%d, %d]",
+			d.Exponent, -MaxRatExponent, MaxRatExponent)
+	}
+	return decimalFractionToRat(d.Mantissa, d.Exponent), nil
+}
+
+// MaxRatExponent is the biggest absolute exponent of a BigFloat or
+// a DecimalFraction that can be converted into an exact *big.Rat,
+// bigger exponents
+// would need huge amounts of memory to hold the numerator or the
+// denominator and are rejected
+const MaxRatExponent = 1 << 16
+
+// BigFloat is an exact binary number (tag 5) that represents
+// the value Mantissa * 2 ^ Exponent as it was encoded
+type BigFloat struct {
+	Exponent int64
+	Mantissa *big.Int
+}
+
+// Rat returns the exact value of the big float as a *big.Rat,
+// it fails if the exponent is out of the MaxRatExponent range
+func (b BigFloat) Rat() (*big.Rat, error) {
+	if b.Mantissa == nil {
+		return new(big.Rat), nil
+	}
+	return bigFloatToRat(b.Mantissa, b.Exponent)
+}
+
+// NewDecimalFraction returns the shortest decimal fraction that
+// represents exactly the decimal form of f, 273.15 is 27315 * 10^-2
+func NewDecimalFraction(f float32) (DecimalFraction, error) {
+	if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+		return DecimalFraction{}, fmt.Errorf("%v can't be encoded as a decimal fraction", f)
+	}
+	e, m := floatToDecimalFraction(f)
+	return DecimalFraction{Exponent: e, Mantissa: m}, nil
+}
+
+// Float32 returns the nearest float32 value of the decimal fraction,
+// values out of the float32 range are returned as ±Inf or ±0
+func (d DecimalFraction) Float32() float32 {
+	if d.Mantissa == nil {
+		return 0
+	}
+	if d.Mantissa.IsInt64() {
+		return decimalFractionToFloat(d.Mantissa.Int64(), d.Exponent)
+	}
+	// the value is between 10^(digits+Exponent-1) and 10^(digits+Exponent)
+	digits := int64(len(new(big.Int).Abs(d.Mantissa).Text(10)))
+	sign := float64(d.Mantissa.Sign())
+	if d.Exponent > 40-digits {
+		return float32(math.Inf(int(sign)))
+	}
+	if d.Exponent < -46-digits {
+		return float32(math.Copysign(0, sign))
+	}
+	f, _ := decimalFractionToRat(d.Mantissa, d.Exponent).Float32()
+	return f
+}
+
 // CBORMIME
 type CBORMIME struct {
 	ContentType string
@@ -220,31 +387,103 @@ func uint32toFloat16(i uint32) uint16 {
 	}
 }
 
+// returns the bits of f as a float16 and true if
+// f can be represented exactly as a float16 value
+func float32ToExactFloat16(f float32) (uint16, bool) {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exp := int(bits>>23) & 0xff
+	mant := bits & 0x007fffff
+	switch {
+	case exp == 0 && mant == 0: // plus or minus zero
+		return sign, true
+	case exp == 0 || exp == 0xff: // float32 denormals, infinities and NaN
+		return 0, false
+	}
+	e := exp - 127
+	if e > 15 {
+		return 0, false
+	}
+	if e >= -14 {
+		if mant&0x1fff != 0 {
+			return 0, false
+		}
+		return sign | uint16(e+15)<<10 | uint16(mant>>13), true
+	}
+	// float16 denormals, m * 2^-24 with m in [1, 1023]
+	shift := uint(-(e + 1))
+	m := mant | 0x00800000
+	if shift >= 24 || m&(1<<shift-1) != 0 {
+		return 0, false
+	}
+	return sign | uint16(m>>shift), true
+}
+
 // convert a mantissa and an exponent into a float32
 func decimalFractionToFloat(m, e int64) float32 {
+	// math.Pow10 is already +Inf or 0 out of [-400, 400]
+	if m == 0 {
+		return 0
+	} else if e > 400 {
+		e = 400
+	} else if e < -400 {
+		e = -400
+	}
 	be := math.Pow10(int(e))
 	return float32(float64(m) * be)
 }
 
-// convert a float32 to an exponent and a mantissa
-func floatToDecimalFraction(f float32) (int64, int64) {
+// convert a mantissa and an exponent into an exact *big.Rat
+func decimalFractionToRat(m *big.Int, e int64) *big.Rat {
+	if e < 0 {
+		d := new(big.Int).Exp(big.NewInt(10), big.NewInt(-e), nil)
+		return new(big.Rat).SetFrac(m, d)
+	}
+	d := new(big.Int).Exp(big.NewInt(10), big.NewInt(e), nil)
+	return new(big.Rat).SetInt(new(big.Int).Mul(m, d))
+}
+
+// convert a finite float32 to an exponent and a mantissa
+// using its shortest decimal representation
+func floatToDecimalFraction(f float32) (int64, *big.Int) {
 	fs := strconv.FormatFloat(float64(f), 'f', -1, 32)
-	l := len(fs) - (strings.Index(fs, ".") + 1)
-	return int64(l), int64(f * float32(math.Pow10(l)))
+	var e int64
+	if i := strings.IndexByte(fs, '.'); i >= 0 {
+		e = -int64(len(fs) - i - 1)
+		fs = fs[:i] + fs[i+1:]
+	}
+	m, _ := new(big.Int).SetString(fs, 10)
+	return e, m
 }
 
-// convert a mantissa and an exponent into a *big.Rat
-func bigFloatToRatFromInt64(m, e int64) *big.Rat {
-	be := math.Pow(2, float64(e))
-	f := float32(float64(m) * be)
-	r := &big.Rat{}
-	r.SetFloat64(float64(f))
-	return r
+// convert a mantissa and a base 2 exponent into
+// the exact *big.Rat value of m * 2^e
+func bigFloatToRat(m *big.Int, e int64) (*big.Rat, error) {
+	if e > MaxRatExponent || e < -MaxRatExponent {
+		return nil, fmt.Errorf(
+			"big float exponent %d is out of the range [%d, %d]", e, -MaxRatExponent, MaxRatExponent)
+	}
+	if e >= 0 {
+		return new(big.Rat).SetInt(new(big.Int).Lsh(m, uint(e))), nil
+	}
+	d := new(big.Int).Lsh(big.NewInt(1), uint(-e))
+	return new(big.Rat).SetFrac(m, d), nil
 }
 
-// convert a mantissa and an exponent into a *big.Rat from a *big.Int
-func bigFloatToRatFromBigInt(m *big.Int, e int64) *big.Rat {
-	multiplier := big.NewInt(2 * int64(math.Abs(float64(e))))
-	r := &big.Rat{}
-	return r.SetFrac(m, multiplier)
+// convert a *big.Rat into a mantissa and a base 2 exponent, it
+// fails if r is not a finite binary fraction (dyadic rational)
+func ratToBigFloat(r *big.Rat) (*big.Int, int64, error) {
+	d := r.Denom()
+	k := d.TrailingZeroBits()
+	if int(k) != d.BitLen()-1 {
+		return nil, 0, fmt.Errorf(
+			"%s can't be encoded as a big float, it isn't a binary fraction", r.String())
+	}
+	m := new(big.Int).Set(r.Num())
+	if k > 0 || m.Sign() == 0 {
+		return m, -int64(k), nil
+	}
+	// move trailing zeros of integer values into the exponent
+	z := m.TrailingZeroBits()
+	return m.Rsh(m, z), int64(z), nil
 }
@@ -80,6 +80,15 @@ func (c *Composer) composeNil() error {
 	return nil
 }
 
+// Write a single byte into the io.Writer
+// as the break stop code of indefinite items
+func (c *Composer) composeBreak() error {
+	if err := c.write1(cborBreak); err != nil {
+		return fmt.Errorf("while writting break code: %s", err.Error())
+	}
+	return nil
+}
+
 // Handle unsigned integers writing
 func (c *Composer) composeUint(i uint64, infoType ...Major) (n int, err error) {
 	var t Major = cborUnsignedInt
@@ -185,6 +194,21 @@ func (c *Composer) composeBoolean(v bool) error {
 	return nil
 }
 
+// Write one or two bytes into the io.Writer
+// as an encoded CBOR simple value
+func (c *Composer) composeSimple(v Simple) error {
+	if v < 24 {
+		return c.composeInformation(cborNC, byte(v))
+	}
+	if v < 32 {
+		return fmt.Errorf("simple value %d is reserved", v)
+	}
+	if _, err := c.write([]byte{absoluteSimple, byte(v)}); err != nil {
+		return fmt.Errorf("while writting simple value %d: %s", v, err.Error())
+	}
+	return nil
+}
+
 // Write two bytes into the io.Writer
 // as an encoded CBOR float16
 func (c *Composer) composeFloat16(f float16) error {
@@ -230,6 +254,26 @@ func (c *Composer) composeFloat64(f float64) error {
 	return nil
 }
 
+// Write f into the io.Writer as the shortest CBOR float
+// (16, 32 or 64 bits) that keeps its value untouched
+func (c *Composer) composeShortestFloat(f float64) error {
+	switch {
+	case math.IsNaN(f):
+		return c.composeCanonicalNaN()
+	case math.IsInf(f, 0):
+		return c.composeCanonicalInfinity(f < 0)
+	}
+	f32 := float32(f)
+	if float64(f32) != f {
+		return c.composeFloat64(f)
+	}
+	if h, ok := float32ToExactFloat16(f32); ok {
+		_, err := c.write([]byte{absoluteFloat16, byte(h >> 8), byte(h)})
+		return err
+	}
+	return c.composeFloat32(f32)
+}
+
 // Write len(b) + 1 bytes into the
 // io.Writer as a sequence of bytes
 func (c *Composer) composeBytes(b []byte, major ...Major) (err error) {
@@ -237,17 +281,7 @@ func (c *Composer) composeBytes(b []byte, major ...Major) (err error) {
 	if len(major) != 0 {
 		m = major[0]
 	}
-	l := uint(len(b))
-	if l <= 24 {
-		err = c.composeInformation(m, byte(l))
-	} else {
-		info, err := infoHelper(l)
-		if err != nil {
-			return err
-		}
-		err = c.composeInformation(m, info)
-	}
-	if err != nil {
+	if _, err := c.composeUint(uint64(len(b)), m); err != nil {
 		return err
 	}
 	if _, err := c.write(b); err != nil {
@@ -271,9 +305,10 @@ func (c *Composer) composeBigInt(n big.Int) error {
 	if err := c.write1(absoluteNegativeBigNum); err != nil {
 		return err
 	}
-	buf := n.Bytes()
-	buf[len(buf)-1]--
-	return c.composeBytes(buf)
+	// negative big nums are encoded as -1 - n that is the same as |n| - 1
+	m := new(big.Int).Abs(&n)
+	m.Sub(m, big.NewInt(1))
+	return c.composeBytes(m.Bytes())
 }
 
 // Write N bytes into the io.Writer
@@ -286,21 +321,63 @@ func (c *Composer) composeEpochDateTime(t time.Time) error {
 	return err
 }
 
+// Write N bytes into the io.Writer as an encoded CBOR
+// standard datetime string (RFC3339 with nanoseconds)
+func (c *Composer) composeStringDateTime(t time.Time) error {
+	if err := c.write1(absoluteStringDateTime); err != nil {
+		return err
+	}
+	return c.composeString(t.Format(time.RFC3339Nano))
+}
+
+// Write N bytes into the io.Writer
+// as an encoded CBOR Decimal Fraction
+func (c *Composer) composeDecimalFraction(d DecimalFraction) error {
+	if _, err := c.write([]byte{absoluteDecimalFraction, byte(0x82)}); err != nil {
+		return err
+	}
+	if _, err := c.composeInt(d.Exponent); err != nil {
+		return err
+	}
+	return c.composeMantissa(d.Mantissa)
+}
+
+// Write the mantissa of a decimal fraction or a big float, as an
+// integer if it fits into an int64 or as a big num otherwise
+func (c *Composer) composeMantissa(m *big.Int) error {
+	if m == nil {
+		m = new(big.Int)
+	}
+	if m.IsInt64() {
+		_, err := c.composeInt(m.Int64())
+		return err
+	}
+	if m.Sign() < 0 {
+		return c.composeBigInt(*m)
+	}
+	return c.composeBigUint(*m)
+}
+
 // Write N bytes into the io.Writer
 // as an encoded CBOR Big Float
 func (c *Composer) composeBigFloat(r big.Rat) error {
-	if _, err := c.write([]byte{absoluteBigFloat, byte(0x82)}); err != nil {
+	m, e, err := ratToBigFloat(&r)
+	if err != nil {
 		return err
 	}
-	f, _ := r.Float64()
-	m, e := math.Frexp(f)
-	if _, err := c.composeInt(int64(e)); err != nil {
+	return c.composeExactBigFloat(BigFloat{Exponent: e, Mantissa: m})
+}
+
+// Write N bytes into the io.Writer as an encoded CBOR
+// Big Float keeping its mantissa and exponent unchanged
+func (c *Composer) composeExactBigFloat(b BigFloat) error {
+	if _, err := c.write([]byte{absoluteBigFloat, byte(0x82)}); err != nil {
 		return err
 	}
-	if err := c.composeFloat64(m); err != nil {
+	if _, err := c.composeInt(b.Exponent); err != nil {
 		return err
 	}
-	return nil
+	return c.composeMantissa(b.Mantissa)
 }
 
 // Write len(s) + 1 bytes into the
@@ -374,20 +451,3 @@ func (c *Composer) composeCanonicalInfinity(neg ...bool) error {
 	}
 	return nil
 }
-
-// get the info code depending of the size of l
-func infoHelper(l uint) (byte, error) {
-	var info byte
-	if l <= math.MaxUint8 {
-		info = cborUint8
-	} else if l <= math.MaxUint16 {
-		info = cborUint16
-	} else if l <= math.MaxUint32 {
-		info = cborUint32
-	} else if l <= math.MaxUint64 {
-		info = cborUint64
-	} else {
-		return 0, fmt.Errorf("totally unexpected error, []byte buf length size is unkwnown %v!", l)
-	}
-	return info, nil
-}
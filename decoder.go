@@ -16,18 +16,51 @@
 package cbor
 
 import (
+	"bytes"
+	"encoding"
 	"encoding/base64"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"math/big"
 	"mime"
+	"net"
+	"net/netip"
 	"net/url"
 	"reflect"
 	"regexp"
 	"time"
 )
 
+// types that are decoded from semantic tags
+var (
+	typeBigInt   = reflect.TypeOf(big.Int{})
+	typeBigRat   = reflect.TypeOf(big.Rat{})
+	typeBigFloat = reflect.TypeOf(big.Float{})
+	typeTime     = reflect.TypeOf(time.Time{})
+	typeFloat32  = reflect.TypeOf(float32(0))
+	typeSimple   = reflect.TypeOf(Simple(0))
+	typeRaw      = reflect.TypeOf(RawMessage{})
+
+	typeBase64URLBytes = reflect.TypeOf(Base64URLBytes{})
+	typeBase64Bytes    = reflect.TypeOf(Base64Bytes{})
+	typeBase16Bytes    = reflect.TypeOf(Base16Bytes{})
+
+	typeAddr     = reflect.TypeOf(netip.Addr{})
+	typePrefix   = reflect.TypeOf(netip.Prefix{})
+	typeIP       = reflect.TypeOf(net.IP{})
+	typeDuration = reflect.TypeOf(time.Duration(0))
+
+	typeInterface = reflect.TypeOf((*interface{})(nil)).Elem()
+
+	typeTextUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+	typeDecimalFraction = reflect.TypeOf(DecimalFraction{})
+	typeExactBigFloat   = reflect.TypeOf(BigFloat{})
+)
+
 // Type of function that handles decoding of extensions
 type handleDecFn func(*Decoder, reflect.Value) error
 
@@ -68,39 +101,315 @@ func LookupExtensionFn(t reflect.Type) (handleDecFn, error) {
 	return extensionsDec.lookup(t)
 }
 
+// default maximum nesting level of containers for a Decoder
+const defaultMaxDepth = 256
+
+// default maximum size in bytes of a frame read by DecodeFramed
+const defaultMaxFrameSize = 16 << 20
+
+// Go types registered by the user to decode data items of a given major
+type majorTypesMap map[Major]reflect.Type
+
+// global major types register
+var majorTypesDec majorTypesMap = make(majorTypesMap)
+
+// Registers the Go type of proto as the type to use when a data item of
+// the given major is decoded into an empty interface, it is only used by
+// decoders that has been configured with the WithMajorTypes option
+func RegisterMajorType(major Major, proto interface{}) error {
+	if _, ok := majorTypesDec[major]; ok {
+		return fmt.Errorf("%s major is already registered\n", major)
+	}
+	majorTypesDec[major] = reflect.TypeOf(proto)
+	return nil
+}
+
 // A Decoder reads and decode CBOR objects from an input stream.
 type Decoder struct {
-	parser *Parser
-	strict bool
+	parser      *Parser
+	strict      bool
+	depth       int // current nesting level of containers
+	maxDepth    int
+	maxFrame    int            // maximum size of a frame read by DecodeFramed
+	unwrap      bool           // unwrap any leading semantic tag
+	clearMaps   bool           // clear non nil maps before decode into them
+	leapSeconds bool           // accept leap seconds in RFC3339 date times
+	majorTypes  bool           // use the registered major types for interfaces
+	intsAsInt64 bool           // blind decode integers as int64 (or uint64)
+	wideInts    bool           // blind decode integers as uint64 and int64
+	location    *time.Location // location of the decoded date times
+	intBools    bool           // accept 0 and 1 integers as booleans
+	noUnknown   bool           // fail on map keys that don't match any struct field
+	intFloats   bool           // accept integers as floating point numbers
+	exactlyOne  bool           // fail if there is data after the decoded item
+	keepOnUndef bool           // undefined leaves pointers to scalars untouched
+	jsonTags    bool           // use json tags for fields without cbor tags
+	exactFloats bool           // blind decode big floats as BigFloat
+	scalarSlice bool           // decode scalars into slices as one element
+
+	bytesAsString bool // blind decode UTF-8 valid byte strings as strings
+	mergeStructs  bool // decode structs over their current value
+
+	// keys found for the fields of the decoded struct
+	presence map[string]bool
+
+	continueOnError bool
+	errs            *[]error // errors recorded when continuing on errors
+	path            []string // fields and indexes of the item being decoded
+
+	// hooks called before and after decoding each struct field
+	beforeField, afterField func(field string, v reflect.Value)
 }
 
 // NewDecoder returns a new decoder that reads from r.
 func NewDecoder(r io.Reader, options ...func(*Decoder)) *Decoder {
-	d := &Decoder{parser: &Parser{r: r}, strict: false}
+	d := &Decoder{
+		parser: &Parser{r: r}, strict: false, maxDepth: defaultMaxDepth, location: time.UTC,
+		maxFrame: defaultMaxFrameSize,
+	}
 	if len(options) > 0 {
 		for _, option := range options {
 			option(d)
 		}
 	}
+	d.parser.maxDepth = d.maxDepth
 	return d
 }
 
+// Reset makes the decoder to read from r discarding any buffered
+// data and its transient state, options are kept so a single decoder
+// can be reused
+func (dec *Decoder) Reset(r io.Reader) {
+	*dec.parser = Parser{r: r, maxDepth: dec.maxDepth}
+	dec.depth = 0
+	dec.errs = nil
+	dec.presence = nil
+}
+
+// MaxDepth sets the maximum nesting level of arrays, maps and
+// structs that the decoder walks before giving up with an error,
+// a value of zero or less disables the check
+func MaxDepth(n int) func(*Decoder) {
+	return func(dec *Decoder) {
+		dec.maxDepth = n
+	}
+}
+
+// MaxFrameSize sets the maximum size in bytes of a frame read by
+// DecodeFramed, larger length prefixes are rejected before reading
+// the frame, a value of zero or less disables the check
+func MaxFrameSize(n int) func(*Decoder) {
+	return func(dec *Decoder) {
+		dec.maxFrame = n
+	}
+}
+
+// WithTagUnwrap makes the decoder to skip any leading semantic tag
+// when the destination type doesn't know how to process it, by
+// default only tags that are pure wrappers (like self-describe)
+// are skipped
+func WithTagUnwrap() func(*Decoder) {
+	return func(dec *Decoder) {
+		dec.unwrap = true
+	}
+}
+
+// WithMapClear makes the decoder to remove all the existing entries
+// from non nil maps before decoding into them instead of merging
+// the decoded entries with the existing ones
+func WithMapClear() func(*Decoder) {
+	return func(dec *Decoder) {
+		dec.clearMaps = true
+	}
+}
+
+// WithLeapSeconds makes the decoder to accept RFC3339 date times with
+// a leap second (:60) mapping them to the first second of next minute
+func WithLeapSeconds() func(*Decoder) {
+	return func(dec *Decoder) {
+		dec.leapSeconds = true
+	}
+}
+
+// WithMajorTypes makes the decoder to use the types registered with
+// RegisterMajorType when decoding data items into nil interfaces
+func WithMajorTypes() func(*Decoder) {
+	return func(dec *Decoder) {
+		dec.majorTypes = true
+	}
+}
+
+// BlindIntsAsInt64 makes the decoder to decode every integer into empty
+// interfaces as an int64, or an uint64 if the value doesn't fit in it,
+// instead of using the smallest type that holds the encoded value
+func BlindIntsAsInt64() func(*Decoder) {
+	return func(dec *Decoder) {
+		dec.intsAsInt64 = true
+	}
+}
+
+// WideInts makes the decoder to decode every unsigned integer into
+// empty interfaces as an uint64 and every negative one as an int64,
+// instead of using the smallest type that holds the encoded value
+func WideInts(dec *Decoder) {
+	dec.wideInts = true
+}
+
+// WithLocation sets the location of the date times decoded
+// from both string and epoch based tags, it defaults to UTC
+func WithLocation(loc *time.Location) func(*Decoder) {
+	return func(dec *Decoder) {
+		dec.location = loc
+	}
+}
+
+// ContinueOnError makes the decoder to record the errors found while
+// decoding struct fields, map values and slice elements and continue
+// decoding the rest of them, the recorded errors are returned at the
+// end of the Decode operation as DecodeErrors
+func ContinueOnError(dec *Decoder) {
+	dec.continueOnError = true
+}
+
+// RejectUnknownFields makes the decoder to fail when a map key doesn't
+// match with any field of the destination struct, without enabling the
+// rest of the strict mode checks (duplicated keys and lengths)
+func RejectUnknownFields(dec *Decoder) {
+	dec.noUnknown = true
+}
+
+// BytesAsString makes the decoder to decode byte strings that are valid
+// UTF-8 as strings (instead of []byte) when decoding into interfaces
+func BytesAsString() func(*Decoder) {
+	return func(dec *Decoder) {
+		dec.bytesAsString = true
+	}
+}
+
+// LenientBools makes the decoder to accept the 0 and 1 unsigned integers
+// as booleans, as written by the encoder with the BoolsAsInt option
+func LenientBools() func(*Decoder) {
+	return func(dec *Decoder) {
+		dec.intBools = true
+	}
+}
+
+// WithLenientNumbers makes the decoder to accept integers when decoding
+// into float32 and float64, integers that can't be represented exactly
+// by the destination type are reported as errors
+func WithLenientNumbers() func(*Decoder) {
+	return func(dec *Decoder) {
+		dec.intFloats = true
+	}
+}
+
+// WithExactBigFloats makes the decoder to decode big floats into
+// empty interfaces as BigFloat values instead of *big.Rat ones,
+// keeping the mantissa and the exponent as they were encoded
+func WithExactBigFloats() func(*Decoder) {
+	return func(dec *Decoder) {
+		dec.exactFloats = true
+	}
+}
+
+// WithScalarToSlice makes the decoder to accept single values when
+// decoding into slices, a value that is not an array is decoded as
+// the only element of the slice, for producers that emit either a
+// value or an array of them for the same field
+func WithScalarToSlice() func(*Decoder) {
+	return func(dec *Decoder) {
+		dec.scalarSlice = true
+	}
+}
+
+// IgnoreUndefined makes the decoder to leave pointers to scalar values
+// untouched when it finds an undefined value, null still sets them to
+// nil so together with true and false a *bool can express three states.
+// Structs are still reset before decoding into them, use it together
+// with WithStructMerge to keep the current value of struct fields
+func IgnoreUndefined() func(*Decoder) {
+	return func(dec *Decoder) {
+		dec.keepOnUndef = true
+	}
+}
+
+// WithStructMerge makes the decoder to decode structs over their current
+// value instead of resetting them first, so fields that are missing from
+// the encoded map or array keep their values
+func WithStructMerge() func(*Decoder) {
+	return func(dec *Decoder) {
+		dec.mergeStructs = true
+	}
+}
+
+// WithJSONTagFallback makes the decoder to match map keys with the
+// json tag of the struct fields that don't have a cbor tag, use the
+// EncodeJSONTagFallback option to encode them with the same names
+func WithJSONTagFallback() func(*Decoder) {
+	return func(dec *Decoder) {
+		dec.jsonTags = true
+	}
+}
+
+// ExactlyOne makes the decoder to fail when the input contains any
+// data after the decoded item, for protocols that require a buffer
+// to contain exactly one data item
+func ExactlyOne(dec *Decoder) {
+	dec.exactlyOne = true
+}
+
+// WithFieldHook sets functions that are called with the name and the
+// value of every struct field right before and after it is decoded,
+// any of them can be nil
+func WithFieldHook(before, after func(field string, v reflect.Value)) func(*Decoder) {
+	return func(dec *Decoder) {
+		dec.beforeField = before
+		dec.afterField = after
+	}
+}
+
 // Decode reads the next CBOR-encoded value from its
 // input and stores it in the value pointed to by v.
-// It also checks for the well-formedness of the 'data item'
+// It also checks for the well-formedness of the 'data item'.
+// It returns io.EOF when there are no more items in the input, running
+// out of input in the middle of an item is reported as a parse error.
+// Any other error is a *DecodeError wrapping the underlying error
 func (dec *Decoder) Decode(v interface{}) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			err = r.(error)
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = errors.New(fmt.Sprint(r))
+			}
+		}
+		if err == nil && dec.exactlyOne && dec.parser.more() {
+			err = NewParseErr("unexpected trailing data after the decoded item")
 		}
+		if err == nil && dec.errs != nil && len(*dec.errs) > 0 {
+			err = DecodeErrors(*dec.errs)
+		}
+		err = dec.positionError(err)
 	}()
 
+	dec.depth = 0
+	dec.parser.startItem()
+	if dec.continueOnError {
+		dec.errs = new([]error)
+	}
 	var info byte
 	var major Major
 	major, info, err = dec.parser.parseInformation()
 	if err != nil {
 		return err
 	}
+	t := reflect.TypeOf(v)
+	if rv, ok := v.(reflect.Value); ok {
+		t = rv.Type()
+	}
+	if major, info, err = dec.unwrapTags(t, major, info); err != nil {
+		return err
+	}
 	if err = dec.checkTypes(reflect.TypeOf(v), major, info); err != nil {
 		return err
 	}
@@ -126,39 +435,46 @@ func (dec *Decoder) Decode(v interface{}) (err error) {
 	case *float16:
 		*t = dec.decodeFloat16()
 	case *float32:
-		if major == cborNC {
+		switch major {
+		case cborNC:
 			*t = dec.decodeFloat32()
-		} else {
-			*t = dec.decodeDecimalFraction()
+		case cborUnsignedInt, cborNegativeInt:
+			return dec.decodekFloat32(reflect.ValueOf(t).Elem())
+		default:
+			*t = dec.decodeDecimalFraction().Float32()
 		}
+	case *DecimalFraction:
+		*t = dec.decodeDecimalFraction()
 	case *float64:
+		if major != cborNC {
+			return dec.decodekFloat64(reflect.ValueOf(t).Elem())
+		}
 		*t = dec.decodeFloat64()
 	case *big.Int:
-		if v.(*big.Int).Sign() < 0 {
-			n := dec.decodeNegativeBigNum()
-			*t = *n.Neg(n)
-		} else {
-			n := dec.decodePositiveBigNum()
-			*t = *n
-		}
+		*t = *dec.decodeBigInt()
 	case *time.Time:
-		func() {
-			defer func() {
-				if r := recover(); r != nil {
-					*t = dec.decodeEpochDateTime(struct{}{})
-				}
-			}()
-			*t = dec.decodeStringDateTime()
-		}()
+		*t = dec.decodeTime()
 	case *big.Rat:
 		n := dec.decodeBigFloat()
 		*t = *n
+	case *big.Float:
+		*t = *dec.decodeBigFloatValue()
+	case *BigFloat:
+		*t = dec.decodeExactBigFloat()
 	case *[]byte:
+		if major == cborDataArray || major == cborDataMap || major == cborTag {
+			// arrays of small integers are decoded element by element
+			return dec.decodekSlice(reflect.ValueOf(t).Elem())
+		}
 		*t = dec.decodeBytes()
 	case *string:
 		*t = dec.decodeString()
 	case *bool:
 		*t = dec.decodeBool()
+	case *Simple:
+		*t = dec.decodeSimple()
+	case *RawMessage:
+		*t = dec.decodeRawMessage()
 	case *interface{}:
 		return dec.decode(reflect.ValueOf(v).Elem())
 	case reflect.Value:
@@ -174,11 +490,126 @@ func (dec *Decoder) Decode(v interface{}) (err error) {
 	return err
 }
 
+// More reports whether there is another data item to decode in the input,
+// it returns false when the input has been consumed (clean EOF), errors
+// found while checking it are reported by the next call to Decode
+func (dec *Decoder) More() bool {
+	return dec.parser.more()
+}
+
+// DecodeWithPresence works like Decode but it also returns the keys
+// of the decoded struct that matched any of its fields, so a field that
+// is explicitly set to its zero value can be told apart from one that
+// is missing, only the keys of the outermost struct are reported
+func (dec *Decoder) DecodeWithPresence(v interface{}) (map[string]bool, error) {
+	present := map[string]bool{}
+	dec.presence = present
+	defer func() { dec.presence = nil }()
+	if err := dec.Decode(v); err != nil {
+		return nil, err
+	}
+	return present, nil
+}
+
+// DecodeValue reads the next CBOR-encoded value from its input and
+// returns it back decoded as it would be into an empty interface
+func (dec *Decoder) DecodeValue() (interface{}, error) {
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// DecodeFramed reads a frame written by EncodeFramed, a four bytes
+// big-endian length followed by that many bytes, and decodes it into
+// v, the frame must contain exactly one data item. It returns io.EOF
+// when there are no more frames in the input. Frames larger than the
+// size set with MaxFrameSize (16 MiB by default) are rejected
+func (dec *Decoder) DecodeFramed(v interface{}) error {
+	dec.parser.startItem()
+	_, prefix, err := dec.parser.scan(4)
+	if err != nil {
+		return err
+	}
+	size := int64(binary.BigEndian.Uint32(prefix))
+	if dec.maxFrame > 0 && size > int64(dec.maxFrame) {
+		return NewParseErr(fmt.Sprintf(
+			"frame of %d bytes exceeds the maximum frame size of %d", size, dec.maxFrame))
+	}
+	_, data, err := dec.parser.scan(int(size))
+	if err != nil {
+		return err
+	}
+	frame := *dec
+	frame.parser = NewParser(bytes.NewReader(data))
+	frame.parser.maxDepth = dec.maxDepth
+	frame.exactlyOne = true
+	if err := frame.Decode(v); err != nil {
+		if err == io.EOF {
+			return NewParseErr("empty frame")
+		}
+		return err
+	}
+	return nil
+}
+
+// InputOffset returns the number of bytes of the input
+// consumed by the decoder so far
+func (dec *Decoder) InputOffset() int64 {
+	return dec.parser.pos
+}
+
+// adds the offset of the data item being decoded to err, the end
+// of the input (io.EOF) and errors of every item are not modified
+func (dec *Decoder) positionError(err error) error {
+	switch err.(type) {
+	case nil, *DecodeError, DecodeErrors, *InvalidDecodeError:
+		return err
+	}
+	if err == io.EOF {
+		return err
+	}
+	return &DecodeError{Offset: dec.parser.itemPos, Err: err}
+}
+
+// DecodeField works like Decode but it also reports whether a value was
+// present, a CBOR null or undefined item is consumed leaving v zeroed and
+// present as false, so callers can tell a missing value from a zero value
+func (dec *Decoder) DecodeField(v interface{}) (present bool, err error) {
+	if !dec.parser.more() || len(dec.parser.peeked) == 0 {
+		return false, dec.Decode(v)
+	}
+	if h := dec.parser.peeked[0]; h != absoluteNil && h != absoluteUndef {
+		return true, dec.Decode(v)
+	}
+	if _, _, err = dec.parser.parseInformation(); err != nil {
+		return false, err
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+	}
+	return false, nil
+}
+
+// Skip reads the next CBOR-encoded value from its input and discards it
+func (dec *Decoder) Skip() error {
+	dec.parser.startItem()
+	if _, _, err := dec.parser.parseInformation(); err != nil {
+		return err
+	}
+	return dec.parser.skip()
+}
+
 // decode is being used when the type of the receiver of the decode
 // operation is a slice, a map an interface or any type of custom type
 func (dec *Decoder) decode(rv reflect.Value) (err error) {
 	// Decode nil and undef into zero values
-	if dec.parser.isNil() || dec.parser.isUndef() {
+	raw := rv.IsValid() && rv.Type() == typeRaw
+	if !raw && (dec.parser.isNil() || dec.parser.isUndef()) {
+		if dec.keepOnUndef && dec.parser.isUndef() && rv.IsValid() && isScalarPtr(rv.Type()) {
+			return nil
+		}
 		if rv.Kind() == reflect.Ptr {
 			if !rv.IsNil() {
 				rv.Set(reflect.Zero(rv.Type()))
@@ -190,6 +621,12 @@ func (dec *Decoder) decode(rv reflect.Value) (err error) {
 		}
 		return nil
 	}
+	// text strings are decoded by types that know how to unmarshal them
+	if major, _ := dec.parser.parseHeader(); major == cborTextString && rv.CanAddr() {
+		if u, ok := rv.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return u.UnmarshalText([]byte(dec.decodeString()))
+		}
+	}
 	var handler handleDecFn
 	handler, err = dec.lookupFn(rv)
 	if err != nil {
@@ -203,8 +640,82 @@ func (dec *Decoder) decode(rv reflect.Value) (err error) {
 	return handler(dec, rv)
 }
 
+// returns true if t is a pointer to a boolean, number or string
+func isScalarPtr(t reflect.Type) bool {
+	if t.Kind() != reflect.Ptr {
+		return false
+	}
+	switch t.Elem().Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// skips leading semantic tags that are pure wrappers of the next data
+// item, or any other tag if the decoder is configured to unwrap them
+// and the destination type t doesn't know how to process the tag
+func (dec *Decoder) unwrapTags(t reflect.Type, major Major, info byte) (Major, byte, error) {
+	var err error
+	for major == cborTag {
+		off := dec.parser.off
+		tag := dec.parser.buflen()
+		if tag != cborSelfDescribe && (!dec.unwrap || isTagAware(t)) {
+			dec.parser.off = off // leave the tag number to the decoder
+			break
+		}
+		if major, info, err = dec.parser.parseInformation(); err != nil {
+			return major, info, err
+		}
+	}
+	return major, info, nil
+}
+
+// returns true if the given destination type is able to process a tag
+func isTagAware(t reflect.Type) bool {
+	if t == nil || t.Kind() != reflect.Ptr {
+		return true
+	}
+	switch t.Elem() {
+	case typeBigInt, typeBigRat, typeBigFloat, typeTime, typeFloat32, typeDecimalFraction, typeRaw,
+		typeBase64URLBytes, typeBase64Bytes, typeBase16Bytes, typeExactBigFloat, typeAddr, typePrefix, typeIP:
+		return true
+	}
+	return t.Elem().Kind() == reflect.Interface
+}
+
 // lookup for decode function based on type Kind
 func (dec *Decoder) lookupFn(rv reflect.Value) (handler handleDecFn, e error) {
+	// types that are decoded from semantic tags
+	switch rv.Type() {
+	case typeBigInt:
+		return (*Decoder).decodekBigInt, nil
+	case typeBigRat:
+		return (*Decoder).decodekBigRat, nil
+	case typeBigFloat:
+		return (*Decoder).decodekBigFloat, nil
+	case typeTime:
+		return (*Decoder).decodekTime, nil
+	case typeDecimalFraction:
+		return (*Decoder).decodekDecimalFraction, nil
+	case typeExactBigFloat:
+		return (*Decoder).decodekExactBigFloat, nil
+	case typeRaw:
+		return (*Decoder).decodekRawMessage, nil
+	case typeBase64URLBytes, typeBase64Bytes, typeBase16Bytes:
+		return (*Decoder).decodekExpectedBase, nil
+	case typeAddr:
+		return (*Decoder).decodekAddr, nil
+	case typePrefix:
+		return (*Decoder).decodekPrefix, nil
+	case typeIP:
+		return (*Decoder).decodekIP, nil
+	case typeDuration:
+		return (*Decoder).decodekDuration, nil
+	}
 	rk := rv.Kind()
 	switch rk {
 	case reflect.Map:
@@ -245,6 +756,10 @@ func (dec *Decoder) lookupFn(rv reflect.Value) (handler handleDecFn, e error) {
 		handler = (*Decoder).decodekSlice
 	case reflect.Array:
 		handler = (*Decoder).decodekArray
+	case reflect.Ptr:
+		if handler, e = LookupExtensionFn(rv.Type()); e != nil {
+			handler, e = (*Decoder).decodekPtr, nil
+		}
 	default:
 		handler, e = LookupExtensionFn(rv.Type())
 	}
@@ -257,13 +772,44 @@ func (dec *Decoder) checkTypes(t reflect.Type, major Major, info byte) error {
 	if major == cborTag || major == cborDataArray || major == cborDataMap || t == reflect.TypeOf(reflect.Value{}) {
 		return nil
 	}
+	if major == cborNC && t == reflect.PtrTo(typeSimple) || t == reflect.PtrTo(typeRaw) {
+		return nil
+	}
+	// empty interfaces are decoded blindly so they accept any type
+	if t == reflect.PtrTo(typeInterface) {
+		return nil
+	}
+	if major == cborTextString && t != nil && t.Implements(typeTextUnmarshaler) {
+		return nil
+	}
+	if major == cborByteString && (t == reflect.PtrTo(typeAddr) || t == reflect.PtrTo(typeIP)) {
+		return nil
+	}
+	if major == cborByteString && t != nil && t.Kind() == reflect.Ptr &&
+		t.Elem().Kind() == reflect.Array && t.Elem().Elem().Kind() == reflect.Uint8 {
+		return nil
+	}
+	if (major == cborUnsignedInt || major == cborNegativeInt) && t == reflect.PtrTo(typeDuration) {
+		return nil
+	}
+	if dec.scalarSlice && t != nil && t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Slice &&
+		t != reflect.TypeOf(new([]byte)) && !(major == cborNC && (info == cborNil || info == cborUndef)) {
+		return nil
+	}
+	if major == cborUnsignedInt && dec.intBools && t == reflect.TypeOf(new(bool)) {
+		return nil
+	}
+	if (major == cborUnsignedInt || major == cborNegativeInt) && dec.intFloats &&
+		(t == reflect.TypeOf(new(float32)) || t == reflect.TypeOf(new(float64))) {
+		return nil
+	}
 	msg := "expected %s, got %s (major %d, info %d [%#v])\n"
 	e, ok := expectedTypesMap[major][info]
 	if !ok {
 		switch major {
 		case cborUnsignedInt:
 			if info <= cborSmallInt {
-				e = reflect.PtrTo(reflect.TypeOf(uint8(0)))
+				e = reflect.TypeOf(uint8(0))
 				break
 			}
 			return errors.New(fmt.Sprintf("Unknown info %d for major 1", info))
@@ -366,6 +912,18 @@ func (dec *Decoder) decodeFloat64() float64 {
 	return dec.parser.parseFloat64()
 }
 
+// Decode a string or an epoch based date time depending on the tag,
+// untagged data items are decoded as epoch based date times
+func (dec *Decoder) decodeTime() time.Time {
+	switch dec.parser.header {
+	case absoluteStringDateTime:
+		return dec.decodeStringDateTime()
+	case absoluteEpochDateTime:
+		return dec.decodeEpochDateTime()
+	}
+	return dec.decodeEpochDateTime(struct{}{})
+}
+
 // Decode a string date representation
 // that follows the standard format defined in
 // RFC3339 with RFC4287 Section 3.3 additions
@@ -376,9 +934,27 @@ func (dec *Decoder) decodeStringDateTime() time.Time {
 	if major != cborTextString {
 		panic(fmt.Errorf("expected UTF-8 string, found %v", major))
 	}
-	t, err := time.Parse(time.RFC3339, dec.decodeString())
+	t, err := parseDateTime(dec.decodeString(), dec.leapSeconds)
 	checkErr(err)
-	return t
+	return t.In(dec.location)
+}
+
+// parses an RFC3339 date time string, time.Parse rejects leap seconds
+// so if leap is true a 60 seconds value is mapped to the next second
+func parseDateTime(s string, leap bool) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err == nil || len(s) < 19 || s[16] != ':' || s[17:19] != "60" {
+		return t, err
+	}
+	if !leap {
+		return t, fmt.Errorf(
+			"leap second in date time %s is not supported, use WithLeapSeconds to accept it", s)
+	}
+	t, err = time.Parse(time.RFC3339, s[:17]+"59"+s[19:])
+	if err != nil {
+		return t, err
+	}
+	return t.Add(time.Second), nil
 }
 
 // Decode a positive or negative
@@ -402,21 +978,28 @@ func (dec *Decoder) decodeEpochDateTime(parseInforamtion ...struct{}) time.Time
 	default:
 		switch dec.parser.header {
 		case absoluteFloat16:
-			n = int64(int(dec.decodeFloat16()))
+			return floatToTime(float64(dec.decodeFloat16())).In(dec.location)
 		case absoluteFloat32:
-			n = int64(int(dec.decodeFloat32()))
+			return floatToTime(float64(dec.decodeFloat32())).In(dec.location)
 		case absoluteFloat64:
-			n = int64(int(dec.decodeFloat64()))
+			return floatToTime(dec.decodeFloat64()).In(dec.location)
 		default:
 			panic(fmt.Errorf("can't decode Epoch timestamp %v", major))
 		}
 	}
-	return time.Unix(n, int64(0))
+	return time.Unix(n, int64(0)).In(dec.location)
+}
+
+// convert fractional seconds since the epoch into a time.Time
+func floatToTime(f float64) time.Time {
+	sec := math.Floor(f)
+	nsec := math.Round((f - sec) * 1e9)
+	return time.Unix(int64(sec), int64(nsec))
 }
 
 // Decode a decimal fraction as defined in Section 2.4.3 of RFC7049
 // http://tools.ietf.org/html/rfc7049#section-2.4.3
-func (dec *Decoder) decodeDecimalFraction() float32 {
+func (dec *Decoder) decodeDecimalFraction() DecimalFraction {
 	major, _, err := dec.parser.parseInformation()
 	checkErr(err)
 	if major != cborDataArray {
@@ -428,24 +1011,57 @@ func (dec *Decoder) decodeDecimalFraction() float32 {
 	if major > cborNegativeInt {
 		panic(fmt.Errorf("Can't decode %s as decimal fraction exponent", major))
 	}
-	e := dec.decodeInt()
+	e := dec.decodeExponent(major)
 	major, _, err = dec.parser.parseInformation()
 	checkErr(err)
-	if major > cborNegativeInt {
+	if major > cborNegativeInt && dec.parser.header != absolutePositiveBigNum &&
+		dec.parser.header != absoluteNegativeBigNum {
 		panic(fmt.Errorf("Can't decode %s as decimal fraction mantissa", major))
 	}
-	var m int64
+	return DecimalFraction{Exponent: e, Mantissa: dec.decodeBigInt()}
+}
+
+// Decode the exponent of a decimal fraction or a big float, it
+// fails if the exponent doesn't fit in an int64
+func (dec *Decoder) decodeExponent(major Major) int64 {
+	n := dec.parser.buflen()
+	if n > math.MaxInt64 {
+		if major == cborNegativeInt {
+			panic(fmt.Errorf("exponent -1-%d overflows int64", n))
+		}
+		panic(fmt.Errorf("exponent %d overflows int64", n))
+	}
 	if major == cborUnsignedInt {
-		m = int64(dec.decodeUint())
-	} else {
-		m = dec.decodeInt()
+		return int64(n)
 	}
-	return decimalFractionToFloat(m, e)
+	return ^int64(n)
 }
 
 // Decode a big float a defined in Section 2.3.4 of RFC7049
 // http://tools.ietf.org/html/rfc7049#section-2.4.3
 func (dec *Decoder) decodeBigFloat() *big.Rat {
+	r, err := bigFloatToRat(dec.decodeBigFloatMantExp())
+	checkErr(err)
+	return r
+}
+
+// Decode a big float into a big.Float, the mantissa and the
+// binary exponent are set exactly using as much precision as
+// the mantissa needs
+func (dec *Decoder) decodeBigFloatValue() *big.Float {
+	m, e := dec.decodeBigFloatMantExp()
+	f := new(big.Float).SetInt(m)
+	return f.SetMantExp(f, int(e))
+}
+
+// Decode a big float keeping its mantissa and binary exponent
+func (dec *Decoder) decodeExactBigFloat() BigFloat {
+	m, e := dec.decodeBigFloatMantExp()
+	return BigFloat{Exponent: e, Mantissa: m}
+}
+
+// Decode the mantissa and the binary exponent of a big float
+func (dec *Decoder) decodeBigFloatMantExp() (*big.Int, int64) {
 	major, _, err := dec.parser.parseInformation()
 	checkErr(err)
 	if major != cborDataArray {
@@ -457,24 +1073,35 @@ func (dec *Decoder) decodeBigFloat() *big.Rat {
 	if major > cborNegativeInt {
 		panic(fmt.Errorf("Can't decode %s as decimal fraction exponent", major))
 	}
-	e := dec.decodeInt()
-	major, info, err := dec.parser.parseInformation()
+	e := dec.decodeExponent(major)
+	major, _, err = dec.parser.parseInformation()
 	checkErr(err)
-	if major > cborNegativeInt && (major != cborTag && info != cborBigNum) {
+	if major > cborNegativeInt && dec.parser.header != absolutePositiveBigNum &&
+		dec.parser.header != absoluteNegativeBigNum {
 		panic(fmt.Errorf("Can't decode %s as decimal fraction mantissa", major))
 	}
+	return dec.decodeBigInt(), e
+}
+
+// Decode a positive or negative big num depending on the tag,
+// untagged integers are decoded into a big.Int as well
+func (dec *Decoder) decodeBigInt() *big.Int {
+	switch dec.parser.header {
+	case absolutePositiveBigNum:
+		return dec.decodePositiveBigNum()
+	case absoluteNegativeBigNum:
+		n := dec.decodeNegativeBigNum()
+		return n.Neg(n)
+	}
+	major, _ := dec.parser.parseHeader()
 	switch major {
 	case cborUnsignedInt:
-		m := int64(dec.decodeUint())
-		return bigFloatToRatFromInt64(m, e)
+		return new(big.Int).SetUint64(dec.decodeUint())
 	case cborNegativeInt:
-		m := int64(dec.decodeInt())
-		return bigFloatToRatFromInt64(m, e)
-	case cborTag:
-		m := dec.decodePositiveBigNum()
-		return bigFloatToRatFromBigInt(m, e)
+		n := new(big.Int).SetUint64(dec.decodeUint())
+		return n.Sub(n.Neg(n), big.NewInt(1))
 	}
-	return big.NewRat(0, 0)
+	panic(fmt.Errorf("can't decode %s as big num", major))
 }
 
 // Decode positive big num
@@ -632,7 +1259,9 @@ func (dec *Decoder) decodeBytes() []byte {
 	}
 
 	if info != cborIndefinite {
-		_, d, err := dec.parser.scan(int(dec.parser.buflen()))
+		n, err := dec.parser.length()
+		checkErr(err)
+		_, d, err := dec.parser.scan(n)
 		checkErr(err)
 		return d
 	}
@@ -666,8 +1295,39 @@ func (dec *Decoder) decodeIndefiniteBytes(buf []byte) []byte {
 	return buf
 }
 
+// Decode a simple value
+func (dec *Decoder) decodeSimple() Simple {
+	info := dec.parser.header & 0x1f
+	if info < cborFalse {
+		return Simple(info)
+	}
+	if info == cborSimple {
+		return Simple(dec.parser.parseUint8())
+	}
+	panic(fmt.Errorf("can't decode 0x%x as simple value", dec.parser.header))
+}
+
+// Decode the raw bytes of the next data item
+func (dec *Decoder) decodeRawMessage() RawMessage {
+	raw, err := dec.parser.raw()
+	if err != nil {
+		panic(err)
+	}
+	return raw
+}
+
 // Decode into a boolean value
 func (dec *Decoder) decodeBool() bool {
+	if major, _ := dec.parser.parseHeader(); major == cborUnsignedInt && dec.intBools {
+		switch n := dec.parser.buflen(); n {
+		case 0:
+			return false
+		case 1:
+			return true
+		default:
+			panic(fmt.Errorf("can't decode %d as a boolean", n))
+		}
+	}
 	return dec.parser.parseBool()
 }
 
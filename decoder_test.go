@@ -17,9 +17,13 @@ package cbor
 
 import (
 	"bytes"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"net/url"
 
 	"math/big"
@@ -27,7 +31,10 @@ import (
 	"os"
 	"reflect"
 	"regexp"
+	"strconv"
+	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -239,6 +246,14 @@ func TestDecodeBytes(t *testing.T) {
 	var a []byte
 	check(d.Decode(&a))
 	expect("bytes string", string(a), t)
+
+	// arrays of integers are decoded element by element
+	for _, in := range [][]byte{{0x83, 0x01, 0x18, 0xff, 0x03}, {0x9f, 0x01, 0x18, 0xff, 0x03, 0xff}} {
+		check(NewDecoder(bytes.NewReader(in)).Decode(&a))
+		expect(fmt.Sprintf("%x", a), "01ff03", t, fmt.Sprintf("TestDecodeBytes %x", in))
+	}
+	d = NewDecoder(bytes.NewReader([]byte{0xa0}))
+	expect(d.Decode(&a) != nil, true, t, "TestDecodeBytes map")
 }
 
 func TestDecodeString(t *testing.T) {
@@ -311,6 +326,46 @@ func TestDecodeKUint(t *testing.T) {
 	expect(d.Decode(reflect.ValueOf(&a)) != nil, true, t)
 }
 
+func TestDecodeIntKind(t *testing.T) {
+	// {"a": 5, "b": -5}
+	buf := []byte{0xa2, 0x61, 0x61, 0x05, 0x61, 0x62, 0x24}
+	var m map[string]int
+	check(NewDecoder(bytes.NewReader(buf)).Decode(&m))
+	expect(m["a"], 5, t, "TestDecodeIntKind")
+	expect(m["b"], -5, t, "TestDecodeIntKind")
+}
+
+func TestDecodeSignedIntKinds(t *testing.T) {
+	// {"a": 200, "b": -200} with two bytes arguments
+	buf := []byte{0xa2, 0x61, 0x61, 0x19, 0x00, 0xc8, 0x61, 0x62, 0x39, 0x00, 0xc7}
+	var m map[string]int16
+	check(NewDecoder(bytes.NewReader(buf)).Decode(&m))
+	expect(m["a"], int16(200), t, "TestDecodeSignedIntKinds")
+	expect(m["b"], int16(-200), t, "TestDecodeSignedIntKinds")
+
+	// [5, -5] with one byte arguments
+	buf = []byte{0x82, 0x18, 0x05, 0x38, 0x04}
+	var a []int8
+	check(NewDecoder(bytes.NewReader(buf)).Decode(&a))
+	expect(a[0], int8(5), t, "TestDecodeSignedIntKinds")
+	expect(a[1], int8(-5), t, "TestDecodeSignedIntKinds")
+
+	// values that don't fit into the kind are rejected
+	for _, in := range [][]byte{{0x81, 0x18, 0x80}, {0x81, 0x38, 0x80}, {0x81, 0x19, 0x01, 0x00}} {
+		err := NewDecoder(bytes.NewReader(in)).Decode(&a)
+		expect(err != nil, true, t, fmt.Sprintf("TestDecodeSignedIntKinds %x", in))
+	}
+	check(NewDecoder(bytes.NewReader([]byte{0x82, 0x18, 0x7f, 0x38, 0x7f})).Decode(&a))
+	expect(a[0], int8(127), t, "TestDecodeSignedIntKinds")
+	expect(a[1], int8(-128), t, "TestDecodeSignedIntKinds")
+	check(NewDecoder(bytes.NewReader([]byte{0x81, 0x19, 0x00, 0x05})).Decode(&a))
+	expect(a[0], int8(5), t, "TestDecodeSignedIntKinds")
+
+	var ints []int
+	err := NewDecoder(bytes.NewReader([]byte{0x81, 0x1b, 0x80, 0, 0, 0, 0, 0, 0, 0})).Decode(&ints)
+	expect(err != nil, true, t, "TestDecodeSignedIntKinds int")
+}
+
 func TestDecodeUnsignedIntsArray(t *testing.T) {
 	buf := []byte{0x84, 0x04, 0x09, 0x19, 0x04, 0x00, 0x10}
 	r := bytes.NewReader(buf)
@@ -372,6 +427,36 @@ func TestDecodeInterface(t *testing.T) {
 	expect(aiv[2], "españa", t)
 }
 
+func TestDecodeInterfaceIntsAsInt64(t *testing.T) {
+	buf := []byte{0x86, 0x04, 0x19, 0x04, 0x00, 0x21, 0x39, 0x45, 0xab, 0x1a, 0x45, 0xab, 0x23, 0x00, 0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	r := bytes.NewReader(buf)
+	d := NewDecoder(r, BlindIntsAsInt64())
+	var a interface{}
+	check(d.Decode(&a))
+	av := *a.(*[]interface{})
+	expected := []interface{}{int64(4), int64(1024), int64(-2), int64(-17836), int64(1168843520), uint64(18446744073709551615)}
+	for i := range expected {
+		expect(av[i], expected[i], t, "TestDecodeInterfaceIntsAsInt64")
+	}
+}
+
+func TestDecodeInterfaceWideInts(t *testing.T) {
+	buf := []byte{0x86, 0x04, 0x19, 0x04, 0x00, 0x21, 0x39, 0x45, 0xab, 0x1a, 0x45, 0xab, 0x23, 0x00, 0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	d := NewDecoder(bytes.NewReader(buf), WideInts)
+	var a interface{}
+	check(d.Decode(&a))
+	av := *a.(*[]interface{})
+	expected := []interface{}{uint64(4), uint64(1024), int64(-2), int64(-17836), uint64(1168843520), uint64(18446744073709551615)}
+	for i := range expected {
+		expect(av[i], expected[i], t, "TestDecodeInterfaceWideInts")
+	}
+
+	var m map[string]interface{}
+	buf = []byte{0xa1, 0x61, 0x6e, 0x05}
+	check(NewDecoder(bytes.NewReader(buf), WideInts).Decode(&m))
+	expect(m["n"], interface{}(uint64(5)), t, "TestDecodeInterfaceWideInts")
+}
+
 func TestDecodeMap(t *testing.T) {
 	buf := []byte{0xa2, 0x63, 0x46, 0x75, 0x6e, 0xf5, 0x63, 0x41, 0x6d, 0x74, 0x21}
 	r := bytes.NewReader(buf)
@@ -500,7 +585,25 @@ func TestDecodeDuplicateKeysMapIntoStructStrictMode(t *testing.T) {
 	var a MyType
 	err := d.Decode(&a)
 	expect(err != nil, true, t)
-	expect(fmt.Sprint(err), "strict-mode: duplicated key Fun in map", t)
+	expect(fmt.Sprint(errors.Unwrap(err)), "strict-mode: duplicated key Fun in map", t)
+	var strictErr *StrictModeError
+	expect(errors.As(err, &strictErr), true, t)
+}
+
+func TestDecodeNonTextKeysIntoStringMapStrictMode(t *testing.T) {
+	buf := []byte{0xa2, 0x01, 0x02, 0x03, 0x04}
+	d := NewDecoder(bytes.NewReader(buf), func(dec *Decoder) { dec.strict = true })
+	var m map[string]int
+	err := d.Decode(&m)
+	expect(err != nil, true, t)
+	expect(fmt.Sprint(errors.Unwrap(err)), "strict-mode: map key of type cborUnsignedInt can't be decoded into string", t)
+
+	buf = []byte{0xa1, 0x61, 0x61, 0x01}
+	d = NewDecoder(bytes.NewReader(buf), func(dec *Decoder) { dec.strict = true })
+	m = nil
+	check(d.Decode(&m))
+	expect(len(m), 1, t)
+	expect(m["a"], 1, t)
 }
 
 func TestDecodeMapIntoStructNonStringKeys(t *testing.T) {
@@ -514,7 +617,7 @@ func TestDecodeMapIntoStructNonStringKeys(t *testing.T) {
 	var a MyType
 	err := d.Decode(&a)
 	expect(err != nil, true, t)
-	expect(fmt.Sprint(err), "map keys must be string, cborUnsignedInt received", t)
+	expect(fmt.Sprint(errors.Unwrap(err)), "map keys must be string, cborUnsignedInt received", t)
 }
 
 func TestDecodeMapNonFieldIntoStruct(t *testing.T) {
@@ -566,7 +669,7 @@ func TestDecodeMapNonFieldIntoStructStrictMode(t *testing.T) {
 	var a MyType
 	err := d.Decode(&a)
 	expect(err != nil, true, t)
-	expect(fmt.Sprint(err), "strict-mode: key Amt doesn't match with any field", t)
+	expect(fmt.Sprint(errors.Unwrap(err)), "strict-mode: key Amt doesn't match with any field", t)
 }
 
 func TestDecodeMapOutboundsIntoStruct(t *testing.T) {
@@ -595,7 +698,7 @@ func TestDecodeMapOutboundsIntoStructStrictMode(t *testing.T) {
 	var a MyType
 	err := d.Decode(&a)
 	expect(err != nil, true, t)
-	expect(fmt.Sprint(err), "strict-mode: destination struct fields num 2 doesn't match map length 3", t)
+	expect(fmt.Sprint(errors.Unwrap(err)), "strict-mode: destination struct fields num 2 doesn't match map length 3", t)
 }
 
 func TestDecodeArrayIntoStruct(t *testing.T) {
@@ -614,7 +717,7 @@ func TestDecodeArrayIntoStruct(t *testing.T) {
 }
 
 func TestDecodeIndefiniteArrayIntoStruct(t *testing.T) {
-	buf := []byte{0x8f, 0x63, 0x46, 0x75, 0x6e, 0xf5, 0x63, 0x41, 0x6d, 0x74, 0x21, 0xff}
+	buf := []byte{0x9f, 0x63, 0x46, 0x75, 0x6e, 0xf5, 0x63, 0x41, 0x6d, 0x74, 0x21, 0xff}
 	r := bytes.NewReader(buf)
 	d := NewDecoder(r)
 	type MyType struct {
@@ -652,7 +755,38 @@ func TestDecodeDuplicateKeysArrayIntoStructStrictMode(t *testing.T) {
 	var a MyType
 	err := d.Decode(&a)
 	expect(err != nil, true, t)
-	expect(fmt.Sprint(err), "strict-mode: duplicated key Fun in map", t)
+	expect(fmt.Sprint(errors.Unwrap(err)), "strict-mode: duplicated key Fun in map", t)
+}
+
+func TestDecodeDuplicateIntKeysStrictMode(t *testing.T) {
+	strict := func(dec *Decoder) { dec.strict = true }
+	// {1: "a", 1: "b"} with the second key encoded in two bytes
+	buf := []byte{0xa2, 0x01, 0x61, 0x61, 0x18, 0x01, 0x61, 0x62}
+	var a map[int]string
+	err := NewDecoder(bytes.NewReader(buf), strict).Decode(&a)
+	expect(fmt.Sprint(err), "at byte 4: strict-mode: duplicated key 1 in map", t, "TestDecodeDuplicateIntKeysStrictMode")
+
+	// integers of different widths are the same key for interfaces
+	buf = []byte{0xa2, 0x01, 0x61, 0x61, 0x19, 0x00, 0x01, 0x61, 0x62}
+	var b map[interface{}]string
+	err = NewDecoder(bytes.NewReader(buf), strict).Decode(&b)
+	expect(fmt.Sprint(err), "at byte 4: strict-mode: duplicated key 1 in map", t, "TestDecodeDuplicateIntKeysStrictMode")
+	check(NewDecoder(bytes.NewReader(buf)).Decode(&b))
+
+	type Header struct {
+		Alg int    `cbor:"1,keyasint"`
+		Kid string `cbor:"4,keyasint"`
+	}
+	buf = []byte{0xa2, 0x01, 0x20, 0x18, 0x01, 0x21}
+	var h Header
+	err = NewDecoder(bytes.NewReader(buf), strict).Decode(&h)
+	expect(fmt.Sprint(err), "at byte 3: strict-mode: duplicated key 1 in map", t, "TestDecodeDuplicateIntKeysStrictMode")
+
+	// a text key is not the same key as an integer one
+	buf = []byte{0xa2, 0x01, 0x20, 0x63, 0x41, 0x6c, 0x67, 0x21}
+	h = Header{}
+	check(NewDecoder(bytes.NewReader(buf), strict).Decode(&h))
+	expect(h.Alg, -2, t, "TestDecodeDuplicateIntKeysStrictMode")
 }
 
 func TestDecodeArrayIntoStructNonStringKeys(t *testing.T) {
@@ -666,7 +800,7 @@ func TestDecodeArrayIntoStructNonStringKeys(t *testing.T) {
 	var a MyType
 	err := d.Decode(&a)
 	expect(err != nil, true, t)
-	expect(fmt.Sprint(err), "array keys must be string, cborUnsignedInt received", t)
+	expect(fmt.Sprint(errors.Unwrap(err)), "array keys must be string, cborUnsignedInt received", t)
 }
 
 func TestDecodeArrayNonFieldIntoStruct(t *testing.T) {
@@ -696,7 +830,7 @@ func TestDecodeArrayNonFieldIntoStructWithValidTag(t *testing.T) {
 }
 
 func TestDecodeIndefiniteArrayNonFieldIntoStruct(t *testing.T) {
-	buf := []byte{0x8f, 0x63, 0x46, 0x75, 0x6e, 0xf5, 0x63, 0x41, 0x6d, 0x74, 0x21, 0xff}
+	buf := []byte{0x9f, 0x63, 0x46, 0x75, 0x6e, 0xf5, 0x63, 0x41, 0x6d, 0x74, 0x21, 0xff}
 	r := bytes.NewReader(buf)
 	d := NewDecoder(r)
 	type MyType struct {
@@ -718,7 +852,7 @@ func TestDecodeArrayNonFieldIntoStructStrictMode(t *testing.T) {
 	var a MyType
 	err := d.Decode(&a)
 	expect(err != nil, true, t)
-	expect(fmt.Sprint(err), "strict-mode: key Amt doesn't match with any field", t)
+	expect(fmt.Sprint(errors.Unwrap(err)), "strict-mode: key Amt doesn't match with any field", t)
 }
 
 func TestDecodeArrayOutboundsIntoStruct(t *testing.T) {
@@ -747,7 +881,7 @@ func TestDecodeArrayOutboundsIntoStructStrictMode(t *testing.T) {
 	var a MyType
 	err := d.Decode(&a)
 	expect(err != nil, true, t)
-	expect(fmt.Sprint(err), "strict-mode: destination struct fields num 2 doesn't match map length 3", t)
+	expect(fmt.Sprint(errors.Unwrap(err)), "strict-mode: destination struct fields num 2 doesn't match map length 3", t)
 }
 
 func TestDecodeArrayIntoStructWithNilValue(t *testing.T) {
@@ -801,6 +935,36 @@ func TestDecodeNegativeBigNumFromInterface(t *testing.T) {
 	expect(fmt.Sprint(a), "-18446744073709551617", t)
 }
 
+func TestDecodeNegativeBigNumPowersOf256(t *testing.T) {
+	// the value is -1 - n where n is the big-endian content
+	cases := []struct{ in, out string }{
+		{"c340", "-1"},
+		{"c34100", "-1"},
+		{"c341ff", "-256"},
+		{"c3420100", "-257"},
+		{"c343010000", "-65537"},
+		{"c348ffffffffffffffff", "-18446744073709551616"},
+		{"c349010000000000000000", "-18446744073709551617"},
+	}
+	for _, c := range cases {
+		in, _ := hex.DecodeString(c.in)
+		var a big.Int
+		check(NewDecoder(bytes.NewReader(in)).Decode(&a))
+		expect(a.String(), c.out, t, "TestDecodeNegativeBigNumPowersOf256 "+c.in)
+		var i interface{}
+		check(NewDecoder(bytes.NewReader(in)).Decode(&i))
+		expect(fmt.Sprint(i), c.out, t, "TestDecodeNegativeBigNumPowersOf256 "+c.in)
+
+		// encoding the decoded value gives back the shortest content
+		if c.in == "c34100" {
+			continue
+		}
+		buf := bytes.NewBuffer(nil)
+		check(NewEncoder(buf).Encode(&a))
+		expect(fmt.Sprintf("%x", buf.Bytes()), c.in, t, "TestDecodeNegativeBigNumPowersOf256 "+c.in)
+	}
+}
+
 func TestDecodeBigNumWrongData(t *testing.T) {
 	buf := []byte{0xc2, 0x29, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
 	r := bytes.NewReader(buf)
@@ -808,7 +972,7 @@ func TestDecodeBigNumWrongData(t *testing.T) {
 	a := new(big.Int)
 	msg := "expected bytes found cborNegativeInt"
 	err := d.Decode(a)
-	expect(err.Error(), msg, t, "TestDecodeBigNumWrongData")
+	expect(errors.Unwrap(err).Error(), msg, t, "TestDecodeBigNumWrongData")
 }
 
 func TestDecodeUtf8DateTime(t *testing.T) {
@@ -848,7 +1012,32 @@ func TestDecodeUtf8DateTimeWrongMajor(t *testing.T) {
 	var a interface{}
 	err := d.Decode(&a)
 	expect(a, nil, t)
-	expect(err.Error(), "expected UTF-8 string, found cborByteString", t)
+	expect(errors.Unwrap(err).Error(), "expected UTF-8 string, found cborByteString", t)
+}
+
+func TestDecodeUtf8DateTimeLeapSecond(t *testing.T) {
+	buf := []byte{0xc0, 0x74, 0x32, 0x30, 0x31, 0x36, 0x2d, 0x31, 0x32, 0x2d, 0x33, 0x31, 0x54, 0x32, 0x33, 0x3a, 0x35, 0x39, 0x3a, 0x36, 0x30, 0x5a}
+	r := bytes.NewReader(buf)
+	d := NewDecoder(r)
+	var a time.Time
+	err := d.Decode(&a)
+	expect(err != nil, true, t, "TestDecodeUtf8DateTimeLeapSecond")
+	expect(fmt.Sprint(errors.Unwrap(err)), "leap second in date time 2016-12-31T23:59:60Z is not supported, use WithLeapSeconds to accept it", t, "TestDecodeUtf8DateTimeLeapSecond")
+
+	r = bytes.NewReader(buf)
+	d = NewDecoder(r, WithLeapSeconds())
+	check(d.Decode(&a))
+	expect(a.Year(), 2017, t, "TestDecodeUtf8DateTimeLeapSecond")
+	expect(a.Month(), time.January, t, "TestDecodeUtf8DateTimeLeapSecond")
+	expect(a.Day(), 1, t, "TestDecodeUtf8DateTimeLeapSecond")
+	expect(a.Hour(), 0, t, "TestDecodeUtf8DateTimeLeapSecond")
+	expect(a.Second(), 0, t, "TestDecodeUtf8DateTimeLeapSecond")
+
+	r = bytes.NewReader(buf)
+	d = NewDecoder(r, WithLeapSeconds())
+	var b interface{}
+	check(d.Decode(&b))
+	expect(b.(time.Time).Year(), 2017, t, "TestDecodeUtf8DateTimeLeapSecond")
 }
 
 func TestDecodeEpochDateTime(t *testing.T) {
@@ -863,7 +1052,36 @@ func TestDecodeEpochDateTime(t *testing.T) {
 	expect(a.Hour(), 18, t)
 	expect(a.Minute(), 30, t)
 	expect(a.Nanosecond(), 0, t)
-	expect(a.Location(), time.Local, t)
+	expect(a.Location(), time.UTC, t)
+}
+
+func TestDecodeFractionalEpochDateTime(t *testing.T) {
+	buf := []byte{0xc1, 0xfb, 0x41, 0xd4, 0x52, 0xd9, 0xec, 0x20, 0x00, 0x00}
+	r := bytes.NewReader(buf)
+	d := NewDecoder(r)
+	var a time.Time
+	check(d.Decode(&a))
+	expect(a.Unix(), int64(1363896240), t, "TestDecodeFractionalEpochDateTime")
+	expect(a.Nanosecond(), 500000000, t, "TestDecodeFractionalEpochDateTime")
+
+	r = bytes.NewReader(buf)
+	d = NewDecoder(r)
+	var b interface{}
+	check(d.Decode(&b))
+	expect(b.(time.Time).Unix(), int64(1363896240), t, "TestDecodeFractionalEpochDateTime")
+	expect(b.(time.Time).Nanosecond(), 500000000, t, "TestDecodeFractionalEpochDateTime")
+}
+
+func TestDecodeFloatEpochDateTimeBlind(t *testing.T) {
+	buf := []byte{0xc1, 0xf9, 0xbe, 0x00, 0xfa, 0x47, 0xc3, 0x50, 0x00}
+	d := NewDecoder(bytes.NewReader(buf))
+	var a interface{}
+	check(d.Decode(&a))
+	expect(a.(time.Time).Unix(), int64(-2), t, "TestDecodeFloatEpochDateTimeBlind")
+	expect(a.(time.Time).Nanosecond(), 500000000, t, "TestDecodeFloatEpochDateTimeBlind")
+	var b interface{}
+	check(d.Decode(&b))
+	expect(b, float32(100000), t, "TestDecodeFloatEpochDateTimeBlind")
 }
 
 func TestDecodeEpochDateTimeFromInterface(t *testing.T) {
@@ -878,7 +1096,24 @@ func TestDecodeEpochDateTimeFromInterface(t *testing.T) {
 	expect(a.(time.Time).Hour(), 18, t)
 	expect(a.(time.Time).Minute(), 30, t)
 	expect(a.(time.Time).Nanosecond(), 0, t)
-	expect(a.(time.Time).Location(), time.Local, t)
+	expect(a.(time.Time).Location(), time.UTC, t)
+}
+
+func TestDecodeDateTimeLocation(t *testing.T) {
+	epoch := []byte{0xc1, 0x1a, 0x3f, 0xdb, 0x5a, 0xaa}
+	text := []byte{0xc0, 0x74, 0x32, 0x30, 0x30, 0x33, 0x2d, 0x31, 0x32, 0x2d, 0x31, 0x33, 0x54, 0x31, 0x38, 0x3a, 0x33, 0x30, 0x3a, 0x30, 0x32, 0x5a}
+	var a, b time.Time
+	check(NewDecoder(bytes.NewReader(epoch)).Decode(&a))
+	check(NewDecoder(bytes.NewReader(text)).Decode(&b))
+	expect(a.Location(), time.UTC, t, "TestDecodeDateTimeLocation")
+	expect(a, b, t, "TestDecodeDateTimeLocation")
+
+	loc := time.FixedZone("CET", 3600)
+	check(NewDecoder(bytes.NewReader(epoch), WithLocation(loc)).Decode(&a))
+	check(NewDecoder(bytes.NewReader(text), WithLocation(loc)).Decode(&b))
+	expect(a.Location(), loc, t, "TestDecodeDateTimeLocation")
+	expect(a.Hour(), 19, t, "TestDecodeDateTimeLocation")
+	expect(a, b, t, "TestDecodeDateTimeLocation")
 }
 
 func TestDecodeEpochDateTimeWrongMajor(t *testing.T) {
@@ -888,7 +1123,7 @@ func TestDecodeEpochDateTimeWrongMajor(t *testing.T) {
 	var a interface{}
 	err := d.Decode(&a)
 	expect(a, nil, t)
-	expect(err.Error(), "can't decode Epoch timestamp cborByteString", t)
+	expect(errors.Unwrap(err).Error(), "can't decode Epoch timestamp cborByteString", t)
 }
 
 func TestDecodeNegativeEpochDateTimeFromInterface(t *testing.T) {
@@ -900,11 +1135,11 @@ func TestDecodeNegativeEpochDateTimeFromInterface(t *testing.T) {
 	expect(a.(time.Time).Year(), 1969, t)
 	expect(a.(time.Time).Month(), time.February, t)
 	expect(a.(time.Time).Day(), 28, t)
-	expect(a.(time.Time).Hour(), 20, t)
+	expect(a.(time.Time).Hour(), 19, t)
 	expect(a.(time.Time).Minute(), 34, t)
 	expect(a.(time.Time).Second(), 12, t)
 	expect(a.(time.Time).Nanosecond(), 0, t)
-	expect(a.(time.Time).Location(), time.Local, t)
+	expect(a.(time.Time).Location(), time.UTC, t)
 }
 
 func TestDecodeDecimalFraction(t *testing.T) {
@@ -913,7 +1148,64 @@ func TestDecodeDecimalFraction(t *testing.T) {
 	d := NewDecoder(r)
 	var a interface{}
 	check(d.Decode(&a))
-	expect(a, float32(273.15), t, "TestDecodeDecimalFraction")
+	expect(a.(DecimalFraction).Exponent, int64(-2), t, "TestDecodeDecimalFraction")
+	expect(a.(DecimalFraction).Mantissa.Int64(), int64(27315), t, "TestDecodeDecimalFraction")
+	rat, err := a.(DecimalFraction).Rat()
+	check(err)
+	expect(rat.String(), big.NewRat(5463, 20).String(), t, "TestDecodeDecimalFraction")
+
+	r = bytes.NewReader(buf)
+	d = NewDecoder(r)
+	var b float32
+	check(d.Decode(&b))
+	expect(b, float32(273.15), t, "TestDecodeDecimalFraction")
+
+	r = bytes.NewReader(buf)
+	d = NewDecoder(r)
+	var c DecimalFraction
+	check(d.Decode(&c))
+	expect(c.Exponent, int64(-2), t, "TestDecodeDecimalFraction")
+	expect(c.Mantissa.Int64(), int64(27315), t, "TestDecodeDecimalFraction")
+}
+
+func TestDecodeDecimalFractionBigMantissa(t *testing.T) {
+	buf := []byte{0xc4, 0x82, 0x03, 0xc2, 0x49, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	r := bytes.NewReader(buf)
+	d := NewDecoder(r)
+	var a DecimalFraction
+	check(d.Decode(&a))
+	expect(a.Exponent, int64(3), t, "TestDecodeDecimalFractionBigMantissa")
+	expect(a.Mantissa.String(), "18446744073709551616", t, "TestDecodeDecimalFractionBigMantissa")
+	rat, err := a.Rat()
+	check(err)
+	expect(rat.String(), "18446744073709551616000/1", t, "TestDecodeDecimalFractionBigMantissa")
+}
+
+func TestDecodeDecimalFractionHugeExponent(t *testing.T) {
+	buf := []byte{0xc4, 0x82, 0x1b, 0x00, 0x00, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00,
+		0xc2, 0x49, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	var a float32
+	check(NewDecoder(bytes.NewReader(buf)).Decode(&a))
+	expect(math.IsInf(float64(a), 1), true, t, "TestDecodeDecimalFractionHugeExponent")
+	var b DecimalFraction
+	check(NewDecoder(bytes.NewReader(buf)).Decode(&b))
+	_, err := b.Rat()
+	expect(err != nil, true, t, "TestDecodeDecimalFractionHugeExponent")
+
+	buf[2], buf[11] = 0x3b, 0xc3
+	check(NewDecoder(bytes.NewReader(buf)).Decode(&a))
+	expect(a == 0 && math.Signbit(float64(a)), true, t, "TestDecodeDecimalFractionHugeExponent")
+	buf[2] = 0x1b
+	check(NewDecoder(bytes.NewReader(buf)).Decode(&a))
+	expect(math.IsInf(float64(a), -1), true, t, "TestDecodeDecimalFractionHugeExponent")
+
+	// small mantissas
+	buf = []byte{0xc4, 0x82, 0x1b, 0x00, 0x00, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x01}
+	check(NewDecoder(bytes.NewReader(buf)).Decode(&a))
+	expect(math.IsInf(float64(a), 1), true, t, "TestDecodeDecimalFractionHugeExponent")
+	buf[11] = 0x00
+	check(NewDecoder(bytes.NewReader(buf)).Decode(&a))
+	expect(a, float32(0), t, "TestDecodeDecimalFractionHugeExponent")
 }
 
 func TestDecodeDecimalFractionNonArray(t *testing.T) {
@@ -924,7 +1216,7 @@ func TestDecodeDecimalFractionNonArray(t *testing.T) {
 	err := d.Decode(&a)
 	msg := "Decimal Fraction must be represented as an array of two elements"
 	expect(a, nil, t, "TestDecodeCecimalFractionNonArray")
-	expect(err.Error(), msg, t, "TestDecodeDecimalFractionNonArray")
+	expect(errors.Unwrap(err).Error(), msg, t, "TestDecodeDecimalFractionNonArray")
 }
 
 func TestDecodeDecimalFractionInvalidExponent(t *testing.T) {
@@ -935,7 +1227,7 @@ func TestDecodeDecimalFractionInvalidExponent(t *testing.T) {
 	err := d.Decode(&a)
 	msg := "Can't decode cborByteString as decimal fraction exponent"
 	expect(a, nil, t, "TestDecodeDecimalFractionInvalidExponent")
-	expect(err.Error(), msg, t, "TestDecodeDecimalFractionInvalidExponent")
+	expect(errors.Unwrap(err).Error(), msg, t, "TestDecodeDecimalFractionInvalidExponent")
 }
 
 func TestDecodeDecimalFractionInvalidMantissa(t *testing.T) {
@@ -946,7 +1238,7 @@ func TestDecodeDecimalFractionInvalidMantissa(t *testing.T) {
 	err := d.Decode(&a)
 	msg := "Can't decode cborByteString as decimal fraction mantissa"
 	expect(a, nil, t, "TestDecodeDecimalFractionInvalidMantissa")
-	expect(err.Error(), msg, t, "TestDecodeDecimalFractionInvalidMantissa")
+	expect(errors.Unwrap(err).Error(), msg, t, "TestDecodeDecimalFractionInvalidMantissa")
 }
 
 func TestDecodeBigFloat(t *testing.T) {
@@ -975,7 +1267,7 @@ func TestDecodeBigFloatNonArray(t *testing.T) {
 	err := d.Decode(&a)
 	msg := "Big float must be represented as an array of two elements"
 	expect(a, nil, t, "TestDecodeBigFloatNonArray")
-	expect(err.Error(), msg, t, "TestDecodeBigFloatNonArray")
+	expect(errors.Unwrap(err).Error(), msg, t, "TestDecodeBigFloatNonArray")
 }
 
 func TestDecodeBigFloatInvalidExponent(t *testing.T) {
@@ -986,7 +1278,7 @@ func TestDecodeBigFloatInvalidExponent(t *testing.T) {
 	err := d.Decode(&a)
 	msg := "Can't decode cborByteString as decimal fraction exponent"
 	expect(a, nil, t, "TestDecodeBigFloatInvalidExponent")
-	expect(err.Error(), msg, t, "TestDecodeBigFloatInvalidExponent")
+	expect(errors.Unwrap(err).Error(), msg, t, "TestDecodeBigFloatInvalidExponent")
 }
 
 func TestDecodeBigFloatInvalidMantissa(t *testing.T) {
@@ -997,7 +1289,29 @@ func TestDecodeBigFloatInvalidMantissa(t *testing.T) {
 	err := d.Decode(&a)
 	msg := "Can't decode cborByteString as decimal fraction mantissa"
 	expect(a, nil, t, "TestDecodeBigFloatInvalidMantissa")
-	expect(err.Error(), msg, t, "TestDecodeBigFloatInvalidMantissa")
+	expect(errors.Unwrap(err).Error(), msg, t, "TestDecodeBigFloatInvalidMantissa")
+}
+
+func TestDecodeBigFloatHugeExponent(t *testing.T) {
+	buf := []byte{0xc5, 0x82, 0x1b, 0x00, 0x00, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x01}
+	var a interface{}
+	err := NewDecoder(bytes.NewReader(buf)).Decode(&a)
+	expect(err != nil, true, t, "TestDecodeBigFloatHugeExponent")
+	var b big.Rat
+	err = NewDecoder(bytes.NewReader(buf)).Decode(&b)
+	expect(err != nil, true, t, "TestDecodeBigFloatHugeExponent")
+
+	// the exact value keeps the exponent but can't be made a *big.Rat
+	var c BigFloat
+	check(NewDecoder(bytes.NewReader(buf)).Decode(&c))
+	expect(c.Exponent, int64(0x2000000000), t, "TestDecodeBigFloatHugeExponent")
+	_, err = c.Rat()
+	expect(err != nil, true, t, "TestDecodeBigFloatHugeExponent")
+
+	// exponents bigger than math.MaxInt64 don't wrap around
+	buf = []byte{0xc5, 0x82, 0x1b, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}
+	err = NewDecoder(bytes.NewReader(buf)).Decode(&c)
+	expect(err.Error(), "at byte 2: exponent 9223372036854775808 overflows int64", t, "TestDecodeBigFloatHugeExponent")
 }
 
 func TestDecodeBase64Url(t *testing.T) {
@@ -1019,7 +1333,7 @@ func TestDecodeBase64UrlInvalidData(t *testing.T) {
 	var a interface{}
 	err := d.Decode(&a)
 	msg := "expected string or bytes found cborDataArray"
-	expect(err.Error(), msg, t, "TestDecodeBase64UrlInvalidDAta")
+	expect(errors.Unwrap(err).Error(), msg, t, "TestDecodeBase64UrlInvalidDAta")
 }
 
 func TestDecodeBase64String(t *testing.T) {
@@ -1041,7 +1355,7 @@ func TestDecodeBase64StringInvalidData(t *testing.T) {
 	var a interface{}
 	err := d.Decode(&a)
 	msg := "expected string or bytes found cborDataArray"
-	expect(err.Error(), msg, t, "TestDecodeBase64StringInvalidData")
+	expect(errors.Unwrap(err).Error(), msg, t, "TestDecodeBase64StringInvalidData")
 }
 
 func TestDecodeBase16String(t *testing.T) {
@@ -1073,7 +1387,7 @@ func TestDecodeUriInvalidData(t *testing.T) {
 	var a interface{}
 	err := d.Decode(&a)
 	msg := "expected string found cborByteString"
-	expect(err.Error(), msg, t, "TestDeecodeUriInvalidData")
+	expect(errors.Unwrap(err).Error(), msg, t, "TestDeecodeUriInvalidData")
 }
 
 func TestDecodeBase64URI(t *testing.T) {
@@ -1093,7 +1407,7 @@ func TestDecodeBase64URIInvalidData(t *testing.T) {
 	var a interface{}
 	err := d.Decode(&a)
 	msg := "expected string found cborByteString"
-	expect(err.Error(), msg, t, "TestDeecodeBase64URIInvalidData")
+	expect(errors.Unwrap(err).Error(), msg, t, "TestDeecodeBase64URIInvalidData")
 }
 
 func TestDecodeBase64DecodedString(t *testing.T) {
@@ -1113,7 +1427,7 @@ func TestDecodeBase64DecodedStringInvalidData(t *testing.T) {
 	var a interface{}
 	err := d.Decode(&a)
 	msg := "expected string found cborByteString"
-	expect(err.Error(), msg, t, "TestDeecodeBase64DecodedStringInvalidData")
+	expect(errors.Unwrap(err).Error(), msg, t, "TestDeecodeBase64DecodedStringInvalidData")
 }
 
 func TestDecodeRegexp(t *testing.T) {
@@ -1133,7 +1447,7 @@ func TestDecodeRegexpInvalidData(t *testing.T) {
 	var a interface{}
 	err := d.Decode(&a)
 	msg := "expected string found cborByteString"
-	expect(err.Error(), msg, t, "TestDecodeRegexpInvalidData")
+	expect(errors.Unwrap(err).Error(), msg, t, "TestDecodeRegexpInvalidData")
 }
 
 func TestDecodeMime(t *testing.T) {
@@ -1146,6 +1460,1224 @@ func TestDecodeMime(t *testing.T) {
 	expect(a.(*CBORMIME).Params["title"], "This is ***fun***", t, "TestDecodeMime")
 }
 
+func TestDecodeMaxDepth(t *testing.T) {
+	buf := bytes.Repeat([]byte{absoluteIndefiniteArray}, 1000)
+	r := bytes.NewReader(buf)
+	d := NewDecoder(r)
+	var a interface{}
+	err := d.Decode(&a)
+	expect(err != nil, true, t, "TestDecodeMaxDepth")
+	expect(fmt.Sprint(errors.Unwrap(err)), "maximum nesting depth of 256 exceeded", t, "TestDecodeMaxDepth")
+
+	buf = []byte{0x81, 0x81, 0x81, 0x01}
+	r = bytes.NewReader(buf)
+	d = NewDecoder(r, MaxDepth(2))
+	var b [][][]uint
+	err = d.Decode(&b)
+	expect(fmt.Sprint(errors.Unwrap(err)), "maximum nesting depth of 2 exceeded", t, "TestDecodeMaxDepth")
+
+	r = bytes.NewReader(buf)
+	d = NewDecoder(r, MaxDepth(3))
+	check(d.Decode(&b))
+	expect(b[0][0][0], uint(1), t, "TestDecodeMaxDepth")
+}
+
+func TestDecodeMaxDepthNestedUnknownTags(t *testing.T) {
+	buf := append(bytes.Repeat([]byte{0xc6}, 100000), 0x01)
+	var a interface{}
+	err := NewDecoder(bytes.NewReader(buf)).Decode(&a)
+	expect(fmt.Sprint(err), "at byte 256: maximum nesting depth of 256 exceeded", t, "TestDecodeMaxDepthNestedUnknownTags")
+
+	// each tag is a nesting level
+	buf = []byte{0xc6, 0xc6, 0x01}
+	err = NewDecoder(bytes.NewReader(buf), MaxDepth(1)).Decode(&a)
+	expect(err != nil, true, t, "TestDecodeMaxDepthNestedUnknownTags")
+	check(NewDecoder(bytes.NewReader(buf), MaxDepth(2)).Decode(&a))
+	expect(a, interface{}(Tag{Number: 6, Content: Tag{Number: 6, Content: uint8(1)}}), t, "TestDecodeMaxDepthNestedUnknownTags")
+}
+
+func TestDecodeMaxDepthSkippedItems(t *testing.T) {
+	buf := append([]byte{0xa1, 0x61, 0x61}, bytes.Repeat([]byte{0x81}, 100000)...)
+	buf = append(buf, 0x01)
+	var m map[string]RawMessage
+	err := NewDecoder(bytes.NewReader(buf)).Decode(&m)
+	expect(fmt.Sprint(err), "at byte 259: maximum nesting depth of 256 exceeded", t, "TestDecodeMaxDepthSkippedItems")
+
+	err = NewDecoder(bytes.NewReader(buf[3:])).Skip()
+	expect(fmt.Sprint(err), "maximum nesting depth of 256 exceeded", t, "TestDecodeMaxDepthSkippedItems")
+	check(NewDecoder(bytes.NewReader(buf[3:]), MaxDepth(0)).Skip())
+}
+
+func TestSkipBigLengths(t *testing.T) {
+	// lengths bigger than the data are truncated items, not allocations
+	buf := []byte{0x5b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00}
+	err := NewDecoder(bytes.NewReader(buf)).Skip()
+	expect(fmt.Sprint(err), "can't scan 9223372036854775807 bytes from buffer as only 1 are available\n", t, "TestSkipBigLengths")
+	buf[1] = 0xff
+	err = NewDecoder(bytes.NewReader(buf)).Skip()
+	expect(fmt.Sprint(err), "length 18446744073709551615 is too big", t, "TestSkipBigLengths")
+
+	// maps with more than math.MaxInt64 pairs don't wrap around
+	buf = []byte{0xbb, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	err = NewDecoder(bytes.NewReader(buf)).Skip()
+	expect(fmt.Sprint(err), "unexpected end of data", t, "TestSkipBigLengths")
+}
+
+func TestDecodeTagWrappedMapIntoStruct(t *testing.T) {
+	type MyType struct {
+		Fun bool
+		Amt int8
+	}
+	buf := []byte{0xd8, 0x1b, 0xa2, 0x63, 0x46, 0x75, 0x6e, 0xf5, 0x63, 0x41, 0x6d, 0x74, 0x21}
+	r := bytes.NewReader(buf)
+	d := NewDecoder(r, WithTagUnwrap())
+	var a MyType
+	check(d.Decode(&a))
+	expect(a.Fun, true, t, "TestDecodeTagWrappedMapIntoStruct")
+	expect(a.Amt, int8(-2), t, "TestDecodeTagWrappedMapIntoStruct")
+
+	buf = []byte{0xd9, 0xd9, 0xf7, 0xa2, 0x63, 0x46, 0x75, 0x6e, 0xf5, 0x63, 0x41, 0x6d, 0x74, 0x21}
+	r = bytes.NewReader(buf)
+	d = NewDecoder(r)
+	var b MyType
+	check(d.Decode(&b))
+	expect(b.Fun, true, t, "TestDecodeTagWrappedMapIntoStruct")
+	expect(b.Amt, int8(-2), t, "TestDecodeTagWrappedMapIntoStruct")
+}
+
+func TestDecodeMapIntoExistingMap(t *testing.T) {
+	buf := []byte{0xa2, 0x63, 0x4f, 0x6e, 0x65, 0x01, 0x63, 0x54, 0x77, 0x6f, 0x02}
+	r := bytes.NewReader(buf)
+	d := NewDecoder(r)
+	a := map[string]int{"One": 100, "Three": 3}
+	check(d.Decode(&a))
+	expect(len(a), 3, t, "TestDecodeMapIntoExistingMap")
+	expect(a["One"], 1, t, "TestDecodeMapIntoExistingMap")
+	expect(a["Two"], 2, t, "TestDecodeMapIntoExistingMap")
+	expect(a["Three"], 3, t, "TestDecodeMapIntoExistingMap")
+
+	r = bytes.NewReader(buf)
+	d = NewDecoder(r, WithMapClear())
+	b := map[string]int{"One": 100, "Three": 3}
+	check(d.Decode(&b))
+	expect(len(b), 2, t, "TestDecodeMapIntoExistingMap")
+	expect(b["One"], 1, t, "TestDecodeMapIntoExistingMap")
+	expect(b["Two"], 2, t, "TestDecodeMapIntoExistingMap")
+	_, ok := b["Three"]
+	expect(ok, false, t, "TestDecodeMapIntoExistingMap")
+
+	r = bytes.NewReader(buf)
+	d = NewDecoder(r, func(dec *Decoder) { dec.strict = true })
+	c := map[string]int{"One": 100}
+	check(d.Decode(&c))
+	expect(c["One"], 1, t, "TestDecodeMapIntoExistingMap")
+}
+
+type UnionName string
+
+type UnionAddress struct {
+	Street string
+	Number uint8
+}
+
+func TestDecodeRegisteredMajorTypes(t *testing.T) {
+	check(RegisterMajorType(cborTextString, UnionName("")))
+	check(RegisterMajorType(cborDataMap, UnionAddress{}))
+	defer delete(majorTypesDec, cborTextString)
+	defer delete(majorTypesDec, cborDataMap)
+	expect(RegisterMajorType(cborTextString, "") != nil, true, t, "TestDecodeRegisteredMajorTypes")
+
+	buf := []byte{0x83, 0x63, 0x42, 0x6f, 0x62, 0xa2, 0x66, 0x53, 0x74, 0x72, 0x65, 0x65, 0x74, 0x64, 0x43, 0x42, 0x4f, 0x52, 0x66, 0x4e, 0x75, 0x6d, 0x62, 0x65, 0x72, 0x04, 0x05}
+	r := bytes.NewReader(buf)
+	d := NewDecoder(r, WithMajorTypes())
+	var a []interface{}
+	check(d.Decode(&a))
+	expect(len(a), 3, t, "TestDecodeRegisteredMajorTypes")
+	expect(a[0], UnionName("Bob"), t, "TestDecodeRegisteredMajorTypes")
+	expect(a[1], UnionAddress{"CBOR", 4}, t, "TestDecodeRegisteredMajorTypes")
+	expect(a[2], uint8(5), t, "TestDecodeRegisteredMajorTypes")
+
+	r = bytes.NewReader(buf)
+	d = NewDecoder(r)
+	var b []interface{}
+	check(d.Decode(&b))
+	expect(b[0], "Bob", t, "TestDecodeRegisteredMajorTypes")
+}
+
+func TestDecodeSimpleValue(t *testing.T) {
+	buf := []byte{0xf0, 0xf8, 0xff, 0x82, 0xf0, 0xf8, 0xff}
+	r := bytes.NewReader(buf)
+	d := NewDecoder(r)
+	var a Simple
+	check(d.Decode(&a))
+	expect(a, Simple(16), t, "TestDecodeSimpleValue")
+	check(d.Decode(&a))
+	expect(a, Simple(255), t, "TestDecodeSimpleValue")
+	var b interface{}
+	check(d.Decode(&b))
+	bv := *b.(*[]interface{})
+	expect(bv[0], Simple(16), t, "TestDecodeSimpleValue")
+	expect(bv[1], Simple(255), t, "TestDecodeSimpleValue")
+}
+
+func TestDecodeSimpleValueFields(t *testing.T) {
+	type Flags struct {
+		S Simple
+		P *Simple
+		L []Simple
+	}
+	in := Flags{S: 16, P: new(Simple), L: []Simple{16, 255}}
+	*in.P = 255
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf).Encode(in))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "a36153f06150f8ff614c82f0f8ff", t, "TestDecodeSimpleValueFields")
+	var out Flags
+	check(NewDecoder(buf).Decode(&out))
+	expect(out.S, Simple(16), t, "TestDecodeSimpleValueFields")
+	expect(*out.P, Simple(255), t, "TestDecodeSimpleValueFields")
+	expect(fmt.Sprint(out.L), "[16 255]", t, "TestDecodeSimpleValueFields")
+}
+
+func TestDecodeMapIntoRawMessages(t *testing.T) {
+	buf := []byte{
+		0xa3,
+		0x64, 0x6b, 0x69, 0x6e, 0x64, 0x65, 0x70, 0x6f, 0x69, 0x6e, 0x74,
+		0x65, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x82, 0x01, 0x19, 0x01, 0x2c,
+		0x64, 0x6d, 0x65, 0x74, 0x61, 0xa1, 0x61, 0x61, 0xf6,
+		0x9f, 0x01, 0xff, 0x62, 0x6f, 0x6b,
+	}
+	d := NewDecoder(bytes.NewReader(buf))
+	var m map[string]RawMessage
+	check(d.Decode(&m))
+	expect(len(m), 3, t, "TestDecodeMapIntoRawMessages")
+	expect(fmt.Sprintf("%x", m["kind"]), "65706f696e74", t, "TestDecodeMapIntoRawMessages")
+	expect(fmt.Sprintf("%x", m["point"]), "820119012c", t, "TestDecodeMapIntoRawMessages")
+	expect(fmt.Sprintf("%x", m["meta"]), "a16161f6", t, "TestDecodeMapIntoRawMessages")
+	var point []uint
+	check(NewDecoder(bytes.NewReader(m["point"])).Decode(&point))
+	expect(len(point), 2, t, "TestDecodeMapIntoRawMessages")
+	expect(point[0], uint(1), t, "TestDecodeMapIntoRawMessages")
+	expect(point[1], uint(300), t, "TestDecodeMapIntoRawMessages")
+	check(d.Skip())
+	var s string
+	check(d.Decode(&s))
+	expect(s, "ok", t, "TestDecodeMapIntoRawMessages")
+}
+
+func TestDecodeUnknownTag(t *testing.T) {
+	buf := []byte{0xd9, 0x9c, 0x40, 0x82, 0x01, 0xcf, 0x61, 0x61}
+	d := NewDecoder(bytes.NewReader(buf))
+	var a interface{}
+	check(d.Decode(&a))
+	tag := a.(Tag)
+	expect(tag.Number, uint64(40000), t, "TestDecodeUnknownTag")
+	content := *tag.Content.(*[]interface{})
+	expect(content[0], uint8(1), t, "TestDecodeUnknownTag")
+	expect(content[1], Tag{Number: 15, Content: "a"}, t, "TestDecodeUnknownTag")
+	out := bytes.NewBuffer(nil)
+	check(NewEncoder(out).Encode(tag))
+	expect(fmt.Sprintf("%x", out.Bytes()), fmt.Sprintf("%x", buf), t, "TestDecodeUnknownTag")
+}
+
+func TestDecodeStreamOfItems(t *testing.T) {
+	buf := []byte{0x01, 0x18, 0x64, 0x19, 0x03, 0xe8}
+	d := NewDecoder(bytes.NewReader(buf))
+	var values []interface{}
+	for d.More() {
+		v, err := d.DecodeValue()
+		check(err)
+		values = append(values, v)
+	}
+	expect(len(values), 3, t, "TestDecodeStreamOfItems")
+	expect(values[0], uint8(1), t, "TestDecodeStreamOfItems")
+	expect(values[1], uint8(100), t, "TestDecodeStreamOfItems")
+	expect(values[2], uint16(1000), t, "TestDecodeStreamOfItems")
+	expect(d.More(), false, t, "TestDecodeStreamOfItems")
+
+	d = NewDecoder(bytes.NewReader([]byte{0x01, 0x19, 0x03}))
+	_, err := d.DecodeValue()
+	check(err)
+	expect(d.More(), true, t, "TestDecodeStreamOfItems")
+	_, err = d.DecodeValue()
+	expect(err != nil, true, t, "TestDecodeStreamOfItems")
+}
+
+func TestDecodeMoreDoesNotConsumeInput(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf)
+	for _, s := range []string{"a", "bc", "def"} {
+		check(e.Encode(s))
+	}
+	d := NewDecoder(iotest.OneByteReader(buf))
+	var out []string
+	for d.More() {
+		// the peeked byte is not counted until it is decoded
+		offset := d.InputOffset()
+		expect(d.More(), true, t, "TestDecodeMoreDoesNotConsumeInput")
+		expect(d.InputOffset(), offset, t, "TestDecodeMoreDoesNotConsumeInput")
+		var s string
+		check(d.Decode(&s))
+		out = append(out, s)
+	}
+	expect(strings.Join(out, ","), "a,bc,def", t, "TestDecodeMoreDoesNotConsumeInput")
+	expect(d.InputOffset(), int64(9), t, "TestDecodeMoreDoesNotConsumeInput")
+}
+
+func TestDecodeWithPresence(t *testing.T) {
+	type Patch struct {
+		Name    string
+		Enabled bool
+		Retries int
+		Inner   struct{ Level int }
+	}
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf).Encode(map[string]interface{}{
+		"Enabled": false, "Retries": 0, "Inner": map[string]int{"Level": 1}, "Unknown": 1,
+	}))
+	var p Patch
+	present, err := NewDecoder(buf).DecodeWithPresence(&p)
+	check(err)
+	expect(len(present), 3, t, "TestDecodeWithPresence")
+	for _, key := range []string{"Enabled", "Retries", "Inner"} {
+		expect(present[key], true, t, "TestDecodeWithPresence "+key)
+	}
+	expect(present["Name"], false, t, "TestDecodeWithPresence Name")
+	expect(present["Level"], false, t, "TestDecodeWithPresence Level")
+	expect(p.Inner.Level, 1, t, "TestDecodeWithPresence")
+
+	// the decoder doesn't keep recording on further calls
+	buf.Reset()
+	check(NewEncoder(buf).Encode(map[string]string{"Name": "a"}))
+	d := NewDecoder(buf)
+	present, err = d.DecodeWithPresence(&p)
+	check(err)
+	expect(len(present), 1, t, "TestDecodeWithPresence")
+	expect(d.presence == nil, true, t, "TestDecodeWithPresence")
+}
+
+func TestDecoderReset(t *testing.T) {
+	d := NewDecoder(bytes.NewReader(nil), WideInts)
+	inputs := [][]byte{
+		{0x18, 0x64},
+		{0x83, 0x01, 0x02, 0x03},
+		{0x63, 0x61, 0x62, 0x63},
+	}
+	expected := []string{"100", "[1 2 3]", "abc"}
+	for i, in := range inputs {
+		d.Reset(bytes.NewReader(in))
+		v, err := d.DecodeValue()
+		check(err)
+		if s, ok := v.(*[]interface{}); ok {
+			v = *s
+		}
+		expect(fmt.Sprint(v), expected[i], t, "TestDecoderReset")
+		expect(d.InputOffset(), int64(len(in)), t, "TestDecoderReset")
+	}
+
+	// data left unread by a failed decode doesn't leak into the next input
+	d.Reset(bytes.NewReader([]byte{0x82, 0x01}))
+	_, err := d.DecodeValue()
+	expect(err != nil, true, t, "TestDecoderReset")
+	d.Reset(bytes.NewReader([]byte{0x07}))
+	v, err := d.DecodeValue()
+	check(err)
+	expect(v, interface{}(uint64(7)), t, "TestDecoderReset")
+	expect(d.More(), false, t, "TestDecoderReset")
+}
+
+type SignedPayload struct {
+	Payload string
+	Nonce   uint8
+	Raw     RawMessage `cbor:",raw"`
+}
+
+func TestDecodeStructRawField(t *testing.T) {
+	type Envelope struct {
+		Signed SignedPayload
+		Sig    []byte
+		Bytes  []byte `cbor:",raw"`
+	}
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf).Encode(Envelope{Signed: SignedPayload{Payload: "hi", Nonce: 7}, Sig: []byte{1}}))
+	// the raw fields are not encoded
+	encoded := "a266536967" + "6e6564a2675061796c6f6164626869654e6f6e636507635369674101"
+	expect(fmt.Sprintf("%x", buf.Bytes()), encoded, t, "TestDecodeStructRawField")
+
+	var out Envelope
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&out))
+	expect(out.Signed.Payload, "hi", t, "TestDecodeStructRawField")
+	expect(out.Signed.Nonce, uint8(7), t, "TestDecodeStructRawField")
+	expect(fmt.Sprintf("%x", out.Bytes), encoded, t, "TestDecodeStructRawField")
+	expect(fmt.Sprintf("%x", []byte(out.Signed.Raw)), "a2675061796c6f6164626869654e6f6e636507", t, "TestDecodeStructRawField")
+
+	// the captured bytes decode into the same values
+	var again SignedPayload
+	check(NewDecoder(bytes.NewReader(out.Signed.Raw)).Decode(&again))
+	expect(again.Payload, out.Signed.Payload, t, "TestDecodeStructRawField")
+	expect(again.Nonce, out.Signed.Nonce, t, "TestDecodeStructRawField")
+	expect(bytes.Equal(again.Raw, out.Signed.Raw), true, t, "TestDecodeStructRawField")
+
+	// errors keep the offset inside the whole input
+	buf.Reset()
+	check(NewEncoder(buf).Encode(map[string]interface{}{"Signed": map[string]interface{}{"Other": 1}}))
+	err := NewDecoder(buf, func(dec *Decoder) { dec.noUnknown = true }).Decode(&out)
+	expect(err != nil, true, t, "TestDecodeStructRawField")
+	expect(strings.HasPrefix(err.Error(), "at byte 9: "), true, t, "TestDecodeStructRawField "+err.Error())
+
+	// unexported fields are not captured
+	type Hidden struct {
+		Payload string
+		raw     []byte `cbor:",raw"`
+	}
+	var hidden Hidden
+	check(NewDecoder(bytes.NewReader(again.Raw)).Decode(&hidden))
+	expect(hidden.Payload, "hi", t, "TestDecodeStructRawField")
+	expect(hidden.raw == nil, true, t, "TestDecodeStructRawField")
+}
+
+type ServerConfig struct {
+	Name  string
+	Host  string  `cbor:"host,default=localhost"`
+	Port  int     `cbor:"port,default=8080"`
+	Debug bool    `cbor:"debug,default=true"`
+	Ratio float64 `cbor:",default=0.5"`
+}
+
+func TestDecodeStructDefaults(t *testing.T) {
+	buf := []byte{
+		0xa2, 0x64, 0x4e, 0x61, 0x6d, 0x65, 0x61, 0x78,
+		0x64, 0x68, 0x6f, 0x73, 0x74, 0x61, 0x68,
+	}
+	d := NewDecoder(bytes.NewReader(buf))
+	var c ServerConfig
+	check(d.Decode(&c))
+	expect(c.Name, "x", t, "TestDecodeStructDefaults")
+	expect(c.Host, "h", t, "TestDecodeStructDefaults")
+	expect(c.Port, 8080, t, "TestDecodeStructDefaults")
+	expect(c.Debug, true, t, "TestDecodeStructDefaults")
+	expect(c.Ratio, 0.5, t, "TestDecodeStructDefaults")
+
+	// arrays decoded into indexed fields get the defaults too
+	type Point struct {
+		X int `cbor:"0,index"`
+		Y int `cbor:"1,index,default=7"`
+		Z int `cbor:"2,index,default=9"`
+	}
+	var p Point
+	check(NewDecoder(bytes.NewReader([]byte{0x82, 0x01, 0x02})).Decode(&p))
+	expect(p, Point{1, 2, 9}, t, "TestDecodeStructDefaults indexed")
+}
+
+func TestDecodeUntilEOF(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf)
+	for _, n := range []uint8{1, 2, 200} {
+		check(e.Encode(n))
+	}
+	d := NewDecoder(bytes.NewReader(buf.Bytes()))
+	var values []uint8
+	for {
+		var n uint8
+		err := d.Decode(&n)
+		if err == io.EOF {
+			break
+		}
+		check(err)
+		values = append(values, n)
+	}
+	expect(len(values), 3, t, "TestDecodeUntilEOF")
+	expect(values[2], uint8(200), t, "TestDecodeUntilEOF")
+	expect(d.Decode(new(uint8)), io.EOF, t, "TestDecodeUntilEOF")
+}
+
+func TestDecodeTruncatedLastItem(t *testing.T) {
+	cases := []string{
+		"8201",   // definite array
+		"9f01",   // indefinite array
+		"18",     // header argument
+		"a16161", // map value
+	}
+	for _, c := range cases {
+		in, _ := hex.DecodeString("01" + c)
+		d := NewDecoder(bytes.NewReader(in))
+		var v interface{}
+		check(d.Decode(&v))
+		expect(v, uint8(1), t, "TestDecodeTruncatedLastItem "+c)
+		err, ok := d.Decode(&v).(*DecodeError)
+		expect(ok, true, t, "TestDecodeTruncatedLastItem "+c)
+		_, ok = err.Err.(ParserErr)
+		expect(ok, true, t, "TestDecodeTruncatedLastItem "+c)
+
+		d = NewDecoder(bytes.NewReader(in))
+		_, gerr := d.DecodeGeneric()
+		check(gerr)
+		_, gerr = d.DecodeGeneric()
+		expect(gerr != nil && gerr != io.EOF, true, t, "TestDecodeTruncatedLastItem generic "+c)
+	}
+}
+
+func TestDecodeStructFieldHooks(t *testing.T) {
+	buf := []byte{
+		0xa2, 0x64, 0x4e, 0x61, 0x6d, 0x65, 0x61, 0x78,
+		0x64, 0x68, 0x6f, 0x73, 0x74, 0x61, 0x68,
+	}
+	var calls []string
+	before := func(field string, v reflect.Value) {
+		calls = append(calls, "before "+field)
+	}
+	after := func(field string, v reflect.Value) {
+		calls = append(calls, "after "+field)
+		if field == "Host" {
+			v.SetString(strings.ToUpper(v.String()))
+		}
+	}
+	var c ServerConfig
+	check(NewDecoder(bytes.NewReader(buf), WithFieldHook(before, after)).Decode(&c))
+	expect(strings.Join(calls, ","), "before Name,after Name,before Host,after Host", t, "TestDecodeStructFieldHooks")
+	expect(c.Host, "H", t, "TestDecodeStructFieldHooks")
+
+	calls = nil
+	check(NewDecoder(bytes.NewReader(buf), WithFieldHook(nil, after)).Decode(&c))
+	expect(strings.Join(calls, ","), "after Name,after Host", t, "TestDecodeStructFieldHooks")
+}
+
+func TestDecodeMapWithIntKeys(t *testing.T) {
+	// {1: "a", 24: "b", 1000: "c", -1: "d", -300: "e"}
+	buf := []byte{
+		0xa5, 0x01, 0x61, 0x61, 0x18, 0x18, 0x61, 0x62, 0x19, 0x03, 0xe8, 0x61, 0x63,
+		0x20, 0x61, 0x64, 0x39, 0x01, 0x2b, 0x61, 0x65,
+	}
+	var m map[int]interface{}
+	check(NewDecoder(bytes.NewReader(buf)).Decode(&m))
+	expect(len(m), 5, t, "TestDecodeMapWithIntKeys")
+	expect(m[1], "a", t, "TestDecodeMapWithIntKeys")
+	expect(m[24], "b", t, "TestDecodeMapWithIntKeys")
+	expect(m[1000], "c", t, "TestDecodeMapWithIntKeys")
+	expect(m[-1], "d", t, "TestDecodeMapWithIntKeys")
+	expect(m[-300], "e", t, "TestDecodeMapWithIntKeys")
+}
+
+// OrderID is decoded from texts like "ORD-42"
+type OrderID struct {
+	Number int
+}
+
+func (id *OrderID) UnmarshalText(text []byte) error {
+	s := string(text)
+	if !strings.HasPrefix(s, "ORD-") {
+		return fmt.Errorf("invalid order id %q", s)
+	}
+	n, err := strconv.Atoi(s[4:])
+	if err != nil {
+		return err
+	}
+	id.Number = n
+	return nil
+}
+
+type Order struct {
+	ID    OrderID
+	Price uint16
+}
+
+func TestDecodeTextUnmarshaler(t *testing.T) {
+	var id OrderID
+	buf := []byte{0x66, 0x4f, 0x52, 0x44, 0x2d, 0x34, 0x32}
+	check(NewDecoder(bytes.NewReader(buf)).Decode(&id))
+	expect(id.Number, 42, t, "TestDecodeTextUnmarshaler")
+
+	// {"ID": "ORD-7", "Price": 1000}
+	buf = []byte{
+		0xa2, 0x62, 0x49, 0x44, 0x65, 0x4f, 0x52, 0x44, 0x2d, 0x37,
+		0x65, 0x50, 0x72, 0x69, 0x63, 0x65, 0x19, 0x03, 0xe8,
+	}
+	var o Order
+	check(NewDecoder(bytes.NewReader(buf)).Decode(&o))
+	expect(o.ID.Number, 7, t, "TestDecodeTextUnmarshaler")
+	expect(o.Price, uint16(1000), t, "TestDecodeTextUnmarshaler")
+
+	buf = []byte{0x63, 0x41, 0x42, 0x43}
+	err := NewDecoder(bytes.NewReader(buf)).Decode(&id)
+	expect(errors.Unwrap(err).Error(), `invalid order id "ABC"`, t, "TestDecodeTextUnmarshaler")
+}
+
+type IndexedRecord struct {
+	Amount int8 `cbor:"1,index"`
+	Name   string
+	Fun    bool `cbor:"0,index"`
+}
+
+func TestDecodeArrayIntoIndexedStruct(t *testing.T) {
+	buf := []byte{0x82, 0xf5, 0x21}
+	var a IndexedRecord
+	check(NewDecoder(bytes.NewReader(buf)).Decode(&a))
+	expect(a.Fun, true, t, "TestDecodeArrayIntoIndexedStruct")
+	expect(a.Amount, int8(-2), t, "TestDecodeArrayIntoIndexedStruct")
+	expect(a.Name, "", t, "TestDecodeArrayIntoIndexedStruct")
+
+	buf = []byte{0x9f, 0xf4, 0x05, 0x61, 0x61, 0xff}
+	var b IndexedRecord
+	check(NewDecoder(bytes.NewReader(buf)).Decode(&b))
+	expect(b.Fun, false, t, "TestDecodeArrayIntoIndexedStruct")
+	expect(b.Amount, int8(5), t, "TestDecodeArrayIntoIndexedStruct")
+
+	err := NewDecoder(bytes.NewReader(buf), func(dec *Decoder) { dec.strict = true }).Decode(&b)
+	expect(errors.Unwrap(err).Error(), "strict-mode: array element 2 doesn't match with any field", t, "TestDecodeArrayIntoIndexedStruct")
+}
+
+func TestDecodeRejectUnknownFields(t *testing.T) {
+	type MyType struct {
+		Fun bool
+		Amt int8
+	}
+	// {"Fun": true, "Foo": 1, "Amt": -2}
+	buf := []byte{
+		0xa3, 0x63, 0x46, 0x75, 0x6e, 0xf5, 0x63, 0x46, 0x6f, 0x6f, 0x01,
+		0x63, 0x41, 0x6d, 0x74, 0x21,
+	}
+	var a MyType
+	check(NewDecoder(bytes.NewReader(buf)).Decode(&a))
+	expect(a.Amt, int8(-2), t, "TestDecodeRejectUnknownFields")
+
+	err := NewDecoder(bytes.NewReader(buf), RejectUnknownFields).Decode(&a)
+	expect(err != nil, true, t, "TestDecodeRejectUnknownFields")
+	expect(errors.Unwrap(err).Error(), "key Foo doesn't match with any field", t, "TestDecodeRejectUnknownFields")
+
+	// lengths are not checked, so missing fields are fine
+	buf = []byte{0xa1, 0x63, 0x46, 0x75, 0x6e, 0xf4}
+	check(NewDecoder(bytes.NewReader(buf), RejectUnknownFields).Decode(&a))
+	expect(a.Fun, false, t, "TestDecodeRejectUnknownFields")
+}
+
+func TestDecodeInterfaceBytesAsString(t *testing.T) {
+	definite := []byte{0x43, 0x61, 0x62, 0x63}
+	indefinite := []byte{0x5f, 0x42, 0x61, 0x62, 0x41, 0x63, 0xff}
+	invalid := []byte{0x42, 0xff, 0xfe}
+	for _, buf := range [][]byte{definite, indefinite} {
+		var a, b interface{}
+		check(NewDecoder(bytes.NewReader(buf)).Decode(&a))
+		expect(fmt.Sprintf("%T %s", a, a), "[]uint8 abc", t, "TestDecodeInterfaceBytesAsString")
+		check(NewDecoder(bytes.NewReader(buf), BytesAsString()).Decode(&b))
+		expect(b, "abc", t, "TestDecodeInterfaceBytesAsString")
+	}
+	var c interface{}
+	check(NewDecoder(bytes.NewReader(invalid), BytesAsString()).Decode(&c))
+	expect(fmt.Sprintf("%T", c), "[]uint8", t, "TestDecodeInterfaceBytesAsString")
+}
+
+func TestDecodeContinueOnError(t *testing.T) {
+	type Product struct {
+		Name  string
+		Price uint16
+		Tags  []uint8
+	}
+	// {"Name": "x", "Price": "abc", "Tags": [1, 2]}
+	buf := []byte{
+		0xa3, 0x64, 0x4e, 0x61, 0x6d, 0x65, 0x61, 0x78,
+		0x65, 0x50, 0x72, 0x69, 0x63, 0x65, 0x63, 0x61, 0x62, 0x63,
+		0x64, 0x54, 0x61, 0x67, 0x73, 0x82, 0x01, 0x02,
+	}
+	var p Product
+	err := NewDecoder(bytes.NewReader(buf), ContinueOnError).Decode(&p)
+	errs, ok := err.(DecodeErrors)
+	expect(ok, true, t, "TestDecodeContinueOnError")
+	expect(len(errs), 1, t, "TestDecodeContinueOnError")
+	expect(strings.HasPrefix(errs[0].Error(), "field Price: "), true, t, "TestDecodeContinueOnError")
+	expect(p.Name, "x", t, "TestDecodeContinueOnError")
+	expect(p.Price, uint16(0), t, "TestDecodeContinueOnError")
+	expect(len(p.Tags), 2, t, "TestDecodeContinueOnError")
+	expect(p.Tags[1], uint8(2), t, "TestDecodeContinueOnError")
+
+	var q Product
+	err = NewDecoder(bytes.NewReader(buf)).Decode(&q)
+	expect(err != nil, true, t, "TestDecodeContinueOnError")
+	expect(len(q.Tags), 0, t, "TestDecodeContinueOnError")
+
+	// nested errors are recorded with their path and offset
+	// [{"Name": "x", "Price": "abc", "Tags": [1, 2]}, {"Tags": [3], "Price": "abc"}]
+	nested := append([]byte{0x82}, buf...)
+	nested = append(nested, 0xa2, 0x64, 0x54, 0x61, 0x67, 0x73, 0x81, 0x03,
+		0x65, 0x50, 0x72, 0x69, 0x63, 0x65, 0x63, 0x61, 0x62, 0x63)
+	var products []Product
+	err = NewDecoder(bytes.NewReader(nested), ContinueOnError).Decode(&products)
+	errs, ok = err.(DecodeErrors)
+	expect(ok, true, t, "TestDecodeContinueOnError")
+	expect(len(errs), 2, t, "TestDecodeContinueOnError")
+	expect(strings.HasPrefix(errs[0].Error(), "index 0: field Price: at byte 15: "), true, t, "TestDecodeContinueOnError "+errs[0].Error())
+	expect(strings.HasPrefix(errs[1].Error(), "index 1: field Price: at byte 41: "), true, t, "TestDecodeContinueOnError "+errs[1].Error())
+	var decErr *DecodeError
+	expect(errors.As(errs[1], &decErr) && decErr.Offset == 41, true, t, "TestDecodeContinueOnError")
+	expect(len(products), 2, t, "TestDecodeContinueOnError")
+	expect(products[1].Tags[0], uint8(3), t, "TestDecodeContinueOnError")
+}
+
+func TestDecodeSliceOfMaps(t *testing.T) {
+	in := []map[string]interface{}{
+		{"id": uint8(1), "name": "a"},
+		{"id": uint16(300), "tags": []interface{}{"x"}},
+	}
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf).Encode(in))
+	var out []map[string]interface{}
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&out))
+	expect(len(out), 2, t, "TestDecodeSliceOfMaps")
+	expect(out[0]["id"], uint8(1), t, "TestDecodeSliceOfMaps")
+	expect(out[0]["name"], "a", t, "TestDecodeSliceOfMaps")
+	expect(out[1]["id"], uint16(300), t, "TestDecodeSliceOfMaps")
+	tags := *out[1]["tags"].(*[]interface{})
+	expect(tags[0], "x", t, "TestDecodeSliceOfMaps")
+}
+
+func TestDecodeFloat16SpecialValues(t *testing.T) {
+	tests := []struct {
+		input []byte
+		bits  uint32
+	}{
+		{[]byte{0xf9, 0x7c, 0x00}, 0x7f800000}, // +Inf
+		{[]byte{0xf9, 0xfc, 0x00}, 0xff800000}, // -Inf
+		{[]byte{0xf9, 0x7e, 0x00}, 0x7fc00000}, // NaN
+		{[]byte{0xf9, 0x00, 0x01}, 0x33800000}, // smallest subnormal 2^-24
+		{[]byte{0xf9, 0x83, 0xff}, 0xb87fc000}, // largest negative subnormal
+		{[]byte{0xf9, 0x80, 0x00}, 0x80000000}, // -0
+		{[]byte{0xf9, 0x7b, 0xff}, 0x477fe000}, // 65504
+	}
+	for _, test := range tests {
+		msg := fmt.Sprintf("TestDecodeFloat16SpecialValues %x", test.input)
+		var f float16
+		check(NewDecoder(bytes.NewReader(test.input)).Decode(&f))
+		expect(math.Float32bits(float32(f)), test.bits, t, msg)
+
+		var v interface{}
+		check(NewDecoder(bytes.NewReader(test.input)).Decode(&v))
+		expect(math.Float32bits(float32(v.(float16))), test.bits, t, msg)
+	}
+
+	// blind decoded float32 values keep their bits
+	var v interface{}
+	check(NewDecoder(bytes.NewReader([]byte{0xfa, 0x40, 0x49, 0x0f, 0xdb})).Decode(&v))
+	expect(math.Float32bits(v.(float32)), uint32(0x40490fdb), t, "TestDecodeFloat16SpecialValues")
+}
+
+func TestDecodeBlindFloat32(t *testing.T) {
+	var v interface{}
+	check(NewDecoder(bytes.NewReader([]byte{0xfa, 0x47, 0xc3, 0x50, 0x00})).Decode(&v))
+	expect(v, interface{}(float32(100000.0)), t, "TestDecodeBlindFloat32")
+}
+
+func TestDecodeLenientNumbers(t *testing.T) {
+	var f float64
+	check(NewDecoder(bytes.NewReader([]byte{0x0a}), WithLenientNumbers()).Decode(&f))
+	expect(f, 10.0, t, "TestDecodeLenientNumbers")
+	check(NewDecoder(bytes.NewReader([]byte{0x38, 0x63}), WithLenientNumbers()).Decode(&f))
+	expect(f, -100.0, t, "TestDecodeLenientNumbers")
+
+	var f32 float32
+	check(NewDecoder(bytes.NewReader([]byte{0x19, 0x03, 0xe8}), WithLenientNumbers()).Decode(&f32))
+	expect(f32, float32(1000), t, "TestDecodeLenientNumbers")
+
+	// 2^53 + 1 and 2^24 + 1 can't be represented exactly
+	err := NewDecoder(bytes.NewReader([]byte{0x1b, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}),
+		WithLenientNumbers()).Decode(&f)
+	expect(err != nil, true, t, "TestDecodeLenientNumbers")
+	err = NewDecoder(bytes.NewReader([]byte{0x1a, 0x01, 0x00, 0x00, 0x01}), WithLenientNumbers()).Decode(&f32)
+	expect(err != nil, true, t, "TestDecodeLenientNumbers")
+
+	// integers are not accepted by default
+	err = NewDecoder(bytes.NewReader([]byte{0x0a})).Decode(&f)
+	expect(err != nil, true, t, "TestDecodeLenientNumbers")
+
+	var s struct {
+		Price float64 `cbor:"price"`
+	}
+	buf := []byte{0xa1, 0x65, 'p', 'r', 'i', 'c', 'e', 0x18, 0x2a}
+	check(NewDecoder(bytes.NewReader(buf), WithLenientNumbers()).Decode(&s))
+	expect(s.Price, 42.0, t, "TestDecodeLenientNumbers")
+}
+
+func TestDecodeFieldPresence(t *testing.T) {
+	d := NewDecoder(bytes.NewReader([]byte{0xf6, 0x61, 0x78, 0xf7, 0x00}))
+	s := "old"
+	present, err := d.DecodeField(&s)
+	check(err)
+	expect(present, false, t, "TestDecodeFieldPresence")
+	expect(s, "", t, "TestDecodeFieldPresence")
+
+	present, err = d.DecodeField(&s)
+	check(err)
+	expect(present, true, t, "TestDecodeFieldPresence")
+	expect(s, "x", t, "TestDecodeFieldPresence")
+
+	var n uint8 = 7
+	present, err = d.DecodeField(&n)
+	check(err)
+	expect(present, false, t, "TestDecodeFieldPresence")
+	expect(n, uint8(0), t, "TestDecodeFieldPresence")
+
+	// a present zero value is still present
+	present, err = d.DecodeField(&n)
+	check(err)
+	expect(present, true, t, "TestDecodeFieldPresence")
+	expect(n, uint8(0), t, "TestDecodeFieldPresence")
+
+	present, err = d.DecodeField(&n)
+	expect(err, io.EOF, t, "TestDecodeFieldPresence")
+	expect(present, false, t, "TestDecodeFieldPresence")
+}
+
+func TestDecodeExactlyOne(t *testing.T) {
+	var s string
+	check(NewDecoder(bytes.NewReader([]byte{0x62, 0x68, 0x69}), ExactlyOne).Decode(&s))
+	expect(s, "hi", t, "TestDecodeExactlyOne")
+
+	var a []uint
+	check(NewDecoder(bytes.NewReader([]byte{0x9f, 0x01, 0x02, 0xff}), ExactlyOne).Decode(&a))
+	expect(len(a), 2, t, "TestDecodeExactlyOne")
+
+	err := NewDecoder(bytes.NewReader([]byte{0x62, 0x68, 0x69, 0x00}), ExactlyOne).Decode(&s)
+	expect(err != nil, true, t, "TestDecodeExactlyOne")
+	expect(strings.Contains(err.Error(), "unexpected trailing data"), true, t, "TestDecodeExactlyOne")
+
+	// without the option trailing data is left for the next Decode
+	check(NewDecoder(bytes.NewReader([]byte{0x62, 0x68, 0x69, 0x00})).Decode(&s))
+}
+
+func TestDecodeInterfaceRawBytes(t *testing.T) {
+	var v interface{}
+	check(NewDecoder(bytes.NewReader([]byte{0x43, 0x01, 0x02, 0x03})).Decode(&v))
+	b, ok := v.([]byte)
+	expect(ok, true, t, "TestDecodeInterfaceRawBytes")
+	expect(bytes.Equal(b, []byte{1, 2, 3}), true, t, "TestDecodeInterfaceRawBytes")
+
+	// chunked byte strings are joined together
+	check(NewDecoder(bytes.NewReader([]byte{0x5f, 0x41, 0x01, 0x42, 0x02, 0x03, 0xff})).Decode(&v))
+	b, ok = v.([]byte)
+	expect(ok, true, t, "TestDecodeInterfaceRawBytes")
+	expect(bytes.Equal(b, []byte{1, 2, 3}), true, t, "TestDecodeInterfaceRawBytes")
+}
+
+func TestDecodeIntoPopulatedInterfaces(t *testing.T) {
+	// {"a": 1} into a map which values are already set
+	buf := []byte{0xa1, 0x61, 0x61, 0x01}
+	m := map[string]interface{}{"a": "x", "b": true}
+	check(NewDecoder(bytes.NewReader(buf)).Decode(&m))
+	expect(m["a"], interface{}(uint8(1)), t, "TestDecodeIntoPopulatedInterfaces")
+	expect(m["b"], interface{}(true), t, "TestDecodeIntoPopulatedInterfaces")
+
+	// pointers held by interfaces are decoded in place
+	n := uint8(0)
+	var v interface{} = &n
+	check(NewDecoder(bytes.NewReader([]byte{0x05})).Decode(&v))
+	expect(n, uint8(5), t, "TestDecodeIntoPopulatedInterfaces")
+}
+
+func TestDecodeIgnoreUndefined(t *testing.T) {
+	type Flags struct {
+		Enabled *bool `cbor:"enabled"`
+		Visible *bool `cbor:"visible"`
+		Pinned  *bool `cbor:"pinned"`
+	}
+	// {"enabled": true, "visible": null, "pinned": undefined}
+	buf := []byte{0xa3,
+		0x67, 'e', 'n', 'a', 'b', 'l', 'e', 'd', 0xf5,
+		0x67, 'v', 'i', 's', 'i', 'b', 'l', 'e', 0xf6,
+		0x66, 'p', 'i', 'n', 'n', 'e', 'd', 0xf7,
+	}
+	visible, pinned := false, false
+	f := Flags{Visible: &visible, Pinned: &pinned}
+	check(NewDecoder(bytes.NewReader(buf), IgnoreUndefined(), WithStructMerge()).Decode(&f))
+	expect(f.Enabled != nil && *f.Enabled, true, t, "TestDecodeIgnoreUndefined")
+	expect(f.Visible == nil, true, t, "TestDecodeIgnoreUndefined")
+	expect(f.Pinned == &pinned, true, t, "TestDecodeIgnoreUndefined")
+	expect(pinned, false, t, "TestDecodeIgnoreUndefined")
+
+	// the struct is still reset without WithStructMerge
+	f = Flags{Pinned: &pinned}
+	check(NewDecoder(bytes.NewReader(buf), IgnoreUndefined()).Decode(&f))
+	expect(f.Pinned == nil, true, t, "TestDecodeIgnoreUndefined")
+
+	// by default undefined is decoded as null
+	f = Flags{Pinned: &pinned}
+	check(NewDecoder(bytes.NewReader(buf)).Decode(&f))
+	expect(f.Pinned == nil, true, t, "TestDecodeIgnoreUndefined")
+}
+
+type JSONTagged struct {
+	UserName string `json:"user_name"`
+	Email    string `json:"email,omitempty"`
+	Age      uint8  `json:"age" cbor:"years"`
+	Secret   string `json:"-"`
+}
+
+func TestJSONTagFallback(t *testing.T) {
+	v := JSONTagged{UserName: "gopher", Email: "g@example.com", Age: 12, Secret: "s3cr3t"}
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf, EncodeJSONTagFallback(), WithCanonical()).Encode(v))
+	// cbor tags take precedence over json tags
+	var m map[string]interface{}
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&m))
+	expect(len(m), 3, t, "TestJSONTagFallback")
+	expect(m["user_name"], interface{}("gopher"), t, "TestJSONTagFallback")
+	expect(m["email"], interface{}("g@example.com"), t, "TestJSONTagFallback")
+	expect(m["years"], interface{}(uint8(12)), t, "TestJSONTagFallback")
+
+	var got JSONTagged
+	check(NewDecoder(bytes.NewReader(buf.Bytes()), WithJSONTagFallback()).Decode(&got))
+	expect(got, JSONTagged{UserName: "gopher", Email: "g@example.com", Age: 12}, t, "TestJSONTagFallback")
+
+	// json tags are ignored by default
+	buf.Reset()
+	check(NewEncoder(buf).Encode(v))
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&m))
+	expect(m["UserName"], interface{}("gopher"), t, "TestJSONTagFallback")
+	expect(m["Secret"], interface{}("s3cr3t"), t, "TestJSONTagFallback")
+
+	// fields tagged json:"-" are not decoded either
+	got = JSONTagged{}
+	check(NewDecoder(bytes.NewReader(buf.Bytes()), WithJSONTagFallback()).Decode(&got))
+	expect(got.Secret, "", t, "TestJSONTagFallback")
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&got))
+	expect(got.Secret, "s3cr3t", t, "TestJSONTagFallback")
+}
+
+type CompactClaims struct {
+	Active  bool   `cbor:"1,keyasint"`
+	Balance int8   `cbor:"2,keyasint"`
+	Issuer  string `cbor:"-1,keyasint"`
+	Subject string `cbor:"sub"`
+}
+
+func TestDecodeMapWithIntKeysIntoStruct(t *testing.T) {
+	// {1: true, 2: -2}
+	var c CompactClaims
+	check(NewDecoder(bytes.NewReader([]byte{0xa2, 0x01, 0xf5, 0x02, 0x21})).Decode(&c))
+	expect(c, CompactClaims{Active: true, Balance: -2}, t, "TestDecodeMapWithIntKeysIntoStruct")
+
+	// integer and string keys can be mixed, unknown integer keys are skipped
+	c = CompactClaims{}
+	buf := []byte{0xa4, 0x20, 0x62, 'm', 'e', 0x63, 's', 'u', 'b', 0x62, 'y', 'o', 0x18, 0x63, 0x00, 0x02, 0x01}
+	check(NewDecoder(bytes.NewReader(buf)).Decode(&c))
+	expect(c, CompactClaims{Balance: 1, Issuer: "me", Subject: "yo"}, t, "TestDecodeMapWithIntKeysIntoStruct")
+
+	// the encoder writes keyasint fields keys as integers
+	out := bytes.NewBuffer(nil)
+	check(NewEncoder(out, WithCanonical()).Encode(CompactClaims{Active: true, Balance: -2, Issuer: "me", Subject: "yo"}))
+	expect(fmt.Sprintf("%x", out.Bytes()), "a401f5022120626d656373756262796f", t, "TestDecodeMapWithIntKeysIntoStruct")
+	var got CompactClaims
+	check(NewDecoder(bytes.NewReader(out.Bytes())).Decode(&got))
+	expect(got, CompactClaims{Active: true, Balance: -2, Issuer: "me", Subject: "yo"}, t, "TestDecodeMapWithIntKeysIntoStruct")
+}
+
+func TestEncodeDecodeFramed(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf)
+	check(e.EncodeFramed("hello"))
+	check(e.EncodeFramed([]uint{1, 2, 3}))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "000000066568656c6c6f"+"0000000483010203", t, "TestEncodeDecodeFramed")
+
+	d := NewDecoder(bytes.NewReader(buf.Bytes()))
+	var s string
+	check(d.DecodeFramed(&s))
+	expect(s, "hello", t, "TestEncodeDecodeFramed")
+	var a []uint8
+	check(d.DecodeFramed(&a))
+	expect(fmt.Sprintf("%x", a), "010203", t, "TestEncodeDecodeFramed")
+	expect(d.DecodeFramed(&s), io.EOF, t, "TestEncodeDecodeFramed")
+
+	// frames must contain exactly one item
+	d = NewDecoder(bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x02, 0x01, 0x02}))
+	var n uint8
+	expect(d.DecodeFramed(&n) != nil, true, t, "TestEncodeDecodeFramed")
+	// truncated frame
+	d = NewDecoder(bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x02, 0x01}))
+	expect(d.DecodeFramed(&n) != nil, true, t, "TestEncodeDecodeFramed")
+}
+
+func TestDecodeFramedMaxFrameSize(t *testing.T) {
+	// a huge length prefix is rejected before reading the frame
+	var n uint8
+	d := NewDecoder(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff, 0x01}))
+	err := d.DecodeFramed(&n)
+	expect(err.Error(), "frame of 4294967295 bytes exceeds the maximum frame size of 16777216",
+		t, "TestDecodeFramedMaxFrameSize")
+
+	d = NewDecoder(bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x02, 0x18, 0x2a}), MaxFrameSize(1))
+	err = d.DecodeFramed(&n)
+	expect(err.Error(), "frame of 2 bytes exceeds the maximum frame size of 1",
+		t, "TestDecodeFramedMaxFrameSize")
+
+	d = NewDecoder(bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x02, 0x18, 0x2a}), MaxFrameSize(2))
+	check(d.DecodeFramed(&n))
+	expect(n, uint8(42), t, "TestDecodeFramedMaxFrameSize")
+}
+
+func TestDecodeBigFloatIntoFloat(t *testing.T) {
+	var f big.Float
+	check(NewDecoder(bytes.NewReader([]byte{0xc5, 0x82, 0x20, 0x03})).Decode(&f))
+	expect(f.Cmp(big.NewFloat(1.5)), 0, t, "TestDecodeBigFloatIntoFloat")
+
+	// mantissas wider than a float64 are kept exactly: (2^70 + 1) * 2^-3
+	m, _ := new(big.Int).SetString("1180591620717411303425", 10)
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf).Encode(new(big.Rat).SetFrac(m, big.NewInt(8))))
+	type Quantity struct {
+		Amount big.Float `cbor:"amount"`
+	}
+	var q Quantity
+	raw := append([]byte{0xa1, 0x66, 'a', 'm', 'o', 'u', 'n', 't'}, buf.Bytes()...)
+	check(NewDecoder(bytes.NewReader(raw)).Decode(&q))
+	expected := new(big.Float).SetInt(m)
+	expected.SetMantExp(expected, -3)
+	expect(q.Amount.Cmp(expected), 0, t, "TestDecodeBigFloatIntoFloat")
+	expect(q.Amount.MinPrec() > 64, true, t, "TestDecodeBigFloatIntoFloat")
+}
+
+func TestDecodeSliceReusesCapacity(t *testing.T) {
+	// definite length array
+	a := make([]uint, 2, 8)
+	a[0], a[1] = 7, 7
+	backing := &a[:1][0]
+	check(NewDecoder(bytes.NewReader([]byte{0x83, 0x01, 0x02, 0x03})).Decode(&a))
+	expect(len(a), 3, t, "TestDecodeSliceReusesCapacity")
+	expect(cap(a), 8, t, "TestDecodeSliceReusesCapacity")
+	expect(&a[0] == backing, true, t, "TestDecodeSliceReusesCapacity")
+	expect(a[0]+a[1]+a[2], uint(6), t, "TestDecodeSliceReusesCapacity")
+
+	// indefinite length array
+	check(NewDecoder(bytes.NewReader([]byte{0x9f, 0x04, 0x05, 0xff})).Decode(&a))
+	expect(len(a), 2, t, "TestDecodeSliceReusesCapacity")
+	expect(&a[0] == backing, true, t, "TestDecodeSliceReusesCapacity")
+	expect(a[0]+a[1], uint(9), t, "TestDecodeSliceReusesCapacity")
+
+	// not enough capacity, a new backing array is allocated
+	small := make([]uint, 0, 1)
+	check(NewDecoder(bytes.NewReader([]byte{0x82, 0x01, 0x02})).Decode(&small))
+	expect(len(small), 2, t, "TestDecodeSliceReusesCapacity")
+	expect(small[0]+small[1], uint(3), t, "TestDecodeSliceReusesCapacity")
+}
+
+func TestDecodeIntoShorterPresizedSlice(t *testing.T) {
+	in := []byte{0x84, 0x01, 0x02, 0x03, 0x04}
+	a := make([]int, 2)
+	check(NewDecoder(bytes.NewReader(in)).Decode(&a))
+	expect(fmt.Sprint(a), "[1 2 3 4]", t, "TestDecodeIntoShorterPresizedSlice")
+
+	type Series struct {
+		Points []int
+	}
+	s := Series{Points: make([]int, 2)}
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf).Encode(map[string][]int{"Points": {5, 6, 7, 8}}))
+	check(NewDecoder(buf).Decode(&s))
+	expect(fmt.Sprint(s.Points), "[5 6 7 8]", t, "TestDecodeIntoShorterPresizedSlice")
+}
+
+func TestDecodeErrorOffset(t *testing.T) {
+	// [1, 0(h'61')] into []time.Time, the byte string starts at byte 3
+	d := NewDecoder(bytes.NewReader([]byte{0x82, 0x01, 0xc0, 0x41, 0x61}))
+	var a []time.Time
+	err := d.Decode(&a)
+	var decErr *DecodeError
+	expect(errors.As(err, &decErr), true, t, "TestDecodeErrorOffset")
+	expect(decErr.Offset, int64(3), t, "TestDecodeErrorOffset")
+	expect(err.Error(), "at byte 3: expected UTF-8 string, found cborByteString", t, "TestDecodeErrorOffset")
+
+	d = NewDecoder(bytes.NewReader([]byte{0x01, 0x19, 0x03, 0xe8}))
+	var n uint8
+	var m uint16
+	check(d.Decode(&n))
+	expect(d.InputOffset(), int64(1), t, "TestDecodeErrorOffset")
+	check(d.Decode(&m))
+	expect(d.InputOffset(), int64(4), t, "TestDecodeErrorOffset")
+	expect(d.Decode(&m), io.EOF, t, "TestDecodeErrorOffset")
+}
+
+func TestDecodeTaggedItemsIntoInterfaceSlice(t *testing.T) {
+	// [1(1363896240), 2(h'010000000000000000'), "abcd"]
+	definite := "83c11a514b67b0c249010000000000000000646162636480"
+	indefinite := "9fc11a514b67b0c2490100000000000000006461626364ff"
+	for _, c := range []string{definite, indefinite} {
+		in, _ := hex.DecodeString(c)
+		// the elements of a reused slice are replaced by the tagged values
+		a := []interface{}{1, 2, 3, 4}
+		check(NewDecoder(bytes.NewReader(in)).Decode(&a))
+		expect(len(a), 3, t, "TestDecodeTaggedItemsIntoInterfaceSlice")
+		tm, ok := a[0].(time.Time)
+		expect(ok, true, t, "TestDecodeTaggedItemsIntoInterfaceSlice")
+		expect(tm.Unix(), int64(1363896240), t, "TestDecodeTaggedItemsIntoInterfaceSlice")
+		n, ok := a[1].(*big.Int)
+		expect(ok, true, t, "TestDecodeTaggedItemsIntoInterfaceSlice")
+		expect(n.String(), "18446744073709551616", t, "TestDecodeTaggedItemsIntoInterfaceSlice")
+		expect(a[2], interface{}("abcd"), t, "TestDecodeTaggedItemsIntoInterfaceSlice")
+	}
+}
+
+func TestDecodeHeterogeneousTaggedArray(t *testing.T) {
+	cases := []struct{ in, typ, value string }{
+		{"d82076687474703a2f2f7777772e6578616d706c652e636f6d", "*url.URL", "http://www.example.com"},
+		{"c074323031332d30332d32315432303a30343a30305a", "time.Time", "2013-03-21 20:04:00 +0000 UTC"},
+		{"c1fb41d452d9ec200000", "time.Time", "2013-03-21 20:04:00.5 +0000 UTC"},
+		{"c349010000000000000000", "*big.Int", "-18446744073709551617"},
+		{"c48221196ab3", "cbor.DecimalFraction", "{-2 27315}"},
+		{"c5822003", "*big.Rat", "3/2"},
+		{"d8236161", "*regexp.Regexp", "a"},
+		{"fa47c35000", "float32", "100000"},
+		{"1a000f4240", "uint32", "1000000"},
+		{"6161", "string", "a"},
+	}
+	in := []byte{0x80 | byte(len(cases))}
+	for _, c := range cases {
+		b, _ := hex.DecodeString(c.in)
+		in = append(in, b...)
+	}
+	var a []interface{}
+	check(NewDecoder(bytes.NewReader(in)).Decode(&a))
+	expect(len(a), len(cases), t, "TestDecodeHeterogeneousTaggedArray")
+	for i, c := range cases {
+		expect(fmt.Sprintf("%T", a[i]), c.typ, t, "TestDecodeHeterogeneousTaggedArray "+c.in)
+		expect(fmt.Sprint(a[i]), c.value, t, "TestDecodeHeterogeneousTaggedArray "+c.in)
+	}
+}
+
+func TestDecodeIntKeyedMaps(t *testing.T) {
+	// {1: "a", -1: "b", 255: "c"} as used by COSE headers
+	in, _ := hex.DecodeString("a301616120616218ff6163")
+	var a map[int64]string
+	check(NewDecoder(bytes.NewReader(in)).Decode(&a))
+	expect(len(a), 3, t, "TestDecodeIntKeyedMaps")
+	expect(a[1], "a", t, "TestDecodeIntKeyedMaps")
+	expect(a[-1], "b", t, "TestDecodeIntKeyedMaps")
+	expect(a[255], "c", t, "TestDecodeIntKeyedMaps")
+
+	// {1: true, 2: false, 24: true}
+	in, _ = hex.DecodeString("a301f502f41818f5")
+	var b map[uint8]bool
+	check(NewDecoder(bytes.NewReader(in)).Decode(&b))
+	expect(len(b), 3, t, "TestDecodeIntKeyedMaps")
+	expect(b[1] && !b[2] && b[24], true, t, "TestDecodeIntKeyedMaps")
+
+	// keys that don't fit into the key type are errors
+	for _, c := range []string{"a1190100f5", "a120f5"} {
+		in, _ = hex.DecodeString(c)
+		b = nil
+		err := NewDecoder(bytes.NewReader(in)).Decode(&b)
+		expect(err != nil, true, t, "TestDecodeIntKeyedMaps "+c)
+	}
+	in, _ = hex.DecodeString("a1190100f5")
+	err := NewDecoder(bytes.NewReader(in)).Decode(&b)
+	expect(err.Error(), "at byte 1: map key 256 doesn't fit into uint8", t, "TestDecodeIntKeyedMaps")
+}
+
+func TestDecodeStructWithIndefiniteFields(t *testing.T) {
+	// {"A": [_ 1, 2], "B": 3} followed by 4, the indefinite
+	// array must not make the struct map to look for a break
+	buf := []byte{0xa2, 0x61, 0x41, 0x9f, 0x01, 0x02, 0xff, 0x61, 0x42, 0x03, 0x04}
+	d := NewDecoder(bytes.NewReader(buf))
+	var a struct {
+		A []uint8
+		B uint8
+	}
+	check(d.Decode(&a))
+	expect(len(a.A), 2, t, "TestDecodeStructWithIndefiniteFields")
+	expect(a.B, uint8(3), t, "TestDecodeStructWithIndefiniteFields")
+	var n uint8
+	check(d.Decode(&n))
+	expect(n, uint8(4), t, "TestDecodeStructWithIndefiniteFields")
+
+	// a break stop code can't close a definite length map
+	buf = []byte{0xa2, 0x61, 0x41, 0x80, 0xff, 0x61, 0x42, 0x03}
+	err := NewDecoder(bytes.NewReader(buf)).Decode(&a)
+	expect(err.Error(), "at byte 4: map keys must be string, cborNC received", t, "TestDecodeStructWithIndefiniteFields")
+}
+
+func TestDecodeScalarToSlice(t *testing.T) {
+	var out []int
+	err := NewDecoder(bytes.NewReader([]byte{0x05})).Decode(&out)
+	expect(err != nil, true, t, "TestDecodeScalarToSlice without option")
+
+	for _, c := range []struct {
+		in       []byte
+		expected []int
+	}{
+		{[]byte{0x05}, []int{5}},
+		{[]byte{0x83, 0x01, 0x02, 0x03}, []int{1, 2, 3}},
+		{[]byte{0x20}, []int{-1}},
+	} {
+		check(NewDecoder(bytes.NewReader(c.in), WithScalarToSlice()).Decode(&out))
+		expect(fmt.Sprint(out), fmt.Sprint(c.expected), t, "TestDecodeScalarToSlice")
+	}
+
+	// struct fields and strings into slices of strings
+	type Message struct {
+		To []string
+	}
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf).Encode(map[string]string{"To": "ana"}))
+	var m Message
+	check(NewDecoder(buf, WithScalarToSlice()).Decode(&m))
+	expect(fmt.Sprint(m.To), "[ana]", t, "TestDecodeScalarToSlice field")
+
+	// byte strings still decode into byte slices
+	var b []byte
+	check(NewDecoder(bytes.NewReader([]byte{0x42, 0x01, 0x02}), WithScalarToSlice()).Decode(&b))
+	expect(fmt.Sprintf("%x", b), "0102", t, "TestDecodeScalarToSlice bytes")
+
+	// tagged values and maps are not scalars
+	for _, in := range [][]byte{{0xc1, 0x01}, {0xc2, 0x41, 0x05}, {0xa1, 0x01, 0x02}} {
+		err := NewDecoder(bytes.NewReader(in), WithScalarToSlice()).Decode(&out)
+		expect(err != nil, true, t, fmt.Sprintf("TestDecodeScalarToSlice %x", in))
+	}
+	type Tagged struct {
+		N []int
+	}
+	var tagged Tagged
+	err = NewDecoder(bytes.NewReader([]byte{0xa1, 0x61, 0x4e, 0xc1, 0x01}), WithScalarToSlice()).Decode(&tagged)
+	expect(err != nil, true, t, "TestDecodeScalarToSlice tagged field")
+	err = NewDecoder(bytes.NewReader([]byte{0xa1, 0x61, 0x4e, 0xa1, 0x01, 0x02}), WithScalarToSlice()).Decode(&tagged)
+	expect(err != nil, true, t, "TestDecodeScalarToSlice map field")
+}
+
+type Shape interface {
+	Area() uint
+}
+
+type Square struct {
+	Side uint
+}
+
+func (s Square) Area() uint { return s.Side * s.Side }
+
+type Rect struct {
+	Width  uint
+	Height uint
+}
+
+func (r *Rect) Area() uint { return r.Width * r.Height }
+
+func TestDecodeRegisteredTagTypesIntoInterfaceSlice(t *testing.T) {
+	check(RegisterTagType(1000, Square{}))
+	check(RegisterTagType(1001, &Rect{}))
+	defer delete(tagTypesDec, 1000)
+	defer delete(tagTypesDec, 1001)
+	expect(RegisterTagType(1000, Rect{}) != nil, true, t, "TestDecodeRegisteredTagTypesIntoInterfaceSlice")
+
+	buf := []byte{0x82, 0xd9, 0x03, 0xe8, 0xa1, 0x64, 0x53, 0x69, 0x64, 0x65, 0x03, 0xd9, 0x03, 0xe9, 0xa2, 0x65, 0x57, 0x69, 0x64, 0x74, 0x68, 0x02, 0x66, 0x48, 0x65, 0x69, 0x67, 0x68, 0x74, 0x05}
+	r := bytes.NewReader(buf)
+	d := NewDecoder(r)
+	var a []Shape
+	check(d.Decode(&a))
+	expect(len(a), 2, t, "TestDecodeRegisteredTagTypesIntoInterfaceSlice")
+	expect(a[0], Square{3}, t, "TestDecodeRegisteredTagTypesIntoInterfaceSlice")
+	expect(a[0].Area(), uint(9), t, "TestDecodeRegisteredTagTypesIntoInterfaceSlice")
+	expect(*a[1].(*Rect), Rect{2, 5}, t, "TestDecodeRegisteredTagTypesIntoInterfaceSlice")
+	expect(a[1].Area(), uint(10), t, "TestDecodeRegisteredTagTypesIntoInterfaceSlice")
+
+	buf = []byte{0x81, 0xd9, 0x03, 0xe8, 0x03}
+	r = bytes.NewReader(buf)
+	d = NewDecoder(r)
+	var b []Shape
+	expect(d.Decode(&b) != nil, true, t, "TestDecodeRegisteredTagTypesIntoInterfaceSlice")
+}
+
 type MineType struct {
 	Id   int
 	Name string
@@ -1229,6 +2761,19 @@ func BenchmarkDecodeUnsignedIntsArray(b *testing.B) {
 	}
 }
 
+func BenchmarkDecodeUnsignedIntsArrayReuse(b *testing.B) {
+	buf := []byte{0x84, 0x04, 0x09, 0x19, 0x04, 0x00, 0x10}
+	r := bytes.NewReader(buf)
+	d := NewDecoder(r)
+	a := make([]uint, 0, 4)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Reset(buf)
+		d.Decode(&a)
+	}
+}
+
 func BenchmarkDecodeInterface(b *testing.B) {
 	buf := []byte{0x85, 0x04, 0x09, 0x19, 0x04, 0x00, 0x10, 0x83, 0x01, 0x02, 0x67, 0x65, 0x73, 0x70, 0x61, 0xc3, 0xb1, 0x61}
 	r := bytes.NewReader(buf)
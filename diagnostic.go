@@ -0,0 +1,200 @@
+// A Golang RFC7049 implementation
+// Copyright (C) 2015 Oscar Campos
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Diagnose returns the given CBOR data item in the diagnostic notation
+// defined in the section 6 of the RFC7049, e.g. [1, h'0102', {"a": true}].
+// Byte strings are written as h'..', tags as NN(...) and indefinite length
+// items are marked with an underscore like in (_ "strea", "ming")
+func Diagnose(data []byte) (string, error) {
+	p := NewParser(bytes.NewReader(data))
+	tz := newTokenizer(p, defaultMaxDepth)
+	buf := bytes.NewBuffer(nil)
+	t, err := tz.next()
+	if err == nil {
+		err = diagnoseToken(tz, buf, t)
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = NewParseErr("unexpected end of data")
+		}
+		return "", err
+	}
+	p.startItem()
+	if _, err := p.scan1(); err != io.EOF {
+		return "", NewParseErr("trailing data after the first data item")
+	}
+	return buf.String(), nil
+}
+
+// writes the diagnostic notation of the data item started by t into w
+func diagnoseToken(tz *tokenizer, w *bytes.Buffer, t token) error {
+	switch t.Major {
+	case cborUnsignedInt:
+		w.WriteString(strconv.FormatUint(t.Arg, 10))
+	case cborNegativeInt:
+		n := new(big.Int).SetUint64(t.Arg)
+		w.WriteString(n.Add(n, big.NewInt(1)).Neg(n).String())
+	case cborByteString, cborTextString:
+		if !t.IsIndefinite() {
+			w.WriteString(diagnoseString(t.Bytes, t.Major))
+			return nil
+		}
+		w.WriteString("(_ ")
+		err := tz.each(func(n int, chunk token) error {
+			if n > 0 {
+				w.WriteString(", ")
+			}
+			w.WriteString(diagnoseString(chunk.Bytes, t.Major))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		w.WriteString(")")
+	case cborDataArray, cborDataMap:
+		return diagnoseItems(tz, w, t)
+	case cborTag:
+		fmt.Fprintf(w, "%d(", t.Arg)
+		err := tz.each(func(_ int, t token) error {
+			return diagnoseToken(tz, w, t)
+		})
+		if err != nil {
+			return err
+		}
+		w.WriteString(")")
+	case cborNC:
+		diagnoseSimple(w, t)
+	}
+	return nil
+}
+
+// writes every element of an array or every key and
+// value of a map, definite length or not
+func diagnoseItems(tz *tokenizer, w *bytes.Buffer, t token) error {
+	open, end, size := "[", "]", 1
+	if t.Major == cborDataMap {
+		open, end, size = "{", "}", 2
+	}
+	w.WriteString(open)
+	if t.IsIndefinite() {
+		w.WriteString("_ ")
+	}
+	err := tz.each(func(n int, t token) error {
+		diagnoseSeparator(w, n%size, n > 0)
+		return diagnoseToken(tz, w, t)
+	})
+	if err != nil {
+		return err
+	}
+	w.WriteString(end)
+	return nil
+}
+
+// writes the separator that goes before the n-th element of a
+// container of the given size, map values are preceded by a colon
+func diagnoseSeparator(w *bytes.Buffer, pos int, notFirst bool) {
+	switch {
+	case pos == 1:
+		w.WriteString(": ")
+	case notFirst:
+		w.WriteString(", ")
+	}
+}
+
+// writes simple values and floats, floats are written with as
+// many digits as needed to represent its value exactly
+func diagnoseSimple(w *bytes.Buffer, t token) {
+	switch t.Info {
+	case cborFalse:
+		w.WriteString("false")
+	case cborTrue:
+		w.WriteString("true")
+	case cborNil:
+		w.WriteString("null")
+	case cborUndef:
+		w.WriteString("undefined")
+	case absoluteFloat16 & 0x1f, absoluteFloat32 & 0x1f, absoluteFloat64 & 0x1f:
+		f, _ := t.Float()
+		w.WriteString(diagnoseFloat(f))
+	default:
+		fmt.Fprintf(w, "simple(%d)", t.Arg)
+	}
+}
+
+// returns the diagnostic notation of a float, always with a decimal
+// point so they can't be confused with integers, e.g. 1.0 or 1.0e+300
+func diagnoseFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	var s string
+	if abs := math.Abs(f); abs == 0 || abs >= 1e-7 && abs < 1e21 {
+		s = strconv.FormatFloat(f, 'f', -1, 64)
+	} else {
+		s = strconv.FormatFloat(f, 'e', -1, 64)
+	}
+	mantissa, exp := s, ""
+	if i := strings.IndexByte(s, 'e'); i >= 0 {
+		mantissa, exp = s[:i], s[i:]
+	}
+	if !strings.Contains(mantissa, ".") {
+		mantissa += ".0"
+	}
+	return mantissa + exp
+}
+
+// returns the diagnostic notation of a byte string (h'..') or a
+// text string (JSON like, escaping any non ASCII character)
+func diagnoseString(data []byte, major Major) string {
+	if major == cborByteString {
+		return fmt.Sprintf("h'%x'", data)
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range string(data) {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r > 0x7e && r <= 0xffff:
+			fmt.Fprintf(&b, "\\u%04x", r)
+		case r > 0xffff:
+			r1, r2 := utf16.EncodeRune(r)
+			fmt.Fprintf(&b, "\\u%04x\\u%04x", r1, r2)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,142 @@
+// A Golang RFC7049 implementation
+// Copyright (C) 2015 Oscar Campos
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbor
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// examples from the RFC7049 Appendix A
+var rfc7049Diagnostics = []struct{ in, out string }{
+	{"00", "0"},
+	{"01", "1"},
+	{"0a", "10"},
+	{"17", "23"},
+	{"1818", "24"},
+	{"1819", "25"},
+	{"1864", "100"},
+	{"1903e8", "1000"},
+	{"1a000f4240", "1000000"},
+	{"1b000000e8d4a51000", "1000000000000"},
+	{"1bffffffffffffffff", "18446744073709551615"},
+	{"c249010000000000000000", "2(h'010000000000000000')"},
+	{"3bffffffffffffffff", "-18446744073709551616"},
+	{"c349010000000000000000", "3(h'010000000000000000')"},
+	{"20", "-1"},
+	{"29", "-10"},
+	{"3863", "-100"},
+	{"3903e7", "-1000"},
+	{"f90000", "0.0"},
+	{"f98000", "-0.0"},
+	{"f93c00", "1.0"},
+	{"fb3ff199999999999a", "1.1"},
+	{"f93e00", "1.5"},
+	{"f97bff", "65504.0"},
+	{"fa47c35000", "100000.0"},
+	{"fa7f7fffff", "3.4028234663852886e+38"},
+	{"fb7e37e43c8800759c", "1.0e+300"},
+	{"f90001", "5.960464477539063e-08"},
+	{"f90400", "0.00006103515625"},
+	{"f9c400", "-4.0"},
+	{"fbc010666666666666", "-4.1"},
+	{"f97c00", "Infinity"},
+	{"f97e00", "NaN"},
+	{"f9fc00", "-Infinity"},
+	{"fa7f800000", "Infinity"},
+	{"fa7fc00000", "NaN"},
+	{"faff800000", "-Infinity"},
+	{"fb7ff0000000000000", "Infinity"},
+	{"fb7ff8000000000000", "NaN"},
+	{"fbfff0000000000000", "-Infinity"},
+	{"f4", "false"},
+	{"f5", "true"},
+	{"f6", "null"},
+	{"f7", "undefined"},
+	{"f0", "simple(16)"},
+	{"f818", "simple(24)"},
+	{"f8ff", "simple(255)"},
+	{"c074323031332d30332d32315432303a30343a30305a", `0("2013-03-21T20:04:00Z")`},
+	{"c11a514b67b0", "1(1363896240)"},
+	{"c1fb41d452d9ec200000", "1(1363896240.5)"},
+	{"d74401020304", "23(h'01020304')"},
+	{"d818456449455446", "24(h'6449455446')"},
+	{"d82076687474703a2f2f7777772e6578616d706c652e636f6d", `32("http://www.example.com")`},
+	{"40", "h''"},
+	{"4401020304", "h'01020304'"},
+	{"60", `""`},
+	{"6161", `"a"`},
+	{"6449455446", `"IETF"`},
+	{"62225c", `"\"\\"`},
+	{"62c3bc", `"\u00fc"`},
+	{"63e6b0b4", `"\u6c34"`},
+	{"64f0908591", `"\ud800\udd51"`},
+	{"80", "[]"},
+	{"83010203", "[1, 2, 3]"},
+	{"8301820203820405", "[1, [2, 3], [4, 5]]"},
+	{"98190102030405060708090a0b0c0d0e0f101112131415161718181819",
+		"[1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25]"},
+	{"a0", "{}"},
+	{"a201020304", "{1: 2, 3: 4}"},
+	{"a26161016162820203", `{"a": 1, "b": [2, 3]}`},
+	{"826161a161626163", `["a", {"b": "c"}]`},
+	{"a56161614161626142616361436164614461656145",
+		`{"a": "A", "b": "B", "c": "C", "d": "D", "e": "E"}`},
+	{"5f42010243030405ff", "(_ h'0102', h'030405')"},
+	{"7f657374726561646d696e67ff", `(_ "strea", "ming")`},
+	{"9fff", "[_ ]"},
+	{"9f018202039f0405ffff", "[_ 1, [2, 3], [_ 4, 5]]"},
+	{"9f01820203820405ff", "[_ 1, [2, 3], [4, 5]]"},
+	{"83018202039f0405ff", "[1, [2, 3], [_ 4, 5]]"},
+	{"83019f0203ff820405", "[1, [_ 2, 3], [4, 5]]"},
+	{"9f0102030405060708090a0b0c0d0e0f101112131415161718181819ff",
+		"[_ 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25]"},
+	{"bf61610161629f0203ffff", `{_ "a": 1, "b": [_ 2, 3]}`},
+	{"826161bf61626163ff", `["a", {_ "b": "c"}]`},
+	{"bf6346756ef563416d7421ff", `{_ "Fun": true, "Amt": -2}`},
+}
+
+func TestDiagnose(t *testing.T) {
+	for _, c := range rfc7049Diagnostics {
+		in, _ := hex.DecodeString(c.in)
+		out, err := Diagnose(in)
+		check(err)
+		expect(out, c.out, t, "TestDiagnose "+c.in)
+	}
+}
+
+func TestDiagnoseErrors(t *testing.T) {
+	cases := []string{
+		"",           // no data at all
+		"0102",       // trailing data
+		"ff",         // break outside indefinite item
+		"8201",       // truncated array
+		"bf6161ff",   // break before the map value
+		"5f6161ff",   // text chunk in a byte string
+		"7a00000010", // truncated string
+
+		"5b7fffffffffffffff",     // string longer than the data
+		"5bffffffffffffffff",     // string longer than an int
+		"5f5bffffffffffffffff",   // chunk longer than an int
+		"bb8000000000000000",     // map with more pairs than an uint64
+		"9b7fffffffffffffff0102", // array longer than the data
+	}
+	for _, c := range cases {
+		in, _ := hex.DecodeString(c)
+		_, err := Diagnose(in)
+		expect(err != nil, true, t, "TestDiagnoseErrors "+c)
+	}
+}
@@ -17,26 +17,131 @@ package cbor
 
 import (
 	"bytes"
+	"encoding"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"math/big"
+	"net"
+	"net/netip"
 	"reflect"
+	"sort"
+	"strconv"
 	"time"
 	"unicode"
+	"unicode/utf8"
 	"unsafe"
 )
 
 // Type of function that handler encoding of extensions
-type handleEncFn handleDecFn
+type handleEncFn func(*Encoder, reflect.Value) error
+
+// additional types map defined by the user
+type extensionEncMap map[uintptr]handleEncFn
+
+// global encode extensions register
+var extensionsEnc extensionEncMap = make(extensionEncMap)
+
+// Registers a new extension in the encode extensions register
+func (e extensionEncMap) register(t reflect.Type, fn handleEncFn) error {
+	tid := reflect.ValueOf(t).Pointer()
+	if _, ok := e[tid]; ok {
+		return fmt.Errorf("%s type is already registered\n", t)
+	}
+	e[tid] = fn
+	return nil
+}
+
+// Look for a function registered to handle the encode of a given type
+func (e extensionEncMap) lookup(t reflect.Type) (handleEncFn, error) {
+	fn, ok := e[reflect.ValueOf(t).Pointer()]
+	if !ok {
+		return nil, fmt.Errorf(
+			"%s not matched as registered encode extension handler", t)
+	}
+	return fn, nil
+}
+
+// Registers a new function to handle encode of extensions, the function
+// is used to encode any value of the given type instead of the default
+// encoding for its kind and it can write any number of items calling
+// Encode (e.g. a Tag wrapping its contents)
+func RegisterEncodeExtensionFn(t reflect.Type, fn func(*Encoder, reflect.Value) error) error {
+	return extensionsEnc.register(t, fn)
+}
+
+// Registers a new function to handle encode of tag extensions, values
+// of the given type are written as the content of a tag with the given
+// number, the encoder writes the tag header before calling fn. It is
+// the encode counterpart of RegisterTagExtensionFn
+func RegisterTagEncodeFn(t reflect.Type, tagNumber uint64, fn func(*Encoder, reflect.Value) error) error {
+	return extensionsEnc.register(t, func(enc *Encoder, rv reflect.Value) error {
+		if _, err := enc.composer.composeUint(tagNumber, cborTag); err != nil {
+			return err
+		}
+		return fn(enc, rv)
+	})
+}
+
+// Lookup for a registered function that handles the given type encode
+func LookupEncodeExtensionFn(t reflect.Type) (handleEncFn, error) {
+	return extensionsEnc.lookup(t)
+}
 
 // An Encoder writes and encode CBOR objects to an output stream
 type Encoder struct {
 	composer  *Composer
 	canonical bool
 	strict    bool
+	threshold int  // slices longer than this are encoded as indefinite arrays
+	boolsInt  bool // booleans are encoded as 0 and 1 integers
+	errChains bool // errors are encoded as arrays of its chain messages
+	jsonTags  bool // use json tags for fields without cbor tags
+	sets      bool // map[K]struct{} sets are encoded as arrays of its keys
+	streams   bool // structs are encoded as indefinite length maps
+	setsTag   bool // sets arrays are tagged as sets (258)
+
+	timeFormat TimeFormat
+
+	selfDescribe     bool // prefix the first encoded item with the self-describe tag
+	selfDescribeEach bool // prefix every encoded item with the self-describe tag
+	described        bool // the self-describe tag has been already written
+	nested           int  // depth of the streams or extensions being written
+
+	wrap    bool   // wrap every top level item in the wrapTag tag
+	wrapTag uint64 // the tag that wraps top level items
+
+	encoding map[encodeRef]struct{} // references being encoded, used to detect cycles
 }
 
+// identifies a pointer, map or slice while it is being encoded,
+// slices sharing the same backing array differ on its length and
+// values at the same address (a struct and its first field) on its type
+type encodeRef struct {
+	ptr uintptr
+	len int
+	typ reflect.Type
+}
+
+// TimeFormat defines how time.Time values are encoded
+type TimeFormat int
+
+const (
+	// TimeEpoch encodes times as epoch-based datetimes (tag 1) using
+	// integer seconds or a float if the time has fractional seconds
+	TimeEpoch TimeFormat = iota
+	// TimeEpochInt encodes times as epoch-based datetimes (tag 1)
+	// using always integer seconds, fractional seconds are dropped
+	TimeEpochInt
+	// TimeEpochFloat encodes times as epoch-based datetimes
+	// (tag 1) using always float seconds
+	TimeEpochFloat
+	// TimeRFC3339 encodes times as standard datetime strings (tag 0)
+	TimeRFC3339
+)
+
 // NewEncoder returns a new encoder that write to w
 func NewEncoder(w io.Writer, options ...func(*Encoder)) *Encoder {
 	e := &Encoder{composer: &Composer{w: w}, strict: false}
@@ -48,6 +153,140 @@ func NewEncoder(w io.Writer, options ...func(*Encoder)) *Encoder {
 	return e
 }
 
+// Reset makes the encoder to write into w discarding its transient
+// state, options are kept so a single encoder can be reused
+func (enc *Encoder) Reset(w io.Writer) {
+	*enc.composer = Composer{w: w}
+	enc.described = false
+	enc.nested = 0
+	enc.encoding = nil
+}
+
+// WithCanonical makes the encoder to follow the section 3.9 Canonical
+// CBOR rules of the RFC7049, map keys and struct fields are sorted by
+// its encoded bytes (shorter first), floats are written using the
+// shortest precision that keeps its value and all the arrays have
+// definite length (any array stream threshold is ignored). Note that
+// the RFC 8949 core deterministic encoding sorts map keys by its plain
+// bytewise order instead, so both orders differ for keys of different
+// lengths
+func WithCanonical() func(*Encoder) {
+	return func(enc *Encoder) {
+		enc.canonical = true
+	}
+}
+
+// BoolsAsInt makes the encoder to write booleans as the 0 and 1
+// unsigned integers for peers that don't understand CBOR booleans
+func BoolsAsInt() func(*Encoder) {
+	return func(enc *Encoder) {
+		enc.boolsInt = true
+	}
+}
+
+// ErrorChains makes the encoder to write errors as an array with the
+// message of the error and the messages of every error it wraps, as
+// returned by errors.Unwrap, from the outermost to the innermost
+func ErrorChains() func(*Encoder) {
+	return func(enc *Encoder) {
+		enc.errChains = true
+	}
+}
+
+// EncodeJSONTagFallback makes the encoder to use the json tag of the
+// struct fields that don't have a cbor tag, it is the counterpart of
+// the WithJSONTagFallback decoder option
+//
+// Empty fields with the omitempty option are omitted whether the option
+// comes from a json tag or a cbor one, cbor tags honor it even when the
+// encoder is not configured with this option
+func EncodeJSONTagFallback() func(*Encoder) {
+	return func(enc *Encoder) {
+		enc.jsonTags = true
+	}
+}
+
+// SetsAsArrays makes the encoder to write map[K]struct{} values as
+// arrays of its keys instead of maps of empty maps, tagged as a set
+// (258) when tagged is true. Decoders accept both forms for sets
+func SetsAsArrays(tagged bool) func(*Encoder) {
+	return func(enc *Encoder) {
+		enc.sets = true
+		enc.setsTag = tagged
+	}
+}
+
+// StructsAsStreams makes the encoder to write structs as indefinite
+// length maps, the fields are written as they are encoded instead of
+// being buffered to count them first at the cost of a break byte.
+// Canonical encoding and structs with ordered fields are not streamed
+func StructsAsStreams() func(*Encoder) {
+	return func(enc *Encoder) {
+		enc.streams = true
+	}
+}
+
+// WithSelfDescribe makes the encoder to write the self-describe
+// tag (55799) once, before the first item it encodes
+func WithSelfDescribe() func(*Encoder) {
+	return func(enc *Encoder) {
+		enc.selfDescribe = true
+	}
+}
+
+// SelfDescribe prefixes the output with the self-describe tag magic
+// number 0xd9d9f7 used to identify CBOR data, it is the same option
+// as WithSelfDescribe()
+func SelfDescribe(enc *Encoder) {
+	enc.selfDescribe = true
+}
+
+// WithSelfDescribeEachItem makes the encoder to write the self-describe
+// tag (55799) before every item it encodes so each item of a sequence
+// is self-describing on its own
+func WithSelfDescribeEachItem() func(*Encoder) {
+	return func(enc *Encoder) {
+		enc.selfDescribeEach = true
+	}
+}
+
+// WrapInTag makes the encoder to write the given tag before every top
+// level item it encodes (after the self-describe tag if any) so the
+// whole document is wrapped in it, use the WithTagUnwrap decoder
+// option to decode it back into types that don't expect the tag
+func WrapInTag(tag uint64) func(*Encoder) {
+	return func(enc *Encoder) {
+		enc.wrap = true
+		enc.wrapTag = tag
+	}
+}
+
+// TextDateTime makes the encoder to write time values as standard
+// datetime strings (tag 0) preserving their offset, it is a shortcut
+// for WithTimeFormat(TimeRFC3339). Decoders move the decoded times to
+// their location (UTC unless WithLocation is used) so the offset is
+// only kept in the encoded string
+func TextDateTime(enc *Encoder) {
+	enc.timeFormat = TimeRFC3339
+}
+
+// WithTimeFormat sets the format used to encode time.Time
+// values, the default format is TimeEpoch
+func WithTimeFormat(f TimeFormat) func(*Encoder) {
+	return func(enc *Encoder) {
+		enc.timeFormat = f
+	}
+}
+
+// WithArrayStreamThreshold makes the encoder to write slices
+// longer than n elements as indefinite-length arrays while
+// shorter ones are still written with definite length
+func WithArrayStreamThreshold(n int) func(*Encoder) {
+	return func(enc *Encoder) {
+		enc.threshold = n
+	}
+}
+
 // Check if the pointer passed to Encode
 // is nil and then call enc.encodeNil()
 func (enc *Encoder) isValidPointer(t unsafe.Pointer) bool {
@@ -67,6 +306,10 @@ func (enc *Encoder) Encode(v interface{}) (err error) {
 		}
 	}()
 
+	if err := enc.encodeLeadingTags(); err != nil {
+		return err
+	}
+
 	// fast path encoding for simple values
 	switch t := v.(type) {
 	case nil:
@@ -95,6 +338,8 @@ func (enc *Encoder) Encode(v interface{}) (err error) {
 		enc.encodeInt(int64(t))
 	case float16:
 		enc.encodeFloat16(t)
+	case Simple:
+		enc.encodeSimple(t)
 	case float32:
 		enc.encodeFloat32(t)
 	case float64:
@@ -109,6 +354,26 @@ func (enc *Encoder) Encode(v interface{}) (err error) {
 		enc.encodeEpochDateTime(t)
 	case big.Rat:
 		enc.encodeBigFloat(t)
+	case DecimalFraction:
+		enc.encodeDecimalFraction(t)
+	case BigFloat:
+		enc.encodeExactBigFloat(t)
+	case RawMessage:
+		enc.encodeRawMessage(t)
+	case Value:
+		enc.encodeGeneric(t)
+	case Tag:
+		enc.encodeTag(t)
+	case Base64URLBytes:
+		enc.encodeExpectedBase(cborBase64Url, t)
+	case Base64Bytes:
+		enc.encodeExpectedBase(cborBase64, t)
+	case Base16Bytes:
+		enc.encodeExpectedBase(cborBase16, t)
+	case netip.Addr:
+		enc.encodeAddr(t)
+	case netip.Prefix:
+		enc.encodePrefix(t)
 	case []uint8:
 		enc.encodeByteString(t)
 	case string:
@@ -185,6 +450,18 @@ func (enc *Encoder) Encode(v interface{}) (err error) {
 		if enc.isValidPointer(unsafe.Pointer(t)) {
 			enc.encodeBigFloat(*t)
 		}
+	case *Value:
+		if enc.isValidPointer(unsafe.Pointer(t)) {
+			enc.encodeGeneric(*t)
+		}
+	case *DecimalFraction:
+		if enc.isValidPointer(unsafe.Pointer(t)) {
+			enc.encodeDecimalFraction(*t)
+		}
+	case *BigFloat:
+		if enc.isValidPointer(unsafe.Pointer(t)) {
+			enc.encodeExactBigFloat(*t)
+		}
 	case *[]uint8:
 		if enc.isValidPointer(unsafe.Pointer(t)) {
 			enc.encodeByteString(*t)
@@ -193,31 +470,183 @@ func (enc *Encoder) Encode(v interface{}) (err error) {
 		if enc.isValidPointer(unsafe.Pointer(t)) {
 			enc.encodeTextString(*t)
 		}
+	case net.IP:
+		enc.encodeIP(t)
+	case *net.IP:
+		if enc.isValidPointer(unsafe.Pointer(t)) {
+			enc.encodeIP(*t)
+		}
+	case *netip.Addr:
+		if enc.isValidPointer(unsafe.Pointer(t)) {
+			enc.encodeAddr(*t)
+		}
+	case *netip.Prefix:
+		if enc.isValidPointer(unsafe.Pointer(t)) {
+			enc.encodePrefix(*t)
+		}
+	case encoding.TextMarshaler:
+		// registered extensions take precedence over MarshalText
+		if _, e := LookupEncodeExtensionFn(reflect.TypeOf(t)); e == nil {
+			return enc.encode(reflect.ValueOf(t))
+		}
+		if rv := reflect.ValueOf(t); rv.Kind() == reflect.Ptr && rv.IsNil() {
+			enc.encodeNil()
+		} else {
+			enc.encodeTextMarshaler(t)
+		}
 	case reflect.Value:
-		enc.encode(t, v)
+		return enc.encode(t)
 	default:
-		enc.encode(reflect.ValueOf(v), v)
+		return enc.encode(reflect.ValueOf(v))
+	}
+
+	return nil
+}
+
+// EncodeArrayStream writes an indefinite-length array, the elements are
+// written by the given function calling Encode as many times as needed
+// and then the array is closed with the break stop code
+func (enc *Encoder) EncodeArrayStream(fn func(*Encoder) error) error {
+	return enc.encodeStream(cborDataArray, fn)
+}
+
+// EncodeMapStream writes an indefinite-length map, the given function
+// must call Encode for every key followed by its value and then the
+// map is closed with the break stop code
+func (enc *Encoder) EncodeMapStream(fn func(*Encoder) error) error {
+	return enc.encodeStream(cborDataMap, fn)
+}
+
+// EncodeSeq writes an indefinite-length array which elements are pulled
+// from next until it returns false, so lazily produced sequences can be
+// encoded without building a slice first
+func (enc *Encoder) EncodeSeq(next func() (interface{}, bool)) error {
+	return enc.encodeStream(cborDataArray, func(enc *Encoder) error {
+		for v, ok := next(); ok; v, ok = next() {
+			if err := enc.Encode(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// EncodeByteString writes b as a byte string (major 2)
+// whatever the type its contents come from
+func (enc *Encoder) EncodeByteString(b []byte) error {
+	if err := enc.encodeLeadingTags(); err != nil {
+		return err
 	}
+	return enc.composer.composeBytes(b)
+}
+
+// EncodeTextString writes s as a text string (major 3), in strict
+// mode it fails if s is not valid UTF-8 as text strings must be
+func (enc *Encoder) EncodeTextString(s string) error {
+	if enc.strict && !utf8.ValidString(s) {
+		return NewStrictModeError("text string is not valid UTF-8")
+	}
+	if err := enc.encodeLeadingTags(); err != nil {
+		return err
+	}
+	return enc.composer.composeString(s)
+}
 
+// EncodeFramed encodes v into a frame prefixed by its length as a four
+// bytes big-endian unsigned integer, the counterpart of DecodeFramed
+func (enc *Encoder) EncodeFramed(v interface{}) error {
+	buf := bytes.NewBuffer(nil)
+	frame := *enc
+	frame.composer = NewComposer(buf)
+	err := frame.Encode(v)
+	enc.described = frame.described
+	if err != nil {
+		return err
+	}
+	if uint64(buf.Len()) > math.MaxUint32 {
+		return fmt.Errorf("can't encode a frame of %d bytes", buf.Len())
+	}
+	prefix := make([]byte, 4)
+	binary.BigEndian.PutUint32(prefix, uint32(buf.Len()))
+	if _, err := enc.composer.write(prefix); err != nil {
+		return err
+	}
+	_, err = enc.composer.write(buf.Bytes())
+	return err
+}
+
+// writes an indefinite-length item of the given major
+func (enc *Encoder) encodeStream(major Major, fn func(*Encoder) error) error {
+	if enc.canonical {
+		return NewCanonicalModeError("indefinite-length items are not allowed")
+	}
+	if err := enc.encodeLeadingTags(); err != nil {
+		return err
+	}
+	if err := enc.composer.composeInformation(major, cborIndefinite); err != nil {
+		return err
+	}
+	enc.nested++
+	err := fn(enc)
+	enc.nested--
+	if err != nil {
+		return err
+	}
+	return enc.composer.composeBreak()
+}
+
+// writes the self-describe tag and the wrapping tag
+// before a top level item if they are configured
+func (enc *Encoder) encodeLeadingTags() error {
+	if enc.nested > 0 {
+		return nil
+	}
+	if enc.selfDescribeEach || enc.selfDescribe && !enc.described {
+		enc.described = true
+		if _, err := enc.composer.composeUint(cborSelfDescribe, cborTag); err != nil {
+			return err
+		}
+	}
+	if enc.wrap {
+		_, err := enc.composer.composeUint(enc.wrapTag, cborTag)
+		return err
+	}
 	return nil
 }
 
 // encode is being used when the type of the supplier of the encode
 // operation is a slice, a map an interface or any other custom type
-func (enc *Encoder) encode(rv reflect.Value, vs ...interface{}) (err error) {
+func (enc *Encoder) encode(rv reflect.Value) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			err = errors.New(fmt.Sprint(r))
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = errors.New(fmt.Sprint(r))
+			}
 		}
 	}()
 
-	// If rv is a pointer, get the value it's references
-	for rv.Kind() == reflect.Ptr {
+	if enc.errChains && rv.IsValid() && rv.CanInterface() {
+		nilPtr := rv.Kind() == reflect.Ptr && rv.IsNil()
+		if e, ok := rv.Interface().(error); ok && !nilPtr {
+			enc.encodeErrorChain(e)
+			return
+		}
+	}
+
+	// If rv is a pointer or an interface, get the value it's references
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
 		// Lets encode nil values if present
 		if rv.IsNil() {
 			enc.encodeNil()
 			return
 		}
+		if rv.Kind() == reflect.Ptr {
+			ref := encodeRef{ptr: rv.Pointer(), typ: rv.Type()}
+			enc.enterReference(ref, rv.Type())
+			defer enc.leaveReference(ref)
+		}
 		rv = rv.Elem()
 	}
 	if !rv.IsValid() {
@@ -225,52 +654,105 @@ func (enc *Encoder) encode(rv reflect.Value, vs ...interface{}) (err error) {
 		enc.encodeNil()
 		return
 	}
-	var v interface{} = rv.Interface()
-	if len(vs) > 0 {
-		v = vs[0]
+	if kind := rv.Kind(); (kind == reflect.Map || kind == reflect.Slice) && rv.Len() > 0 {
+		ref := encodeRef{ptr: rv.Pointer(), len: rv.Len(), typ: rv.Type()}
+		enc.enterReference(ref, rv.Type())
+		defer enc.leaveReference(ref)
+	}
+
+	// types that are encoded as semantic tags
+	switch t := rv.Interface().(type) {
+	case big.Int:
+		if t.Sign() < 0 {
+			enc.encodeBigInt(t)
+		} else {
+			enc.encodeBigUint(t)
+		}
+		return
+	case time.Time:
+		enc.encodeEpochDateTime(t)
+		return
+	case big.Rat:
+		enc.encodeBigFloat(t)
+		return
+	case Simple:
+		enc.encodeSimple(t)
+		return
+	case DecimalFraction:
+		enc.encodeDecimalFraction(t)
+		return
+	case BigFloat:
+		enc.encodeExactBigFloat(t)
+		return
+	case RawMessage:
+		enc.encodeRawMessage(t)
+		return
+	case Value:
+		enc.encodeGeneric(t)
+		return
+	case Tag:
+		enc.encodeTag(t)
+		return
+	case Base64URLBytes:
+		enc.encodeExpectedBase(cborBase64Url, t)
+		return
+	case Base64Bytes:
+		enc.encodeExpectedBase(cborBase64, t)
+		return
+	case Base16Bytes:
+		enc.encodeExpectedBase(cborBase16, t)
+		return
+	case netip.Addr:
+		enc.encodeAddr(t)
+		return
+	case net.IP:
+		enc.encodeIP(t)
+		return
+	case netip.Prefix:
+		enc.encodePrefix(t)
+		return
+	}
+	if fn, e := LookupEncodeExtensionFn(rv.Type()); e == nil {
+		enc.nested++
+		defer func() { enc.nested-- }()
+		return fn(enc, rv)
+	}
+	if m, ok := textMarshalerOf(rv); ok {
+		enc.encodeTextMarshaler(m)
+		return
 	}
 
 	switch rv.Type().Kind() {
 	case reflect.Bool:
-		err = enc.composer.composeBoolean(v.(bool))
-	case reflect.Uint8:
-		_, err = enc.composer.composeUint(uint64(v.(uint8)))
-	case reflect.Uint16:
-		_, err = enc.composer.composeUint(uint64(v.(uint16)))
-	case reflect.Uint32:
-		_, err = enc.composer.composeUint(uint64(v.(uint32)))
-	case reflect.Uint64:
-		_, err = enc.composer.composeUint(v.(uint64))
-	case reflect.Uint:
-		_, err = enc.composer.composeUint(uint64(v.(uint)))
-	case reflect.Int8:
-		_, err = enc.composer.composeInt(int64(v.(int8)))
-	case reflect.Int16:
-		_, err = enc.composer.composeInt(int64(v.(int16)))
-	case reflect.Int32:
-		_, err = enc.composer.composeInt(int64(v.(int32)))
-	case reflect.Int64:
-		_, err = enc.composer.composeInt(v.(int64))
-	case reflect.Int:
-		_, err = enc.composer.composeInt(int64(v.(int)))
+		enc.encodeBool(rv.Bool())
+	// named types (like time.Duration) are encoded as their kind
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
+		_, err = enc.composer.composeUint(rv.Uint())
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
+		_, err = enc.composer.composeInt(rv.Int())
 	case reflect.Float32:
-		err = enc.composer.composeFloat32(v.(float32))
+		enc.encodeFloat32(float32(rv.Float()))
 	case reflect.Float64:
-		err = enc.composer.composeFloat64(v.(float64))
+		enc.encodeFloat64(rv.Float())
 	case reflect.String:
-		enc.encodeTextString(v.(string))
+		enc.encodeTextString(rv.String())
 	case reflect.Invalid:
 		err = enc.composer.composeNil()
 	case reflect.Slice, reflect.Array:
 		enc.encodeSlice(rv)
 	case reflect.Map:
+		if enc.sets && isSetType(rv.Type()) {
+			enc.encodeSet(rv)
+			break
+		}
 		enc.encodeMap(rv)
 	case reflect.Struct:
+		// canonical and ordered fields need to be buffered to be sorted
+		if enc.streams && !enc.canonical && !hasOrderedFields(rv.Type()) {
+			enc.encodeStructStream(rv)
+			break
+		}
 		enc.encodeStruct(rv)
-		// case reflect.Interface:
-		// 	err = enc.encodeInterface()
-		// default:
-		// 	err = enc.lookupExtension(rv)
 	}
 
 	return err
@@ -285,6 +767,14 @@ func (enc *Encoder) encodeNil() {
 
 // Encode a boolean value
 func (enc *Encoder) encodeBool(v bool) {
+	if enc.boolsInt {
+		var n uint64
+		if v {
+			n = 1
+		}
+		enc.encodeUint(n)
+		return
+	}
 	if err := enc.composer.composeBoolean(v); err != nil {
 		panic(err)
 	}
@@ -304,8 +794,174 @@ func (enc *Encoder) encodeUint(v uint64) {
 	}
 }
 
+// Encode a simple value
+func (enc *Encoder) encodeSimple(v Simple) {
+	if err := enc.composer.composeSimple(v); err != nil {
+		panic(err)
+	}
+}
+
+// Encode the messages of an error chain as an array of text strings
+func (enc *Encoder) encodeErrorChain(err error) {
+	var messages []string
+	for ; err != nil; err = errors.Unwrap(err) {
+		messages = append(messages, err.Error())
+	}
+	enc.encodeSlice(reflect.ValueOf(messages))
+}
+
+// Encode the text returned by MarshalText as a text string
+func (enc *Encoder) encodeTextMarshaler(m encoding.TextMarshaler) {
+	text, err := m.MarshalText()
+	if err != nil {
+		panic(err)
+	}
+	enc.encodeTextString(string(text))
+}
+
+// returns rv (or its address) as an encoding.TextMarshaler if possible
+func textMarshalerOf(rv reflect.Value) (encoding.TextMarshaler, bool) {
+	if m, ok := rv.Interface().(encoding.TextMarshaler); ok {
+		return m, true
+	}
+	if rv.CanAddr() {
+		m, ok := rv.Addr().Interface().(encoding.TextMarshaler)
+		return m, ok
+	}
+	return nil, false
+}
+
+// Encode a tag number followed by its content
+func (enc *Encoder) encodeTag(t Tag) {
+	if _, err := enc.composer.composeUint(t.Number, cborTag); err != nil {
+		panic(err)
+	}
+	if err := enc.encode(reflect.ValueOf(t.Content)); err != nil {
+		panic(err)
+	}
+}
+
+// Write an already encoded data item as is, nil ones are encoded as null
+func (enc *Encoder) encodeRawMessage(raw RawMessage) {
+	if raw == nil {
+		enc.encodeNil()
+		return
+	}
+	if _, err := enc.composer.write(raw); err != nil {
+		panic(err)
+	}
+}
+
+// Encode a byte string tagged with the expected base encoding
+// for it when it is converted to JSON, nil is encoded as null
+func (enc *Encoder) encodeExpectedBase(tag uint64, b []byte) {
+	if b == nil {
+		enc.encodeNil()
+		return
+	}
+	if _, err := enc.composer.composeUint(tag, cborTag); err != nil {
+		panic(err)
+	}
+	if err := enc.composer.composeBytes(b, cborByteString); err != nil {
+		panic(err)
+	}
+}
+
+// Encode a net.IP as a byte string of 4 or 16 bytes
+// tagged as an IPv4 (52) or IPv6 (54) address
+func (enc *Encoder) encodeIP(ip net.IP) {
+	if ip == nil {
+		enc.encodeNil()
+		return
+	}
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	if len(ip) != net.IPv6len && len(ip) != net.IPv4len {
+		panic(fmt.Errorf("can't encode an IP address of %d bytes", len(ip)))
+	}
+	enc.encodeIPBytes(ip)
+}
+
+// Encode an IP address as a tagged byte string of 4 or 16 bytes, the
+// zero address is encoded as null, addresses with a zone (for example
+// fe80::1%eth0) are encoded as text strings using their MarshalText
+// form instead of the RFC 9164 interface format so the zone is kept,
+// they are decoded back into a netip.Addr through UnmarshalText
+func (enc *Encoder) encodeAddr(a netip.Addr) {
+	switch {
+	case !a.IsValid():
+		enc.encodeNil()
+	case a.Zone() != "":
+		enc.encodeTextString(a.String())
+	default:
+		enc.encodeIPBytes(a.AsSlice())
+	}
+}
+
+// Encode an IP prefix as an array of its length in bits followed by its
+// address without trailing zero bytes, tagged as an IPv4 or IPv6 prefix,
+// the zero (invalid) prefix is encoded as null
+func (enc *Encoder) encodePrefix(p netip.Prefix) {
+	if !p.IsValid() {
+		enc.encodeNil()
+		return
+	}
+	b := p.Masked().Addr().AsSlice()
+	tag := uint64(cborIPv6)
+	if len(b) == net.IPv4len {
+		tag = cborIPv4
+	}
+	for len(b) > 0 && b[len(b)-1] == 0 {
+		b = b[:len(b)-1]
+	}
+	if _, err := enc.composer.composeUint(tag, cborTag); err != nil {
+		panic(err)
+	}
+	if _, err := enc.composer.composeUint(2, cborDataArray); err != nil {
+		panic(err)
+	}
+	enc.encodeUint(uint64(p.Bits()))
+	enc.encodeByteString(b)
+}
+
+// Encode the bytes of an IP address tagged as IPv4 or IPv6
+func (enc *Encoder) encodeIPBytes(b []byte) {
+	tag := uint64(cborIPv6)
+	if len(b) == net.IPv4len {
+		tag = cborIPv4
+	}
+	if _, err := enc.composer.composeUint(tag, cborTag); err != nil {
+		panic(err)
+	}
+	enc.encodeByteString(b)
+}
+
+// marks the given reference as being encoded, if it was already
+// being encoded the value contains a cycle and it can't be encoded
+func (enc *Encoder) enterReference(ref encodeRef, t reflect.Type) {
+	if _, ok := enc.encoding[ref]; ok {
+		panic(&CyclicReferenceError{Type: t})
+	}
+	if enc.encoding == nil {
+		enc.encoding = make(map[encodeRef]struct{})
+	}
+	enc.encoding[ref] = struct{}{}
+}
+
+// marks the given reference as completely encoded
+func (enc *Encoder) leaveReference(ref encodeRef) {
+	delete(enc.encoding, ref)
+}
+
 // Encode a float16
 func (enc *Encoder) encodeFloat16(v float16) {
+	if enc.canonical {
+		if err := enc.composer.composeShortestFloat(float64(v)); err != nil {
+			panic(err)
+		}
+		return
+	}
 	if err := enc.composer.composeFloat16(v); err != nil {
 		panic(err)
 	}
@@ -313,6 +969,12 @@ func (enc *Encoder) encodeFloat16(v float16) {
 
 // Encode a float32
 func (enc *Encoder) encodeFloat32(v float32) {
+	if enc.canonical {
+		if err := enc.composer.composeShortestFloat(float64(v)); err != nil {
+			panic(err)
+		}
+		return
+	}
 	if err := enc.composer.composeFloat32(v); err != nil {
 		panic(err)
 	}
@@ -320,6 +982,12 @@ func (enc *Encoder) encodeFloat32(v float32) {
 
 // Encode a float64
 func (enc *Encoder) encodeFloat64(v float64) {
+	if enc.canonical {
+		if err := enc.composer.composeShortestFloat(float64(v)); err != nil {
+			panic(err)
+		}
+		return
+	}
 	if err := enc.composer.composeFloat64(v); err != nil {
 		panic(err)
 	}
@@ -346,9 +1014,21 @@ func (enc *Encoder) encodeBigInt(v big.Int) {
 	}
 }
 
-// Encode a datetime as epoch
+// Encode a datetime using the encoder time format
 func (enc *Encoder) encodeEpochDateTime(v time.Time) {
-	if err := enc.composer.composeEpochDateTime(v); err != nil {
+	var err error
+	switch {
+	case enc.timeFormat == TimeRFC3339:
+		err = enc.composer.composeStringDateTime(v)
+	case enc.timeFormat == TimeEpochFloat,
+		enc.timeFormat == TimeEpoch && v.Nanosecond() != 0:
+		if err = enc.composer.write1(absoluteEpochDateTime); err == nil {
+			enc.encodeFloat64(float64(v.Unix()) + float64(v.Nanosecond())/1e9)
+		}
+	default:
+		err = enc.composer.composeEpochDateTime(v)
+	}
+	if err != nil {
 		panic(err)
 	}
 }
@@ -360,6 +1040,20 @@ func (enc *Encoder) encodeBigFloat(v big.Rat) {
 	}
 }
 
+// Encode a big float keeping its mantissa and exponent
+func (enc *Encoder) encodeExactBigFloat(v BigFloat) {
+	if err := enc.composer.composeExactBigFloat(v); err != nil {
+		panic(err)
+	}
+}
+
+// Encode a decimal fraction
+func (enc *Encoder) encodeDecimalFraction(v DecimalFraction) {
+	if err := enc.composer.composeDecimalFraction(v); err != nil {
+		panic(err)
+	}
+}
+
 // Encode a Text String (UTF-8)
 func (enc *Encoder) encodeTextString(v string) {
 	if err := enc.composer.composeString(v); err != nil {
@@ -370,22 +1064,28 @@ func (enc *Encoder) encodeTextString(v string) {
 // Encode an Slice
 func (enc *Encoder) encodeSlice(rv reflect.Value) {
 	etp := rv.Type().Elem()
-	if etp.Kind() == reflect.Uint8 {
+	if etp.Kind() == reflect.Uint8 && etp != typeSimple {
 		// Bytes String
+		if rv.Kind() == reflect.Array {
+			// arrays are not always addressable so its bytes are copied
+			b := make([]byte, rv.Len())
+			for i := range b {
+				b[i] = byte(rv.Index(i).Uint())
+			}
+			enc.encodeByteString(b)
+			return
+		}
 		enc.encodeByteString(rv.Bytes())
 		return
 	}
 	l := rv.Len()
-	info, err := calculateInfoFromIntLength(l)
-	if err != nil {
-		panic(err)
+	if enc.threshold > 0 && l > enc.threshold && !enc.canonical {
+		enc.encodeIndefiniteSlice(rv)
+		return
 	}
-	if err := enc.composer.composeInformation(cborDataArray, info); err != nil {
+	if _, err := enc.composer.composeUint(uint64(l), cborDataArray); err != nil {
 		panic(err)
 	}
-	if info > cborSmallInt {
-		enc.encodeUint(uint64(l))
-	}
 	for i := 0; i < l; i++ {
 		if err := enc.encode(rv.Index(i)); err != nil {
 			panic(err)
@@ -393,82 +1093,278 @@ func (enc *Encoder) encodeSlice(rv reflect.Value) {
 	}
 }
 
+// Encode an Slice as an indefinite-length array
+func (enc *Encoder) encodeIndefiniteSlice(rv reflect.Value) {
+	if err := enc.composer.composeInformation(cborDataArray, cborIndefinite); err != nil {
+		panic(err)
+	}
+	for i := 0; i < rv.Len(); i++ {
+		if err := enc.encode(rv.Index(i)); err != nil {
+			panic(err)
+		}
+	}
+	if err := enc.composer.composeBreak(); err != nil {
+		panic(err)
+	}
+}
+
 // Encode a Map
 func (enc *Encoder) encodeMap(rv reflect.Value) {
-	l := rv.Len()
-	info, err := calculateInfoFromIntLength(l)
-	if err != nil {
+	if _, err := enc.composer.composeUint(uint64(rv.Len()), cborDataMap); err != nil {
 		panic(err)
 	}
-	if err := enc.composer.composeInformation(cborDataMap, info); err != nil {
+	if !enc.canonical {
+		for _, key := range rv.MapKeys() {
+			enc.checkMapKey(key)
+			if err := enc.encode(key); err != nil {
+				panic(err)
+			}
+			if err := enc.encode(rv.MapIndex(key)); err != nil {
+				panic(err)
+			}
+		}
+		return
+	}
+	pairs := make([][2][]byte, 0, rv.Len())
+	for _, key := range rv.MapKeys() {
+		enc.checkMapKey(key)
+		pairs = append(pairs, enc.encodePair(func() error {
+			return enc.encode(key)
+		}, func() error {
+			return enc.encode(rv.MapIndex(key))
+		}))
+	}
+	sortPairs(pairs)
+	enc.writePairs(pairs)
+}
+
+// Encode a map[K]struct{} set as an array of its keys
+func (enc *Encoder) encodeSet(rv reflect.Value) {
+	if enc.setsTag {
+		if _, err := enc.composer.composeUint(cborSet, cborTag); err != nil {
+			panic(err)
+		}
+	}
+	if _, err := enc.composer.composeUint(uint64(rv.Len()), cborDataArray); err != nil {
 		panic(err)
 	}
-	if info > cborSmallInt {
-		enc.encodeUint(uint64(l))
+	if !enc.canonical {
+		for _, key := range rv.MapKeys() {
+			enc.checkMapKey(key)
+			if err := enc.encode(key); err != nil {
+				panic(err)
+			}
+		}
+		return
 	}
+	// keys are sorted as the keys of a canonical map
+	pairs := make([][2][]byte, 0, rv.Len())
 	for _, key := range rv.MapKeys() {
-		if err := enc.encode(key); err != nil {
+		enc.checkMapKey(key)
+		pairs = append(pairs, enc.encodePair(func() error {
+			return enc.encode(key)
+		}, func() error {
+			return nil
+		}))
+	}
+	sortPairs(pairs)
+	enc.writePairs(pairs)
+}
+
+// in strict mode string keys must be valid UTF-8 as
+// they are encoded as text strings
+func (enc *Encoder) checkMapKey(key reflect.Value) {
+	if enc.strict && key.Kind() == reflect.String && !utf8.ValidString(key.String()) {
+		panic(NewStrictModeError(fmt.Sprintf("map key %q is not valid UTF-8", key.String())))
+	}
+}
+
+// Encode a key and a value into its own buffers
+func (enc *Encoder) encodePair(key, value func() error) [2][]byte {
+	w := enc.composer.w
+	defer func() {
+		enc.composer.w = w
+	}()
+	var pair [2][]byte
+	for i, fn := range []func() error{key, value} {
+		buf := bytes.NewBuffer(nil)
+		enc.composer.w = buf
+		if err := fn(); err != nil {
 			panic(err)
 		}
-		if err := enc.encode(rv.MapIndex(key)); err != nil {
+		pair[i] = buf.Bytes()
+	}
+	return pair
+}
+
+// Write the encoded keys and values of a map
+func (enc *Encoder) writePairs(pairs [][2][]byte) {
+	for _, pair := range pairs {
+		if _, err := enc.composer.write(pair[0]); err != nil {
+			panic(err)
+		}
+		if _, err := enc.composer.write(pair[1]); err != nil {
 			panic(err)
 		}
 	}
-
 }
 
 // Encode a Struct
 func (enc *Encoder) encodeStruct(rv reflect.Value, array ...bool) {
 	// buffer the fields encoding
-	buf := bytes.NewBuffer(nil)
-	w := enc.composer.w
-	enc.composer.w = buf
+	var pairs [][2][]byte
+	orders := map[int]int{} // fields encoding index by its order tag option
+	enc.eachStructField(rv, func(fv reflect.Value, field reflect.StructField, encodeKey func() error, opts tagOptions) {
+		if s, ok := opts.Value("order"); ok {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				panic(fmt.Errorf("invalid order %q for field %s", s, field.Name))
+			}
+			if _, ok := orders[n]; ok {
+				panic(fmt.Errorf("duplicated order %d for field %s", n, field.Name))
+			}
+			orders[n] = len(pairs)
+		}
+		pairs = append(pairs, enc.encodePair(encodeKey, func() error {
+			return enc.encodeField(fv, opts)
+		}))
+	})
+
+	l := len(pairs)
+	if len(array) > 0 && array[0] {
+		l *= 2
+	}
+	if _, err := enc.composer.composeUint(uint64(l), cborDataMap); err != nil {
+		panic(err)
+	}
+	if len(orders) > 0 {
+		pairs = orderPairs(pairs, orders)
+	} else if enc.canonical {
+		sortPairs(pairs)
+	}
+	enc.writePairs(pairs)
+}
+
+// Encode a Struct as an indefinite length map writing
+// its fields as they are found, without buffering them
+func (enc *Encoder) encodeStructStream(rv reflect.Value) {
+	if err := enc.composer.composeInformation(cborDataMap, cborIndefinite); err != nil {
+		panic(err)
+	}
+	enc.eachStructField(rv, func(fv reflect.Value, _ reflect.StructField, encodeKey func() error, opts tagOptions) {
+		if err := encodeKey(); err != nil {
+			panic(err)
+		}
+		if err := enc.encodeField(fv, opts); err != nil {
+			panic(err)
+		}
+	})
+	if err := enc.composer.composeBreak(); err != nil {
+		panic(err)
+	}
+}
 
-	exportedFields := 0
+// calls fn with every field of the struct that has to be encoded
+// and the function that writes its key, either its name or its tag
+func (enc *Encoder) eachStructField(rv reflect.Value, fn func(reflect.Value, reflect.StructField, func() error, tagOptions)) {
 	numfields := rv.NumField()
 	for i := 0; i < numfields; i++ {
 		field := rv.Type().Field(i)
 		key := field.Name
-		if unicode.IsUpper(rune(key[0])) {
-			tag := field.Tag.Get("cbor")
-			if tag != "" {
-				if tag == "-" {
-					continue
-				}
-				key = tag
-			}
-			exportedFields++
+		if !unicode.IsUpper(rune(key[0])) {
+			continue
+		}
+		name, opts := parseTag(fieldTag(field, enc.jsonTags))
+		if name == "-" || opts.Contains("raw") { // raw fields are only decoded
+			continue
+		}
+		if name != "" {
+			key = name
+		}
+		if opts.Contains("omitempty") && isEmptyValue(rv.Field(i)) {
+			continue
+		}
+		encodeKey := func() error {
 			enc.encodeTextString(key)
-			if err := enc.encode(rv.Field(i)); err != nil {
-				panic(err)
+			return nil
+		}
+		if opts.Contains("keyasint") {
+			n, err := strconv.ParseInt(key, 10, 64)
+			if err != nil {
+				panic(fmt.Errorf("invalid integer key %q for field %s", key, field.Name))
+			}
+			encodeKey = func() error {
+				_, err := enc.composer.composeInt(n)
+				return err
 			}
 		}
+		fn(rv.Field(i), field, encodeKey, opts)
 	}
+}
 
-	enc.composer.w = w
-	var info byte
-	if len(array) > 0 && array[0] {
-		info, _ = calculateInfoFromIntLength(exportedFields * 2)
-	} else {
-		info, _ = calculateInfoFromIntLength(exportedFields)
-	}
-	if err := enc.composer.composeInformation(cborDataMap, info); err != nil {
-		panic(err)
+// returns true if any field of the struct has the `order` option
+func hasOrderedFields(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if _, opts := parseTag(t.Field(i).Tag.Get("cbor")); opts.Contains("order") {
+			return true
+		}
 	}
-	if _, err := enc.composer.write(buf.Bytes()); err != nil {
-		panic(err)
+	return false
+}
+
+// returns true if v is the zero value of a basic type or an
+// empty container, the same empty values of encoding/json
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
 	}
+	return false
 }
 
-// helper function that calculates the size
-// of the info byte depending on the given length
-func calculateInfoFromIntLength(l int) (info byte, err error) {
-	if l < int(cborSmallInt) {
-		info = byte(l)
-	} else {
-		if info, err = infoHelper(uint(l)); err != nil {
-			return 0, err
+// returns the fields with an order tag option sorted by its order followed
+// by the fields without it in the same order they were declared
+func orderPairs(pairs [][2][]byte, orders map[int]int) [][2][]byte {
+	keys := make([]int, 0, len(orders))
+	for n := range orders {
+		keys = append(keys, n)
+	}
+	sort.Ints(keys)
+	ordered := make([][2][]byte, 0, len(pairs))
+	used := make([]bool, len(pairs))
+	for _, n := range keys {
+		ordered = append(ordered, pairs[orders[n]])
+		used[orders[n]] = true
+	}
+	for i, pair := range pairs {
+		if !used[i] {
+			ordered = append(ordered, pair)
 		}
 	}
-	return info, nil
+	return ordered
+}
+
+// Encode a struct field honoring the options of its tag, `bytes` forces
+// strings to be written as byte strings and `text` forces byte slices
+// to be written as UTF-8 text strings
+func (enc *Encoder) encodeField(fv reflect.Value, opts tagOptions) error {
+	switch {
+	case opts.Contains("bytes") && fv.Kind() == reflect.String:
+		enc.encodeByteString([]byte(fv.String()))
+	case opts.Contains("text") && fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.Uint8:
+		enc.encodeTextString(string(fv.Bytes()))
+	default:
+		return enc.encode(fv)
+	}
+	return nil
 }
@@ -17,8 +17,13 @@ package cbor
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"math"
 	"math/big"
+	"net"
+	"net/netip"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -618,6 +623,53 @@ func TestEncodePoiinterToNegativeBigNum(t *testing.T) {
 	expect(buf.Bytes()[10], byte(0x00), t, "TestEncodePoiinterToNegativeBigNum")
 }
 
+func TestEncodeNegativeBigNumBoundaries(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf)
+	bn := big.NewInt(-256)
+	check(e.Encode(bn))
+	expected := []byte{0xc3, 0x41, 0xff}
+	expect(buf.Len(), len(expected), t, "TestEncodeNegativeBigNumBoundaries")
+	for i, c := range expected {
+		expect(buf.Bytes()[i], c, t, "TestEncodeNegativeBigNumBoundaries")
+	}
+
+	buf.Reset()
+	bn = big.NewInt(-65537)
+	check(e.Encode(bn))
+	expected = []byte{0xc3, 0x43, 0x01, 0x00, 0x00}
+	expect(buf.Len(), len(expected), t, "TestEncodeNegativeBigNumBoundaries")
+	for i, c := range expected {
+		expect(buf.Bytes()[i], c, t, "TestEncodeNegativeBigNumBoundaries")
+	}
+
+	buf.Reset()
+	bn = new(big.Int).Lsh(big.NewInt(-1), 64)
+	check(e.Encode(bn))
+	expected = []byte{0xc3, 0x48, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	expect(buf.Len(), len(expected), t, "TestEncodeNegativeBigNumBoundaries")
+	for i, c := range expected {
+		expect(buf.Bytes()[i], c, t, "TestEncodeNegativeBigNumBoundaries")
+	}
+	a := big.NewInt(-1)
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(a))
+	expect(a.Cmp(bn), 0, t, "TestEncodeNegativeBigNumBoundaries")
+}
+
+func TestEncodeNegativeBigNumKeepsValue(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf)
+	bn := big.NewInt(-256)
+	check(e.Encode(*bn))
+	check(e.Encode(bn))
+	expect(bn.String(), "-256", t, "TestEncodeNegativeBigNumKeepsValue")
+	expected := []byte{0xc3, 0x41, 0xff, 0xc3, 0x41, 0xff}
+	expect(buf.Len(), len(expected), t, "TestEncodeNegativeBigNumKeepsValue")
+	for i, c := range expected {
+		expect(buf.Bytes()[i], c, t, "TestEncodeNegativeBigNumKeepsValue")
+	}
+}
+
 func TestEncodeEpochDateTime(t *testing.T) {
 	buf := bytes.NewBuffer(nil)
 	e := NewEncoder(buf)
@@ -650,16 +702,31 @@ func TestEncodeBigFloat(t *testing.T) {
 	check(e.Encode(*v))
 	expect(buf.Bytes()[0], byte(0xc5), t, "TestEncodeBigFloat")
 	expect(buf.Bytes()[1], byte(0x82), t, "TestEncodeBigFloat")
-	expect(buf.Bytes()[2], byte(0x01), t, "TestEncodeBigFloat")
-	expect(buf.Bytes()[3], byte(0xfb), t, "TestEncodeBigFloat")
-	expect(buf.Bytes()[4], byte(0x3f), t, "TestEncodeBigFloat")
-	expect(buf.Bytes()[5], byte(0xe8), t, "TestEncodeBigFloat")
-	expect(buf.Bytes()[6], byte(0x00), t, "TestEncodeBigFloat")
-	expect(buf.Bytes()[7], byte(0x00), t, "TestEncodeBigFloat")
-	expect(buf.Bytes()[8], byte(0x00), t, "TestEncodeBigFloat")
-	expect(buf.Bytes()[9], byte(0x00), t, "TestEncodeBigFloat")
-	expect(buf.Bytes()[10], byte(0x00), t, "TestEncodeBigFloat")
-	expect(buf.Bytes()[11], byte(0x00), t, "TestEncodeBigFloat")
+	expect(buf.Bytes()[2], byte(0x20), t, "TestEncodeBigFloat")
+	expect(buf.Bytes()[3], byte(0x03), t, "TestEncodeBigFloat")
+	expect(buf.Len(), 4, t, "TestEncodeBigFloat")
+}
+
+func TestEncodeBigFloatRoundTrip(t *testing.T) {
+	for _, v := range []*big.Rat{
+		big.NewRat(1, 2), big.NewRat(3, 4), big.NewRat(5, 8), big.NewRat(-5, 8),
+		big.NewRat(12, 1), big.NewRat(0, 1), new(big.Rat).SetFrac(
+			new(big.Int).Lsh(big.NewInt(3), 70), new(big.Int).Lsh(big.NewInt(1), 80)),
+	} {
+		buf := bytes.NewBuffer(nil)
+		check(NewEncoder(buf).Encode(v))
+		var r big.Rat
+		check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&r))
+		expect(r.Cmp(v), 0, t, "TestEncodeBigFloatRoundTrip")
+	}
+}
+
+func TestEncodeBigFloatNonBinaryFraction(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	err := NewEncoder(buf).Encode(big.NewRat(1, 3))
+	expect(err != nil, true, t, "TestEncodeBigFloatNonBinaryFraction")
+	msg := "1/3 can't be encoded as a big float, it isn't a binary fraction"
+	expect(err.Error(), msg, t, "TestEncodeBigFloatNonBinaryFraction")
 }
 
 func TestEncodePointerToBigFloat(t *testing.T) {
@@ -669,16 +736,86 @@ func TestEncodePointerToBigFloat(t *testing.T) {
 	check(e.Encode(v))
 	expect(buf.Bytes()[0], byte(0xc5), t, "TestEncodePointerToBigFloat")
 	expect(buf.Bytes()[1], byte(0x82), t, "TestEncodePointerToBigFloat")
-	expect(buf.Bytes()[2], byte(0x01), t, "TestEncodePointerToBigFloat")
-	expect(buf.Bytes()[3], byte(0xfb), t, "TestEncodePointerToBigFloat")
-	expect(buf.Bytes()[4], byte(0x3f), t, "TestEncodePointerToBigFloat")
-	expect(buf.Bytes()[5], byte(0xe8), t, "TestEncodePointerToBigFloat")
-	expect(buf.Bytes()[6], byte(0x00), t, "TestEncodePointerToBigFloat")
-	expect(buf.Bytes()[7], byte(0x00), t, "TestEncodePointerToBigFloat")
-	expect(buf.Bytes()[8], byte(0x00), t, "TestEncodePointerToBigFloat")
-	expect(buf.Bytes()[9], byte(0x00), t, "TestEncodePointerToBigFloat")
-	expect(buf.Bytes()[10], byte(0x00), t, "TestEncodePointerToBigFloat")
-	expect(buf.Bytes()[11], byte(0x00), t, "TestEncodePointerToBigFloat")
+	expect(buf.Bytes()[2], byte(0x20), t, "TestEncodePointerToBigFloat")
+	expect(buf.Bytes()[3], byte(0x03), t, "TestEncodePointerToBigFloat")
+	expect(buf.Len(), 4, t, "TestEncodePointerToBigFloat")
+}
+
+func TestEncodeDecimalFraction(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf)
+	v := DecimalFraction{Exponent: -2, Mantissa: big.NewInt(27315)}
+	check(e.Encode(v))
+	expected := []byte{0xc4, 0x82, 0x21, 0x19, 0x6a, 0xb3}
+	expect(buf.Len(), len(expected), t, "TestEncodeDecimalFraction")
+	for i, c := range expected {
+		expect(buf.Bytes()[i], c, t, "TestEncodeDecimalFraction")
+	}
+
+	buf.Reset()
+	m := new(big.Int)
+	m.SetString("-18446744073709551617", 10)
+	v = DecimalFraction{Exponent: -20, Mantissa: m}
+	check(e.Encode(&v))
+	var a DecimalFraction
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&a))
+	expect(a.Exponent, v.Exponent, t, "TestEncodeDecimalFraction")
+	expect(a.Mantissa.Cmp(m), 0, t, "TestEncodeDecimalFraction")
+}
+
+func TestEncodeDecimalFractionFromFloat(t *testing.T) {
+	v, err := NewDecimalFraction(273.15)
+	check(err)
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf).Encode(v))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "c48221196ab3", t, "TestEncodeDecimalFractionFromFloat")
+	var f float32
+	check(NewDecoder(buf).Decode(&f))
+	expect(f, float32(273.15), t, "TestEncodeDecimalFractionFromFloat")
+
+	v, err = NewDecimalFraction(-1500)
+	check(err)
+	expect(v.Exponent, int64(0), t, "TestEncodeDecimalFractionFromFloat")
+	expect(v.Mantissa.Int64(), int64(-1500), t, "TestEncodeDecimalFractionFromFloat")
+
+	_, err = NewDecimalFraction(float32(math.Inf(1)))
+	expect(err != nil, true, t, "TestEncodeDecimalFractionFromFloat")
+}
+
+func TestEncodeDecodeExactBigFloat(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf)
+	v := BigFloat{Exponent: -1, Mantissa: big.NewInt(3)}
+	check(e.Encode(v))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "c5822003", t, "TestEncodeDecodeExactBigFloat")
+	var a BigFloat
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&a))
+	expect(a.Exponent, int64(-1), t, "TestEncodeDecodeExactBigFloat")
+	expect(a.Mantissa.Int64(), int64(3), t, "TestEncodeDecodeExactBigFloat")
+	r, err := a.Rat()
+	check(err)
+	expect(r.String(), "3/2", t, "TestEncodeDecodeExactBigFloat")
+
+	// the mantissa is not normalized (12 * 2^-3 is also 3/2)
+	buf.Reset()
+	m, _ := new(big.Int).SetString("-120000000000000000000", 10)
+	check(e.Encode(&BigFloat{Exponent: -3, Mantissa: m}))
+	var i interface{}
+	check(NewDecoder(bytes.NewReader(buf.Bytes()), WithExactBigFloats()).Decode(&i))
+	expect(i.(BigFloat).Exponent, int64(-3), t, "TestEncodeDecodeExactBigFloat")
+	expect(i.(BigFloat).Mantissa.Cmp(m), 0, t, "TestEncodeDecodeExactBigFloat")
+
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&i))
+	expect(i.(*big.Rat).String(), "-15000000000000000000/1", t, "TestEncodeDecodeExactBigFloat")
+
+	// decimal fractions keep its mantissa and exponent too
+	buf.Reset()
+	d := DecimalFraction{Exponent: -4, Mantissa: big.NewInt(1234500)}
+	check(e.Encode(d))
+	var df DecimalFraction
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&df))
+	expect(df.Exponent, int64(-4), t, "TestEncodeDecodeExactBigFloat")
+	expect(df.Mantissa.Int64(), int64(1234500), t, "TestEncodeDecodeExactBigFloat")
 }
 
 func TestEncodeString(t *testing.T) {
@@ -880,6 +1017,23 @@ func TestEncodeSliceOfSlicesOfBools(t *testing.T) {
 	expect(buf.Bytes()[9], absoluteTrue, t, "TestEncodeSliceOfSliceOfBools")
 }
 
+func TestEncodeInterfaceSliceWithNil(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf)
+	check(e.Encode([]interface{}{1, nil, "x"}))
+	expected := []byte{0x83, 0x01, 0xf6, 0x61, 0x78}
+	expect(buf.Len(), len(expected), t, "TestEncodeInterfaceSliceWithNil")
+	for i, c := range expected {
+		expect(buf.Bytes()[i], c, t, "TestEncodeInterfaceSliceWithNil")
+	}
+	var a []interface{}
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&a))
+	expect(len(a), 3, t, "TestEncodeInterfaceSliceWithNil")
+	expect(a[0], uint8(1), t, "TestEncodeInterfaceSliceWithNil")
+	expect(a[1], nil, t, "TestEncodeInterfaceSliceWithNil")
+	expect(a[2], "x", t, "TestEncodeInterfaceSliceWithNil")
+}
+
 func TestEncodeMapOfStringInt(t *testing.T) {
 	buf := bytes.NewBuffer(nil)
 	e := NewEncoder(buf)
@@ -928,6 +1082,1099 @@ func TestEncodeStruct(t *testing.T) {
 	// age := []byte{0x41, 0x67, 0x65}
 }
 
+func TestEncodeSliceStreamThreshold(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf, WithArrayStreamThreshold(3))
+	check(e.Encode([]uint{1, 2, 3}))
+	expect(buf.Bytes()[0], byte(0x83), t, "TestEncodeSliceStreamThreshold")
+	expect(buf.Len(), 4, t, "TestEncodeSliceStreamThreshold")
+	var a []uint
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&a))
+	expect(len(a), 3, t, "TestEncodeSliceStreamThreshold")
+	expect(a[2], uint(3), t, "TestEncodeSliceStreamThreshold")
+
+	buf.Reset()
+	check(e.Encode([]uint{1, 2, 3, 4}))
+	expect(buf.Bytes()[0], byte(absoluteIndefiniteArray), t, "TestEncodeSliceStreamThreshold")
+	expect(buf.Bytes()[5], cborBreak, t, "TestEncodeSliceStreamThreshold")
+	expect(buf.Len(), 6, t, "TestEncodeSliceStreamThreshold")
+	var b []uint
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&b))
+	expect(len(b), 4, t, "TestEncodeSliceStreamThreshold")
+	expect(b[3], uint(4), t, "TestEncodeSliceStreamThreshold")
+}
+
+func TestEncodeStructBytesTextOverride(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf)
+	type MyType struct {
+		Data string `cbor:",bytes"`
+		Raw  []byte `cbor:"raw,text"`
+	}
+	v := MyType{Data: "\x00\x01", Raw: []byte("abc")}
+	check(e.Encode(v))
+	expect(buf.Bytes()[0], byte(0xa2), t, "TestEncodeStructBytesTextOverride")
+	expect(buf.Bytes()[6], byte(0x42), t, "TestEncodeStructBytesTextOverride")
+	expect(buf.Bytes()[9], byte(0x63), t, "TestEncodeStructBytesTextOverride")
+	expect(buf.Bytes()[13], byte(0x63), t, "TestEncodeStructBytesTextOverride")
+	var a MyType
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&a))
+	expect(a.Data, v.Data, t, "TestEncodeStructBytesTextOverride")
+	expect(string(a.Raw), "abc", t, "TestEncodeStructBytesTextOverride")
+}
+
+func TestEncodeBigIntSlice(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf)
+	large := new(big.Int)
+	large.SetString("18446744073709551616", 10)
+	negative := new(big.Int)
+	negative.SetString("-18446744073709551617", 10)
+	v := []*big.Int{large, big.NewInt(10), negative, big.NewInt(-256)}
+	check(e.Encode(v))
+	expect(buf.Bytes()[0], byte(0x84), t, "TestEncodeBigIntSlice")
+	expect(buf.Bytes()[1], byte(0xc2), t, "TestEncodeBigIntSlice")
+	var a []*big.Int
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&a))
+	expect(len(a), len(v), t, "TestEncodeBigIntSlice")
+	for i := range v {
+		expect(a[i].Cmp(v[i]), 0, t, "TestEncodeBigIntSlice")
+	}
+
+	buf.Reset()
+	w := []big.Int{*large, *big.NewInt(-1)}
+	check(e.Encode(w))
+	var b []big.Int
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&b))
+	expect(len(b), len(w), t, "TestEncodeBigIntSlice")
+	for i := range w {
+		expect(b[i].Cmp(&w[i]), 0, t, "TestEncodeBigIntSlice")
+	}
+
+	var c []*big.Int
+	check(NewDecoder(bytes.NewReader([]byte{0x82, 0x0a, 0x38, 0xff})).Decode(&c))
+	expect(c[0].Int64(), int64(10), t, "TestEncodeBigIntSlice")
+	expect(c[1].Int64(), int64(-256), t, "TestEncodeBigIntSlice")
+}
+
+func TestEncodeSimpleValue(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf)
+	check(e.Encode(Simple(16)))
+	check(e.Encode(Simple(255)))
+	check(e.Encode([]Simple{16}))
+	expected := []byte{0xf0, 0xf8, 0xff, 0x81, 0xf0}
+	expect(buf.Len(), len(expected), t, "TestEncodeSimpleValue")
+	for i, c := range expected {
+		expect(buf.Bytes()[i], c, t, "TestEncodeSimpleValue")
+	}
+	expect(e.Encode(Simple(24)) != nil, true, t, "TestEncodeSimpleValue")
+}
+
+func TestEncodeRuneSliceAndMap(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf)
+	check(e.Encode([]rune("añ€")))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "83186118f11920ac", t, "TestEncodeRuneSliceAndMap")
+	buf.Reset()
+	check(e.Encode(map[rune]int{'a': 1}))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "a1186101", t, "TestEncodeRuneSliceAndMap")
+	buf.Reset()
+	check(e.Encode([]byte("añ")))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "4361c3b1", t, "TestEncodeRuneSliceAndMap")
+}
+
+type OrderedFrame struct {
+	Payload string `cbor:"p,order=3"`
+	Kind    uint8  `cbor:"k,order=1"`
+	Extra   bool   `cbor:"e"`
+	Version uint8  `cbor:"v,order=2"`
+}
+
+type DuplicatedOrderFrame struct {
+	A uint8 `cbor:",order=1"`
+	B uint8 `cbor:",order=1"`
+}
+
+func TestEncodeStructFieldOrder(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf, WithCanonical())
+	check(e.Encode(OrderedFrame{Payload: "x", Kind: 1, Extra: true, Version: 2}))
+	// k, v, p and then e
+	expect(fmt.Sprintf("%x", buf.Bytes()), "a4616b01617602617061786165f5", t, "TestEncodeStructFieldOrder")
+	buf.Reset()
+	err := e.Encode(DuplicatedOrderFrame{})
+	expect(err != nil, true, t, "TestEncodeStructFieldOrder")
+	expect(err.Error(), "duplicated order 1 for field B", t, "TestEncodeStructFieldOrder")
+}
+
+func TestEncodeArrayAndMapStreams(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf)
+	check(e.EncodeArrayStream(func(enc *Encoder) error {
+		for i := uint(1); i <= 3; i++ {
+			if err := enc.Encode(i * 100); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "9f186418c819012cff", t, "TestEncodeArrayAndMapStreams")
+	var s []uint
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&s))
+	expect(len(s), 3, t, "TestEncodeArrayAndMapStreams")
+	expect(s[2], uint(300), t, "TestEncodeArrayAndMapStreams")
+
+	buf.Reset()
+	check(e.EncodeMapStream(func(enc *Encoder) error {
+		check(enc.Encode("a"))
+		return enc.Encode("b")
+	}))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "bf61616162ff", t, "TestEncodeArrayAndMapStreams")
+	var m map[string]string
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&m))
+	expect(m["a"], "b", t, "TestEncodeArrayAndMapStreams")
+
+	err := NewEncoder(buf, WithCanonical()).EncodeArrayStream(func(*Encoder) error { return nil })
+	expect(err != nil, true, t, "TestEncodeArrayAndMapStreams")
+}
+
+func TestEncodeSeq(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	i := 0
+	check(NewEncoder(buf).EncodeSeq(func() (interface{}, bool) {
+		i++
+		return i * 10, i <= 4
+	}))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "9f0a14181e1828ff", t, "TestEncodeSeq")
+	var s []int
+	check(NewDecoder(buf).Decode(&s))
+	expect(fmt.Sprint(s), "[10 20 30 40]", t, "TestEncodeSeq")
+
+	buf.Reset()
+	check(NewEncoder(buf).EncodeSeq(func() (interface{}, bool) { return nil, false }))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "9fff", t, "TestEncodeSeq")
+}
+
+type LegacyFlags struct {
+	Active  bool
+	Deleted bool
+}
+
+func TestEncodeBoolsAsInt(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf, BoolsAsInt(), WithCanonical())
+	check(e.Encode(LegacyFlags{Active: true}))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "a266416374697665016744656c6574656400", t, "TestEncodeBoolsAsInt")
+	var f LegacyFlags
+	check(NewDecoder(bytes.NewReader(buf.Bytes()), LenientBools()).Decode(&f))
+	expect(f.Active, true, t, "TestEncodeBoolsAsInt")
+	expect(f.Deleted, false, t, "TestEncodeBoolsAsInt")
+
+	buf.Reset()
+	check(e.Encode(map[string]bool{"a": false}))
+	check(e.Encode(true))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "a161610001", t, "TestEncodeBoolsAsInt")
+	var m map[string]bool
+	var b bool
+	d := NewDecoder(bytes.NewReader(buf.Bytes()), LenientBools())
+	check(d.Decode(&m))
+	check(d.Decode(&b))
+	expect(m["a"], false, t, "TestEncodeBoolsAsInt")
+	expect(b, true, t, "TestEncodeBoolsAsInt")
+
+	err := NewDecoder(bytes.NewReader([]byte{0x02}), LenientBools()).Decode(&b)
+	expect(errors.Unwrap(err).Error(), "can't decode 2 as a boolean", t, "TestEncodeBoolsAsInt")
+}
+
+func TestEncodeErrorChains(t *testing.T) {
+	base := errors.New("disk full")
+	err := fmt.Errorf("query: %w", fmt.Errorf("write: %w", base))
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf, ErrorChains())
+	check(e.Encode(err))
+	var messages []string
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&messages))
+	expect(len(messages), 3, t, "TestEncodeErrorChains")
+	expect(messages[0], "query: write: disk full", t, "TestEncodeErrorChains")
+	expect(messages[1], "write: disk full", t, "TestEncodeErrorChains")
+	expect(messages[2], "disk full", t, "TestEncodeErrorChains")
+
+	buf.Reset()
+	check(e.Encode(map[string]error{"err": base}))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "a16365727281696469736b2066756c6c", t, "TestEncodeErrorChains")
+}
+
+func (id OrderID) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("ORD-%d", id.Number)), nil
+}
+
+func TestEncodeTextMarshaler(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf)
+	check(e.Encode(OrderID{Number: 42}))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "664f52442d3432", t, "TestEncodeTextMarshaler")
+
+	buf.Reset()
+	check(e.Encode(&OrderID{Number: 42}))
+	// net.IP is a text marshaler but it is encoded as a byte string
+	check(e.Encode(net.ParseIP("10.0.0.1").To4()))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "664f52442d3432"+"d834440a000001", t, "TestEncodeTextMarshaler")
+
+	buf.Reset()
+	check(e.Encode(Order{ID: OrderID{Number: 7}, Price: 1000}))
+	var o Order
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&o))
+	expect(o.ID.Number, 7, t, "TestEncodeTextMarshaler")
+	expect(o.Price, uint16(1000), t, "TestEncodeTextMarshaler")
+}
+
+func TestEncodeTimeRoundTrip(t *testing.T) {
+	times := []time.Time{
+		time.Unix(1363896240, 0),
+		time.Unix(1363896240, 500000000),
+		time.Unix(1363896240, 123456789),
+		time.Unix(-14182940, 0),
+		time.Unix(-14182940, 250000000),
+		time.Date(1900, 1, 1, 12, 30, 15, 987654321, time.UTC),
+		time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(3000, 1, 1, 0, 0, 0, 123456789, time.UTC),
+		time.Date(9999, 12, 31, 23, 59, 59, 999999999, time.UTC),
+	}
+	formats := []TimeFormat{TimeEpoch, TimeEpochInt, TimeEpochFloat, TimeRFC3339}
+	for _, format := range formats {
+		for _, tm := range times {
+			buf := bytes.NewBuffer(nil)
+			check(NewEncoder(buf, WithTimeFormat(format)).Encode(tm))
+			var got time.Time
+			check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&got))
+			msg := fmt.Sprintf("TestEncodeTimeRoundTrip %d %s", format, tm)
+
+			float := format == TimeEpochFloat || format == TimeEpoch && tm.Nanosecond() != 0
+			switch {
+			case format == TimeRFC3339:
+				expect(buf.Bytes()[0], byte(absoluteStringDateTime), t, msg)
+				expect(got.Equal(tm), true, t, msg)
+			case float:
+				expect(buf.Bytes()[1], byte(absoluteFloat64), t, msg)
+				f := float64(tm.Unix()) + float64(tm.Nanosecond())/1e9
+				ulp := time.Duration((math.Nextafter(f, math.Inf(1))-f)*1e9) + 1
+				diff := got.Sub(tm)
+				expect(diff <= ulp && diff >= -ulp, true, t, msg)
+			default:
+				expect(buf.Bytes()[0], byte(absoluteEpochDateTime), t, msg)
+				expect(got.Equal(tm.Truncate(time.Second)), true, t, msg)
+			}
+		}
+	}
+}
+
+func TestEncodeSelfDescribe(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf, WithSelfDescribe())
+	for i := uint(1); i <= 3; i++ {
+		check(e.Encode(i))
+	}
+	expect(fmt.Sprintf("%x", buf.Bytes()), "d9d9f7010203", t, "TestEncodeSelfDescribe")
+
+	buf.Reset()
+	e = NewEncoder(buf, WithSelfDescribeEachItem())
+	for i := uint(1); i <= 3; i++ {
+		check(e.Encode(i))
+	}
+	expect(fmt.Sprintf("%x", buf.Bytes()), "d9d9f701d9d9f702d9d9f703", t, "TestEncodeSelfDescribe")
+
+	d := NewDecoder(bytes.NewReader(buf.Bytes()))
+	for i := uint(1); i <= 3; i++ {
+		var n uint8
+		check(d.Decode(&n))
+		expect(uint(n), i, t, "TestEncodeSelfDescribe")
+	}
+
+	buf.Reset()
+	e = NewEncoder(buf, WithSelfDescribeEachItem())
+	check(e.EncodeArrayStream(func(enc *Encoder) error {
+		check(enc.Encode(uint(1)))
+		return enc.Encode(uint(2))
+	}))
+	check(e.Encode(uint(3)))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "d9d9f79f0102ffd9d9f703", t, "TestEncodeSelfDescribe")
+}
+
+func TestEncodeTextDateTime(t *testing.T) {
+	tm := time.Date(2013, 3, 21, 22, 4, 0, 500000000, time.FixedZone("CEST", 2*60*60))
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf, TextDateTime).Encode(tm))
+	expect(buf.Bytes()[0], byte(absoluteStringDateTime), t, "TestEncodeTextDateTime")
+	expect(string(buf.Bytes()[3:]), "2013-03-21T22:04:00.5+02:00", t, "TestEncodeTextDateTime")
+
+	var got time.Time
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&got))
+	expect(got.Equal(tm), true, t, "TestEncodeTextDateTime")
+	// the decoder normalizes the offset to its location
+	_, offset := got.Zone()
+	expect(got.Location(), time.UTC, t, "TestEncodeTextDateTime")
+	expect(offset, 0, t, "TestEncodeTextDateTime")
+
+	check(NewDecoder(bytes.NewReader(buf.Bytes()), WithLocation(tm.Location())).Decode(&got))
+	expect(got.Equal(tm), true, t, "TestEncodeTextDateTime")
+	_, offset = got.Zone()
+	expect(offset, 2*60*60, t, "TestEncodeTextDateTime")
+}
+
+type GridPoint struct {
+	X int8 `cbor:"x"`
+	Y int8 `cbor:"y"`
+}
+
+func TestEncodeMapWithStructKeys(t *testing.T) {
+	m := map[GridPoint]string{{X: 1, Y: 2}: "a", {X: -3, Y: 4}: "b"}
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf, WithCanonical()).Encode(m))
+	// keys are maps themselves, ordered by their encoded bytes
+	expect(fmt.Sprintf("%x", buf.Bytes()), "a2a26178016179026161a26178226179046162", t, "TestEncodeMapWithStructKeys")
+
+	var got map[GridPoint]string
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&got))
+	expect(len(got), 2, t, "TestEncodeMapWithStructKeys")
+	expect(got[GridPoint{X: 1, Y: 2}], "a", t, "TestEncodeMapWithStructKeys")
+	expect(got[GridPoint{X: -3, Y: 4}], "b", t, "TestEncodeMapWithStructKeys")
+}
+
+func TestEncodeCanonicalIntKeys(t *testing.T) {
+	m := map[int64]string{
+		0: "a", 23: "b", 24: "c", -1: "d", -24: "e", -25: "f", 256: "g", -1000: "h",
+	}
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf, WithCanonical()).Encode(m))
+	// keys use its shortest form and sort by length first and then bytewise:
+	// 0, 23, -1, -24, 24, -25, 256, -1000
+	expect(fmt.Sprintf("%x", buf.Bytes()),
+		"a8"+"006161"+"176162"+"206164"+"376165"+"18186163"+"38186166"+"1901006167"+"3903e76168",
+		t, "TestEncodeCanonicalIntKeys")
+
+	// int64 destinations require 8 bytes integers, int accepts any width
+	var got map[int]string
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&got))
+	expect(len(got), len(m), t, "TestEncodeCanonicalIntKeys")
+	for k, v := range m {
+		expect(got[int(k)], v, t, "TestEncodeCanonicalIntKeys")
+	}
+}
+
+type Celsius struct {
+	Degrees float32
+}
+
+func TestRegisterEncodeExtensionFn(t *testing.T) {
+	err := RegisterEncodeExtensionFn(reflect.TypeOf(Celsius{}), func(enc *Encoder, rv reflect.Value) error {
+		return enc.Encode(Tag{Number: 0xce, Content: rv.Interface().(Celsius).Degrees})
+	})
+	check(err)
+	t.Cleanup(func() { unregisterEncodeExtension(reflect.TypeOf(Celsius{})) })
+	_, err = LookupEncodeExtensionFn(reflect.TypeOf(Celsius{}))
+	expect(err, nil, t, "TestRegisterEncodeExtensionFn")
+	err = RegisterEncodeExtensionFn(reflect.TypeOf(Celsius{}), nil)
+	expect(err != nil, true, t, "TestRegisterEncodeExtensionFn")
+
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf).Encode(Celsius{21.5}))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "d8cefa41ac0000", t, "TestRegisterEncodeExtensionFn")
+
+	buf.Reset()
+	check(NewEncoder(buf, WithSelfDescribeEachItem()).Encode([]*Celsius{{-1}, nil}))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "d9d9f782d8cefabf800000f6", t, "TestRegisterEncodeExtensionFn")
+}
+
+// removes a registered encode extension so tests can run more than once
+func unregisterEncodeExtension(t reflect.Type) {
+	delete(extensionsEnc, reflect.ValueOf(t).Pointer())
+}
+
+type Fahrenheit float32
+
+func (f Fahrenheit) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%gF", float32(f))), nil
+}
+
+func TestEncodeExtensionBeforeTextMarshaler(t *testing.T) {
+	check(RegisterEncodeExtensionFn(reflect.TypeOf(Fahrenheit(0)), func(enc *Encoder, rv reflect.Value) error {
+		return enc.Encode(Tag{Number: 0xcf, Content: float32(rv.Interface().(Fahrenheit))})
+	}))
+	t.Cleanup(func() { unregisterEncodeExtension(reflect.TypeOf(Fahrenheit(0))) })
+
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf).Encode(Fahrenheit(21.5)))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "d8cffa41ac0000", t, "TestEncodeExtensionBeforeTextMarshaler")
+
+	buf.Reset()
+	check(NewEncoder(buf).Encode([]Fahrenheit{21.5}))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "81d8cffa41ac0000", t, "TestEncodeExtensionBeforeTextMarshaler")
+}
+
+type Kelvin struct {
+	Degrees uint16
+}
+
+func TestRegisterTagEncodeFn(t *testing.T) {
+	err := RegisterTagEncodeFn(reflect.TypeOf(Kelvin{}), 1000, func(enc *Encoder, rv reflect.Value) error {
+		return enc.Encode(rv.Interface().(Kelvin).Degrees)
+	})
+	check(err)
+	t.Cleanup(func() { unregisterEncodeExtension(reflect.TypeOf(Kelvin{})) })
+	err = RegisterTagEncodeFn(reflect.TypeOf(Kelvin{}), 1000, nil)
+	expect(err != nil, true, t, "TestRegisterTagEncodeFn")
+
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf).Encode(Kelvin{300}))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "d903e819012c", t, "TestRegisterTagEncodeFn")
+	var v interface{}
+	check(NewDecoder(buf).Decode(&v))
+	expect(v, interface{}(Tag{Number: 1000, Content: uint16(300)}), t, "TestRegisterTagEncodeFn")
+
+	// nested values and pointers get the tag too
+	buf.Reset()
+	check(NewEncoder(buf).Encode(map[string]*Kelvin{"k": {1}}))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "a1616bd903e801", t, "TestRegisterTagEncodeFn")
+}
+
+func TestEncodeNilBigInt(t *testing.T) {
+	type Balance struct {
+		Amount *big.Int `cbor:"n"`
+	}
+	cases := []struct {
+		v        interface{}
+		expected string
+	}{
+		{(*big.Int)(nil), "f6"},
+		{Balance{}, "a1616ef6"},
+		{&Balance{}, "a1616ef6"},
+		{[]*big.Int{nil, big.NewInt(1)}, "82f6c24101"},
+		{map[string]*big.Int{"a": nil}, "a16161f6"},
+	}
+	for _, c := range cases {
+		buf := bytes.NewBuffer(nil)
+		check(NewEncoder(buf).Encode(c.v))
+		expect(fmt.Sprintf("%x", buf.Bytes()), c.expected, t, "TestEncodeNilBigInt")
+	}
+}
+
+func TestEncodeSelfDescribeRoundTrip(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf, SelfDescribe).Encode(uint16(1000)))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "d9d9f71903e8", t, "TestEncodeSelfDescribeRoundTrip")
+
+	var n uint16
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&n))
+	expect(n, uint16(1000), t, "TestEncodeSelfDescribeRoundTrip")
+
+	var v interface{}
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&v))
+	expect(v, interface{}(uint16(1000)), t, "TestEncodeSelfDescribeRoundTrip")
+}
+
+type JSONProfile struct {
+	Name     string            `json:"name"`
+	Nick     string            `json:"nick,omitempty"`
+	Tags     []string          `json:"tags,omitempty"`
+	Meta     map[string]string `json:",omitempty"`
+	Score    float64           `json:"score,omitempty"`
+	Password string            `json:"-"`
+	Admin    bool              `json:"admin,omitempty" cbor:"is_admin"`
+}
+
+func TestEncodeJSONTagFallback(t *testing.T) {
+	p := JSONProfile{Name: "gopher", Password: "s3cr3t"}
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf, EncodeJSONTagFallback()).Encode(p))
+	var m map[string]interface{}
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&m))
+	// empty fields are omitted and cbor tags take precedence (without omitempty)
+	expect(len(m), 2, t, "TestEncodeJSONTagFallback")
+	expect(m["name"], interface{}("gopher"), t, "TestEncodeJSONTagFallback")
+	expect(m["is_admin"], interface{}(false), t, "TestEncodeJSONTagFallback")
+
+	p = JSONProfile{Name: "gopher", Nick: "g", Tags: []string{"go"}, Meta: map[string]string{"a": "b"}, Score: 1.5}
+	buf.Reset()
+	check(NewEncoder(buf, EncodeJSONTagFallback()).Encode(p))
+	m = nil
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&m))
+	expect(len(m), 6, t, "TestEncodeJSONTagFallback")
+	expect(m["nick"], interface{}("g"), t, "TestEncodeJSONTagFallback")
+	expect(m["score"], interface{}(1.5), t, "TestEncodeJSONTagFallback")
+	_, ok := m["Meta"]
+	expect(ok, true, t, "TestEncodeJSONTagFallback")
+
+	// without the option json tags are ignored
+	buf.Reset()
+	check(NewEncoder(buf).Encode(p))
+	m = nil
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&m))
+	expect(len(m), 7, t, "TestEncodeJSONTagFallback")
+	expect(m["Password"], interface{}(""), t, "TestEncodeJSONTagFallback")
+
+	// omitempty in cbor tags is honored with or without the option
+	type Account struct {
+		ID    int    `cbor:"id"`
+		Email string `cbor:"email,omitempty" json:"email"`
+	}
+	for _, opts := range [][]func(*Encoder){nil, {EncodeJSONTagFallback()}} {
+		buf.Reset()
+		check(NewEncoder(buf, opts...).Encode(Account{ID: 1}))
+		expect(fmt.Sprintf("%x", buf.Bytes()), "a162696401", t, "TestEncodeJSONTagFallback cbor omitempty")
+	}
+}
+
+func TestEncodeStructKeyAsInt(t *testing.T) {
+	type CoseKey struct {
+		Kty   uint8  `cbor:"1,keyasint"`
+		KeyOp uint8  `cbor:"4,keyasint"`
+		Crv   uint8  `cbor:"-1,keyasint"`
+		X     []byte `cbor:"-2,keyasint"`
+		Extra uint16 `cbor:"1000,keyasint"`
+	}
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf).Encode(CoseKey{Kty: 2, KeyOp: 1, Crv: 1, X: []byte{0xab}, Extra: 7}))
+	// a5: map of 5 pairs, keys 01, 04, 20 (-1), 21 (-2) and 1903e8 (1000)
+	expect(fmt.Sprintf("%x", buf.Bytes()), "a5"+"0102"+"0401"+"2001"+"2141ab"+"1903e807", t, "TestEncodeStructKeyAsInt")
+
+	type BadKey struct {
+		A int `cbor:"a,keyasint"`
+	}
+	expect(NewEncoder(buf).Encode(BadKey{}) != nil, true, t, "TestEncodeStructKeyAsInt")
+}
+
+func TestEncodeExpectedBaseBytes(t *testing.T) {
+	cases := []struct {
+		v        interface{}
+		expected string
+		decoded  string
+	}{
+		{Base64URLBytes{0xfb, 0xff}, "d542fbff", "-_8="},
+		{Base64Bytes{0xfb, 0xff}, "d642fbff", "+/8="},
+		{Base16Bytes{0x01, 0xab}, "d74201ab", "01ab"},
+		{Base64Bytes(nil), "f6", ""},
+	}
+	for _, c := range cases {
+		buf := bytes.NewBuffer(nil)
+		check(NewEncoder(buf).Encode(c.v))
+		expect(fmt.Sprintf("%x", buf.Bytes()), c.expected, t, "TestEncodeExpectedBaseBytes")
+		if c.decoded == "" {
+			continue
+		}
+		// blindly decoded they are converted to its base encoding
+		var v interface{}
+		check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&v))
+		expect(string(v.([]byte)), c.decoded, t, "TestEncodeExpectedBaseBytes")
+	}
+
+	type Payload struct {
+		Sig Base64URLBytes `cbor:"sig"`
+		Key Base16Bytes    `cbor:"key"`
+	}
+	p := Payload{Sig: Base64URLBytes{1, 2, 3}, Key: Base16Bytes{4, 5}}
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf).Encode(p))
+	var got Payload
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&got))
+	expect(bytes.Equal(got.Sig, p.Sig), true, t, "TestEncodeExpectedBaseBytes")
+	expect(bytes.Equal(got.Key, p.Key), true, t, "TestEncodeExpectedBaseBytes")
+
+	var b Base64Bytes
+	check(NewDecoder(bytes.NewReader([]byte{0xd6, 0x42, 0xfb, 0xff})).Decode(&b))
+	expect(bytes.Equal(b, []byte{0xfb, 0xff}), true, t, "TestEncodeExpectedBaseBytes")
+	err := NewDecoder(bytes.NewReader([]byte{0xd7, 0x42, 0xfb, 0xff})).Decode(&b)
+	expect(err != nil, true, t, "TestEncodeExpectedBaseBytes")
+}
+
+func TestEncodeDecodeNetip(t *testing.T) {
+	addrs := []netip.Addr{
+		netip.MustParseAddr("192.168.1.10"),
+		netip.MustParseAddr("2001:db8::1"),
+	}
+	for _, addr := range addrs {
+		buf := bytes.NewBuffer(nil)
+		check(NewEncoder(buf).Encode(addr))
+		expect(buf.Bytes()[0]>>5, byte(cborTag), t, "TestEncodeDecodeNetip "+addr.String())
+		var out netip.Addr
+		check(NewDecoder(buf).Decode(&out))
+		expect(out, addr, t, "TestEncodeDecodeNetip "+addr.String())
+	}
+
+	prefix := netip.MustParsePrefix("10.1.2.0/24")
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf).Encode(&prefix))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "d834821818430a0102", t, "TestEncodeDecodeNetip prefix")
+	var outPrefix netip.Prefix
+	check(NewDecoder(buf).Decode(&outPrefix))
+	expect(outPrefix, prefix, t, "TestEncodeDecodeNetip prefix")
+
+	// untagged prefixes with the full address are still accepted
+	buf.Reset()
+	check(NewEncoder(buf).Encode([]interface{}{24, []byte{10, 1, 2, 0}}))
+	check(NewDecoder(buf).Decode(&outPrefix))
+	expect(outPrefix, prefix, t, "TestEncodeDecodeNetip untagged prefix")
+
+	// text strings are still accepted through encoding.TextUnmarshaler
+	buf.Reset()
+	check(NewEncoder(buf).Encode("fe80::1%eth0"))
+	var zoned netip.Addr
+	check(NewDecoder(buf).Decode(&zoned))
+	expect(zoned, netip.MustParseAddr("fe80::1%eth0"), t, "TestEncodeDecodeNetip text")
+
+	buf.Reset()
+	check(NewEncoder(buf).Encode([]interface{}{33, []byte{10, 1, 2, 0}}))
+	err := NewDecoder(buf).Decode(&outPrefix)
+	expect(err != nil, true, t, "TestEncodeDecodeNetip invalid prefix")
+}
+
+func TestEncodeSelfDescribeOnce(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf, func(e *Encoder) { e.selfDescribe = true })
+	for _, v := range []interface{}{"a", []int{1, 2}, map[string]int{"b": 3}} {
+		check(e.Encode(v))
+	}
+	magic := []byte{0xd9, 0xd9, 0xf7}
+	expect(bytes.Count(buf.Bytes(), magic), 1, t, "TestEncodeSelfDescribeOnce")
+	expect(bytes.HasPrefix(buf.Bytes(), magic), true, t, "TestEncodeSelfDescribeOnce")
+}
+
+type LinkedNode struct {
+	Value int
+	Next  *LinkedNode
+}
+
+func TestEncodeCyclicReferences(t *testing.T) {
+	a := &LinkedNode{Value: 1}
+	a.Next = &LinkedNode{Value: 2, Next: a}
+	err := NewEncoder(bytes.NewBuffer(nil)).Encode(a)
+	_, ok := err.(*CyclicReferenceError)
+	expect(ok, true, t, "TestEncodeCyclicReferences")
+	expect(fmt.Sprint(err), "cbor: encountered a cycle via *cbor.LinkedNode", t, "TestEncodeCyclicReferences")
+
+	m := map[string]interface{}{}
+	m["self"] = m
+	err = NewEncoder(bytes.NewBuffer(nil)).Encode(m)
+	_, ok = err.(*CyclicReferenceError)
+	expect(ok, true, t, "TestEncodeCyclicReferences map")
+
+	s := []interface{}{nil}
+	s[0] = s
+	err = NewEncoder(bytes.NewBuffer(nil)).Encode(s)
+	_, ok = err.(*CyclicReferenceError)
+	expect(ok, true, t, "TestEncodeCyclicReferences slice")
+
+	// the same pointer can appear several times when there is no cycle
+	shared := &LinkedNode{Value: 3}
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf)
+	check(e.Encode([]*LinkedNode{shared, shared}))
+	check(e.Encode(shared))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "82a26556616c756503644e657874f6a26556616c756503644e657874f6a26556616c756503644e657874f6", t, "TestEncodeCyclicReferences")
+}
+
+type AliasedInner struct {
+	N int
+}
+
+type AliasedOuter struct {
+	B AliasedInner
+	Q *AliasedInner
+}
+
+func TestEncodeAliasedFieldIsNotACycle(t *testing.T) {
+	// a pointer to the first field has the same address as the struct
+	a := &AliasedOuter{B: AliasedInner{N: 1}}
+	a.Q = &a.B
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf).Encode(a))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "a26142a1614e016151a1614e01", t, "TestEncodeAliasedFieldIsNotACycle")
+
+	// a slice and a slice of the array of its first element
+	type Row struct {
+		Cells [1]int
+		View  []int
+	}
+	rows := []Row{{Cells: [1]int{7}}}
+	rows[0].View = rows[0].Cells[:]
+	buf.Reset()
+	check(NewEncoder(buf).Encode(rows))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "81a26543656c6c73810764566965778107", t, "TestEncodeAliasedFieldIsNotACycle")
+}
+
+type ArrayPoint struct {
+	X, Y int
+}
+
+func TestEncodeDecodeArrayOfStructs(t *testing.T) {
+	points := [2]ArrayPoint{{1, 2}, {3, -4}}
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf).Encode(points))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "82a2615801615902a2615803615923", t, "TestEncodeDecodeArrayOfStructs")
+	var a [2]ArrayPoint
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&a))
+	expect(a, points, t, "TestEncodeDecodeArrayOfStructs")
+
+	// bigger arrays keep the remaining elements untouched
+	b := [3]ArrayPoint{2: {5, 6}}
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&b))
+	expect(b, [3]ArrayPoint{{1, 2}, {3, -4}, {5, 6}}, t, "TestEncodeDecodeArrayOfStructs")
+
+	// indefinite length arrays
+	buf.Reset()
+	check(NewEncoder(buf).EncodeArrayStream(func(enc *Encoder) error {
+		check(enc.Encode(points[0]))
+		return enc.Encode(&points[1])
+	}))
+	a = [2]ArrayPoint{}
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&a))
+	expect(a, points, t, "TestEncodeDecodeArrayOfStructs")
+
+	var c [1]ArrayPoint
+	err := NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&c)
+	expect(err.Error(), "at byte 8: can't decode more than 1 elements into [1]cbor.ArrayPoint", t, "TestEncodeDecodeArrayOfStructs")
+	buf.Reset()
+	check(NewEncoder(buf).Encode(points))
+	err = NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&c)
+	expect(err.Error(), "at byte 0: can't decode an array of 2 elements into [1]cbor.ArrayPoint", t, "TestEncodeDecodeArrayOfStructs")
+}
+
+func TestEncodeDecodeIPAndDuration(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf)
+	ips := []net.IP{net.ParseIP("10.0.0.1").To4(), net.ParseIP("2001:db8::1")}
+	for _, ip := range ips {
+		buf.Reset()
+		check(e.Encode(ip))
+		expect(buf.Bytes()[2], byte(0x40|len(ip)), t, "TestEncodeDecodeIPAndDuration")
+		var out net.IP
+		check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&out))
+		expect(out.Equal(ip), true, t, "TestEncodeDecodeIPAndDuration")
+	}
+	buf.Reset()
+	check(e.Encode([]byte{1, 2, 3}))
+	var ip net.IP
+	err := NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&ip)
+	expect(err.Error(), "at byte 0: can't decode an IP address of 3 bytes", t, "TestEncodeDecodeIPAndDuration")
+
+	for _, d := range []time.Duration{90 * time.Second, -time.Millisecond, 0, time.Duration(math.MaxInt64)} {
+		buf.Reset()
+		check(e.Encode(d))
+		var out time.Duration
+		check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&out))
+		expect(out, d, t, "TestEncodeDecodeIPAndDuration")
+	}
+	buf.Reset()
+	check(e.Encode(-time.Millisecond))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "3a000f423f", t, "TestEncodeDecodeIPAndDuration")
+
+	// both types inside structs, blind decoding keeps the tag
+	type Probe struct {
+		Host    net.IP
+		Timeout time.Duration
+	}
+	buf.Reset()
+	check(e.Encode(Probe{Host: ips[0], Timeout: 3 * time.Second}))
+	var p Probe
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&p))
+	expect(p.Host.String(), "10.0.0.1", t, "TestEncodeDecodeIPAndDuration")
+	expect(p.Timeout, 3*time.Second, t, "TestEncodeDecodeIPAndDuration")
+	var m map[string]interface{}
+	check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&m))
+	expect(fmt.Sprintf("%T %x", m["Host"], m["Host"]), "cbor.Tag {34 0a000001}", t, "TestEncodeDecodeIPAndDuration")
+	expect(m["Timeout"], interface{}(uint32(3e9)), t, "TestEncodeDecodeIPAndDuration")
+}
+
+func TestEncodeDecodeTaggedIPs(t *testing.T) {
+	cases := []struct {
+		addr, out string
+	}{
+		{"192.168.0.1", "d83444c0a80001"},
+		{"::ffff:192.168.0.1", "d83444c0a80001"},
+		{"2001:db8::1", "d8365020010db8000000000000000000000001"},
+	}
+	for _, c := range cases {
+		ip := net.ParseIP(c.addr)
+		buf := bytes.NewBuffer(nil)
+		check(NewEncoder(buf).Encode(ip))
+		expect(fmt.Sprintf("%x", buf.Bytes()), c.out, t, "TestEncodeDecodeTaggedIPs "+c.addr)
+		var outIP net.IP
+		check(NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&outIP))
+		expect(outIP.Equal(ip), true, t, "TestEncodeDecodeTaggedIPs "+c.addr)
+
+		addr := netip.MustParseAddr(c.addr).Unmap()
+		buf.Reset()
+		check(NewEncoder(buf).Encode(addr))
+		expect(fmt.Sprintf("%x", buf.Bytes()), c.out, t, "TestEncodeDecodeTaggedIPs "+c.addr)
+		var outAddr netip.Addr
+		check(NewDecoder(buf).Decode(&outAddr))
+		expect(outAddr, addr, t, "TestEncodeDecodeTaggedIPs "+c.addr)
+	}
+
+	prefix := netip.MustParsePrefix("2001:db8:1234::/48")
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf).Encode(prefix))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "d8368218304620010db81234", t, "TestEncodeDecodeTaggedIPs prefix")
+	var outPrefix netip.Prefix
+	check(NewDecoder(buf).Decode(&outPrefix))
+	expect(outPrefix, prefix, t, "TestEncodeDecodeTaggedIPs prefix")
+
+	// nil and zero values are encoded as null
+	for _, v := range []interface{}{net.IP(nil), netip.Addr{}, netip.Prefix{}} {
+		buf.Reset()
+		check(NewEncoder(buf).Encode(v))
+		expect(fmt.Sprintf("%x", buf.Bytes()), "f6", t, "TestEncodeDecodeTaggedIPs null")
+	}
+
+	// the size of the address must match its tag
+	var ip net.IP
+	err := NewDecoder(bytes.NewReader([]byte{0xd8, 0x36, 0x44, 10, 0, 0, 1})).Decode(&ip)
+	expect(err != nil, true, t, "TestEncodeDecodeTaggedIPs mismatch")
+	var addr netip.Addr
+	err = NewDecoder(bytes.NewReader([]byte{0xd8, 0x36, 0x44, 10, 0, 0, 1})).Decode(&addr)
+	expect(err != nil, true, t, "TestEncodeDecodeTaggedIPs mismatch")
+
+	// an IPv4 prefix can't carry an IPv6 address
+	buf.Reset()
+	check(NewEncoder(buf).Encode(Tag{Number: cborIPv4, Content: []interface{}{24, net.ParseIP("2001:db8::").To16()}}))
+	err = NewDecoder(buf).Decode(&outPrefix)
+	expect(err != nil, true, t, "TestEncodeDecodeTaggedIPs prefix mismatch")
+
+	// zoned addresses are written as text strings to keep the zone
+	zoned := netip.MustParseAddr("fe80::1%eth0")
+	buf.Reset()
+	check(NewEncoder(buf).Encode(zoned))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "6c666538303a3a312565746830", t, "TestEncodeDecodeTaggedIPs zoned")
+	check(NewDecoder(buf).Decode(&addr))
+	expect(addr, zoned, t, "TestEncodeDecodeTaggedIPs zoned")
+}
+
+type FileEntry struct {
+	Name string
+	Size int
+	Sys  interface{}
+}
+
+func TestEncodeStructWithInterfaceField(t *testing.T) {
+	cases := []struct {
+		in  FileEntry
+		out string
+	}{
+		{FileEntry{Name: "a", Size: 1}, "a3644e616d6561616453697a650163537973f6"},
+		{FileEntry{Name: "b", Size: 2, Sys: map[string]interface{}{"uid": 0}}, "a3644e616d6561626453697a650263537973a163756964" + "00"},
+	}
+	for _, c := range cases {
+		buf := bytes.NewBuffer(nil)
+		check(NewEncoder(buf).Encode(c.in))
+		expect(fmt.Sprintf("%x", buf.Bytes()), c.out, t, "TestEncodeStructWithInterfaceField "+c.in.Name)
+
+		var out FileEntry
+		check(NewDecoder(buf).Decode(&out))
+		expect(out.Name, c.in.Name, t, "TestEncodeStructWithInterfaceField "+c.in.Name)
+		expect(out.Size, c.in.Size, t, "TestEncodeStructWithInterfaceField "+c.in.Name)
+		expect(out.Sys == nil, c.in.Sys == nil, t, "TestEncodeStructWithInterfaceField "+c.in.Name)
+
+		// the decoded value is encoded back into the same bytes
+		buf.Reset()
+		check(NewEncoder(buf).Encode(out))
+		expect(fmt.Sprintf("%x", buf.Bytes()), c.out, t, "TestEncodeStructWithInterfaceField "+c.in.Name)
+	}
+}
+
+func TestEncoderReset(t *testing.T) {
+	e := NewEncoder(nil, func(e *Encoder) { e.selfDescribe = true })
+	for _, v := range []interface{}{1, "a"} {
+		buf := bytes.NewBuffer(nil)
+		e.Reset(buf)
+		check(e.Encode(v))
+		// every new output gets its own self-describe tag
+		expect(bytes.HasPrefix(buf.Bytes(), []byte{0xd9, 0xd9, 0xf7}), true, t, "TestEncoderReset")
+	}
+}
+
+func TestEncodeInvalidUTF8MapKeyStrictMode(t *testing.T) {
+	strict := func(e *Encoder) { e.strict = true }
+	m := map[string]int{"ok": 1, "\xff\xfe": 2}
+	for _, options := range [][]func(*Encoder){{strict}, {strict, WithCanonical()}} {
+		err := NewEncoder(bytes.NewBuffer(nil), options...).Encode(m)
+		_, ok := err.(*StrictModeError)
+		expect(ok, true, t, "TestEncodeInvalidUTF8MapKeyStrictMode")
+		expect(fmt.Sprint(err), `strict-mode: map key "\xff\xfe" is not valid UTF-8`, t, "TestEncodeInvalidUTF8MapKeyStrictMode")
+	}
+
+	// valid keys are encoded in strict mode and any key without it
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf, strict).Encode(map[string]int{"españa": 1}))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "a16765737061c3b16101", t, "TestEncodeInvalidUTF8MapKeyStrictMode")
+	check(NewEncoder(bytes.NewBuffer(nil)).Encode(m))
+}
+
+func TestEncodeDecodeSetsAsArrays(t *testing.T) {
+	set := map[string]struct{}{"a": {}, "bb": {}, "c": {}}
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf, SetsAsArrays(false), WithCanonical()).Encode(set))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "8361616163626262", t, "TestEncodeDecodeSetsAsArrays")
+	var out map[string]struct{}
+	check(NewDecoder(buf).Decode(&out))
+	expect(len(out), 3, t, "TestEncodeDecodeSetsAsArrays")
+	for key := range set {
+		_, ok := out[key]
+		expect(ok, true, t, "TestEncodeDecodeSetsAsArrays "+key)
+	}
+
+	// tagged sets inside structs
+	type Roles struct {
+		Names map[string]struct{}
+	}
+	buf.Reset()
+	check(NewEncoder(buf, SetsAsArrays(true), WithCanonical()).Encode(Roles{Names: set}))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "a1654e616d6573d901028361616163626262", t, "TestEncodeDecodeSetsAsArrays tagged")
+	var roles Roles
+	check(NewDecoder(buf).Decode(&roles))
+	expect(len(roles.Names), 3, t, "TestEncodeDecodeSetsAsArrays tagged")
+
+	// sets are still encoded as maps by default and decoded from them
+	buf.Reset()
+	check(NewEncoder(buf).Encode(map[string]struct{}{"a": {}}))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "a16161a0", t, "TestEncodeDecodeSetsAsArrays map")
+	out = nil
+	check(NewDecoder(buf).Decode(&out))
+	expect(len(out), 1, t, "TestEncodeDecodeSetsAsArrays map")
+
+	// indefinite arrays and duplicated elements in strict mode
+	out = nil
+	check(NewDecoder(bytes.NewReader([]byte{0x9f, 0x61, 0x61, 0x61, 0x61, 0xff})).Decode(&out))
+	expect(len(out), 1, t, "TestEncodeDecodeSetsAsArrays indefinite")
+	err := NewDecoder(bytes.NewReader([]byte{0x82, 0x61, 0x61, 0x61, 0x61}), func(dec *Decoder) { dec.strict = true }).Decode(&out)
+	_, ok := err.(*DecodeError).Err.(*StrictModeError)
+	expect(ok, true, t, "TestEncodeDecodeSetsAsArrays strict")
+}
+
+func TestEncodeStructsAsStreams(t *testing.T) {
+	type Inner struct {
+		ID  int `cbor:"1,keyasint"`
+		Tag string
+	}
+	type Outer struct {
+		Name  string
+		Inner Inner
+		Skip  string `cbor:",omitempty"`
+	}
+	in := Outer{Name: "a", Inner: Inner{ID: 1, Tag: "b"}}
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf, StructsAsStreams()).Encode(in))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "bf644e616d65616165496e6e6572bf0101635461676162ffff", t, "TestEncodeStructsAsStreams")
+	var out Outer
+	check(NewDecoder(buf).Decode(&out))
+	expect(out, in, t, "TestEncodeStructsAsStreams")
+
+	// canonical encoding keeps definite length maps
+	buf.Reset()
+	check(NewEncoder(buf, StructsAsStreams(), WithCanonical()).Encode(in.Inner))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "a20101635461676162", t, "TestEncodeStructsAsStreams canonical")
+}
+
+func TestEncodeWrapInTag(t *testing.T) {
+	type Document struct {
+		Title string
+		Pages uint8
+	}
+	in := Document{Title: "a", Pages: 3}
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf, WrapInTag(1234))
+	check(e.Encode(in))
+	check(e.Encode(in))
+	doc := "d904d2a2655469746c65616165506167657303"
+	expect(fmt.Sprintf("%x", buf.Bytes()), doc+doc, t, "TestEncodeWrapInTag")
+
+	d := NewDecoder(buf, WithTagUnwrap())
+	for i := 0; i < 2; i++ {
+		var out Document
+		check(d.Decode(&out))
+		expect(out, in, t, "TestEncodeWrapInTag")
+	}
+
+	// the wrapping tag goes after the self-describe one
+	buf.Reset()
+	check(NewEncoder(buf, WithSelfDescribe(), WrapInTag(1234)).Encode(1))
+	expect(fmt.Sprintf("%x", buf.Bytes()), "d9d9f7d904d201", t, "TestEncodeWrapInTag")
+	var v interface{}
+	check(NewDecoder(buf).Decode(&v))
+	expect(v, interface{}(Tag{Number: 1234, Content: uint8(1)}), t, "TestEncodeWrapInTag")
+}
+
+func TestEncodeGoArrays(t *testing.T) {
+	type Point struct {
+		X int
+	}
+	type Digest [4]byte
+	cases := []struct {
+		in  interface{}
+		out string
+	}{
+		{[4]int32{1, 2, 3, 4}, "8401020304"},
+		{[0]bool{}, "80"},
+		{[2]Point{{1}, {2}}, "82a1615801a1615802"},
+		{[3]byte{1, 2, 3}, "43010203"},
+		{&[3]byte{1, 2, 3}, "43010203"},
+		{[0]byte{}, "40"},
+		{Digest{0xde, 0xad, 0xbe, 0xef}, "44deadbeef"},
+		{struct{ A [2]byte }{[2]byte{5, 6}}, "a16141420506"},
+		{[][2]byte{{1, 2}}, "81420102"},
+	}
+	for _, c := range cases {
+		buf := bytes.NewBuffer(nil)
+		check(NewEncoder(buf).Encode(c.in))
+		expect(fmt.Sprintf("%x", buf.Bytes()), c.out, t, fmt.Sprintf("TestEncodeGoArrays %T", c.in))
+	}
+
+	var digest Digest
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf).Encode(Digest{1, 2, 3, 4}))
+	check(NewDecoder(buf).Decode(&digest))
+	expect(digest, Digest{1, 2, 3, 4}, t, "TestEncodeGoArrays")
+
+	type Entry struct {
+		Sum Digest
+	}
+	buf.Reset()
+	check(NewEncoder(buf).Encode(Entry{Sum: Digest{5, 6, 7, 8}}))
+	var entry Entry
+	check(NewDecoder(buf).Decode(&entry))
+	expect(entry.Sum, Digest{5, 6, 7, 8}, t, "TestEncodeGoArrays")
+
+	// byte strings are neither truncated nor zero padded
+	for _, in := range [][]byte{{0x43, 1, 2, 3}, {0x45, 1, 2, 3, 4, 5}} {
+		err := NewDecoder(bytes.NewReader(in)).Decode(&digest)
+		expect(err != nil, true, t, fmt.Sprintf("TestEncodeGoArrays %x", in))
+	}
+}
+
+func TestEncodeForcedStringMajors(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf)
+	check(e.EncodeByteString([]byte("abc")))
+	expect(buf.Bytes()[0]>>5, byte(cborByteString), t, "TestEncodeForcedStringMajors")
+	expect(fmt.Sprintf("%x", buf.Bytes()), "43616263", t, "TestEncodeForcedStringMajors")
+
+	buf.Reset()
+	check(e.EncodeTextString(string([]byte{0x61, 0x62, 0x63})))
+	expect(buf.Bytes()[0]>>5, byte(cborTextString), t, "TestEncodeForcedStringMajors")
+	expect(fmt.Sprintf("%x", buf.Bytes()), "63616263", t, "TestEncodeForcedStringMajors")
+
+	// a long byte string uses the one byte length argument
+	buf.Reset()
+	check(e.EncodeByteString(make([]byte, 24)))
+	expect(buf.Bytes()[0], byte(0x58), t, "TestEncodeForcedStringMajors")
+	expect(buf.Len(), 26, t, "TestEncodeForcedStringMajors")
+
+	// text strings must be valid UTF-8 in strict mode
+	strict := NewEncoder(bytes.NewBuffer(nil), func(e *Encoder) { e.strict = true })
+	_, ok := strict.EncodeTextString("\xff").(*StrictModeError)
+	expect(ok, true, t, "TestEncodeForcedStringMajors")
+	check(strict.EncodeByteString([]byte{0xff}))
+}
+
 // benchmarks
 func BenchmarkEncodeBool(b *testing.B) {
 	buf := bytes.NewBuffer(nil)
@@ -18,6 +18,7 @@ package cbor
 import (
 	"fmt"
 	"reflect"
+	"strings"
 )
 
 // An InvalidDecoderError describes an invalid argument passed to Decode
@@ -63,3 +64,44 @@ func NewCanonicalModeError(msg string) *CanonicalModeError {
 func (e *CanonicalModeError) Error() string {
 	return e.Msg
 }
+
+// A CyclicReferenceError is returned by Encode when the value
+// to encode references itself through pointers, maps or slices
+type CyclicReferenceError struct {
+	Type reflect.Type
+}
+
+func (e *CyclicReferenceError) Error() string {
+	return fmt.Sprintf("cbor: encountered a cycle via %s", e.Type)
+}
+
+// A DecodeError describes an error found while decoding
+// the data item that starts at the given byte Offset.
+//
+// Decode wraps every error but io.EOF into a DecodeError, callers that
+// look for a concrete error (e.g. *StrictModeError or ParserErr) must
+// use errors.As or errors.Unwrap instead of a type assertion
+type DecodeError struct {
+	Offset int64
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("at byte %d: %s", e.Offset, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeErrors collects the errors found by a decoder
+// configured to continue decoding on errors
+type DecodeErrors []error
+
+func (e DecodeErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d decode errors: %s", len(e), strings.Join(msgs, "; "))
+}
@@ -0,0 +1,169 @@
+// A Golang RFC7049 implementation
+// Copyright (C) 2015 Oscar Campos
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Value is a generic CBOR data item that mirrors the CBOR data model, it
+// keeps the additional information of the header so a Value returned by
+// DecodeGeneric is encoded back into exactly the same bytes
+type Value struct {
+	Major Major
+	// Info is the additional information of the header, it defines the
+	// width of Arg (24 to 27), the kind of simple value or float (major 7)
+	// and indefinite length items (31), Arg is the Info itself when < 24
+	Info byte
+	// Arg is the integer value (-1-Arg for negative ones), the length of
+	// strings, arrays and maps, the tag number, the simple value or the
+	// bits of a float depending on the Major
+	Arg uint64
+	// Bytes are the contents of definite length byte and text strings
+	Bytes []byte
+	// Items are the elements of arrays, the keys and values of maps one
+	// after the other, the chunks of indefinite length strings or the
+	// content of a tag (as its only item)
+	Items []Value
+}
+
+// IsIndefinite returns true if the value is an indefinite length item
+func (v Value) IsIndefinite() bool {
+	return v.Info == cborIndefinite
+}
+
+// Float returns the value of a half, single or double precision float
+func (v Value) Float() (float64, error) {
+	if v.Major == cborNC {
+		switch v.Info {
+		case absoluteFloat16 & 0x1f:
+			return float64(math.Float32frombits(float16toUint32(uint16(v.Arg)))), nil
+		case absoluteFloat32 & 0x1f:
+			return float64(math.Float32frombits(uint32(v.Arg))), nil
+		case absoluteFloat64 & 0x1f:
+			return math.Float64frombits(v.Arg), nil
+		}
+	}
+	return 0, fmt.Errorf("%s with additional info %d is not a float", v.Major, v.Info)
+}
+
+// DecodeGeneric decodes the next data item from the input into a Value
+// tree, tags and indefinite length items are preserved as they are
+func (dec *Decoder) DecodeGeneric() (Value, error) {
+	dec.parser.startItem()
+	tz := newTokenizer(dec.parser, dec.maxDepth)
+	t, err := tz.next()
+	if err != nil {
+		return Value{}, dec.positionError(err)
+	}
+	v, err := decodeGenericToken(tz, t)
+	return v, dec.positionError(err)
+}
+
+// returns back the data item started by t as a Value
+func decodeGenericToken(tz *tokenizer, t token) (Value, error) {
+	v := t.Value
+	if !t.opens() {
+		return v, nil
+	}
+	err := tz.each(func(_ int, t token) error {
+		item, err := decodeGenericToken(tz, t)
+		v.Items = append(v.Items, item)
+		return err
+	})
+	return v, err
+}
+
+// Encode a generic value writing its header as it is defined
+// by the value, it fails if the value is not consistent
+func (enc *Encoder) encodeGeneric(v Value) {
+	if err := v.check(); err != nil {
+		panic(err)
+	}
+	if err := enc.composer.composeInformation(v.Major, v.Info); err != nil {
+		panic(err)
+	}
+	if v.Info >= cborUint8 && v.Info <= cborUint64 {
+		arg := make([]byte, 8)
+		binary.BigEndian.PutUint64(arg, v.Arg)
+		if _, err := enc.composer.write(arg[8-(1<<(v.Info-cborUint8)):]); err != nil {
+			panic(err)
+		}
+	}
+	if _, err := enc.composer.write(v.Bytes); err != nil {
+		panic(err)
+	}
+	for _, item := range v.Items {
+		enc.encodeGeneric(item)
+	}
+	if v.IsIndefinite() {
+		if err := enc.composer.composeBreak(); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// checks that the header, argument and contents of the value agree
+func (v Value) check() error {
+	switch {
+	case v.Major > cborNC:
+		return fmt.Errorf("invalid major %d", v.Major)
+	case v.Info >= 28 && v.Info <= 30 || v.Info > cborIndefinite:
+		return fmt.Errorf("invalid additional info %d", v.Info)
+	case v.Info <= cborSmallInt && v.Arg != uint64(v.Info):
+		return fmt.Errorf("argument %d doesn't match additional info %d", v.Arg, v.Info)
+	case v.Info >= cborUint8 && v.Info < cborUint64 && v.Arg >= 1<<(8<<(v.Info-cborUint8)):
+		return fmt.Errorf("argument %d doesn't fit into additional info %d", v.Arg, v.Info)
+	}
+	want := 0
+	switch v.Major {
+	case cborByteString, cborTextString:
+		if !v.IsIndefinite() && uint64(len(v.Bytes)) != v.Arg {
+			return fmt.Errorf("%s of length %d has %d bytes", v.Major, v.Arg, len(v.Bytes))
+		}
+		for _, chunk := range v.Items {
+			if chunk.Major != v.Major || chunk.IsIndefinite() {
+				return fmt.Errorf("invalid chunk of major %d inside indefinite string of major %d", chunk.Major, v.Major)
+			}
+		}
+	case cborDataArray:
+		want = int(v.Arg)
+	case cborDataMap:
+		want = int(v.Arg) * 2
+	case cborTag:
+		want = 1
+	}
+	if v.IsIndefinite() {
+		switch {
+		case v.Major < cborByteString || v.Major == cborTag:
+			return fmt.Errorf("%s can't be an indefinite length item", v.Major)
+		case v.Major == cborNC:
+			return NewParseErr("unexpected break stop code outside indefinite item")
+		case v.Major == cborDataMap && len(v.Items)%2 != 0:
+			return fmt.Errorf("indefinite length map with %d items", len(v.Items))
+		}
+		want = len(v.Items)
+	}
+	if len(v.Items) != want {
+		return fmt.Errorf("%s expects %d items but it has %d", v.Major, want, len(v.Items))
+	}
+	if len(v.Bytes) > 0 && (v.Major != cborByteString && v.Major != cborTextString || v.IsIndefinite()) {
+		return fmt.Errorf("%s can't have bytes", v.Major)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+// A Golang RFC7049 implementation
+// Copyright (C) 2015 Oscar Campos
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestDecodeGenericRoundTrip(t *testing.T) {
+	cases := []string{
+		"1800",               // integer with a non preferred width
+		"3a000f423f",         // negative integer
+		"5f4101ff",           // indefinite byte string
+		"f97e00",             // half precision NaN
+		"d9d9f7d8208101",     // nested tags
+		"bf01a0029f80ffff",   // indefinite map with nested containers
+		"9a0000000100",       // array with a non preferred length width
+		"7800",               // empty text string with a non preferred width
+		"d8189f5f40ffff6100", // trailing bytes are left for the next item
+	}
+	for _, c := range rfc7049Diagnostics {
+		cases = append(cases, c.in)
+	}
+	for _, c := range cases {
+		in, _ := hex.DecodeString(c)
+		d := NewDecoder(bytes.NewReader(in))
+		v, err := d.DecodeGeneric()
+		check(err)
+		buf := bytes.NewBuffer(nil)
+		check(NewEncoder(buf).Encode(v))
+		expect(hex.EncodeToString(buf.Bytes()), c[:2*d.InputOffset()], t, "TestDecodeGenericRoundTrip "+c)
+	}
+}
+
+func TestDecodeGenericValues(t *testing.T) {
+	in, _ := hex.DecodeString("c1a26161fa3fc00000206441424344")
+	v, err := NewDecoder(bytes.NewReader(in)).DecodeGeneric()
+	check(err)
+	expect(v.Major, cborTag, t, "TestDecodeGenericValues")
+	expect(v.Arg, uint64(1), t, "TestDecodeGenericValues")
+	m := v.Items[0]
+	expect(m.Major, cborDataMap, t, "TestDecodeGenericValues")
+	expect(m.Arg, uint64(2), t, "TestDecodeGenericValues")
+	expect(len(m.Items), 4, t, "TestDecodeGenericValues")
+	expect(string(m.Items[0].Bytes), "a", t, "TestDecodeGenericValues")
+	f, err := m.Items[1].Float()
+	check(err)
+	expect(f, 1.5, t, "TestDecodeGenericValues")
+	expect(m.Items[2].Major, cborNegativeInt, t, "TestDecodeGenericValues")
+	expect(m.Items[2].Arg, uint64(0), t, "TestDecodeGenericValues")
+	expect(m.Items[3].Major, cborTextString, t, "TestDecodeGenericValues")
+	expect(string(m.Items[3].Bytes), "ABCD", t, "TestDecodeGenericValues")
+	_, err = m.Items[3].Float()
+	expect(err != nil, true, t, "TestDecodeGenericValues")
+
+	// values built by hand are encoded as well
+	buf := bytes.NewBuffer(nil)
+	check(NewEncoder(buf).Encode(&Value{Major: cborDataArray, Info: 2, Arg: 2, Items: []Value{
+		{Major: cborUnsignedInt, Info: cborUint8, Arg: 200},
+		{Major: cborByteString, Info: 1, Arg: 1, Bytes: []byte{0xff}},
+	}}))
+	expect(hex.EncodeToString(buf.Bytes()), "8218c841ff", t, "TestDecodeGenericValues")
+}
+
+func TestDecodeGenericErrors(t *testing.T) {
+	cases := []string{
+		"ff",       // break outside indefinite item
+		"8201",     // truncated array
+		"bf6161ff", // break before the map value
+		"5f6161ff", // text chunk in a byte string
+		"c0",       // tag without content
+
+		"5b7fffffffffffffff",   // string longer than the data
+		"5bffffffffffffffff",   // string longer than an int
+		"5f5bffffffffffffffff", // chunk longer than an int
+		"bb8000000000000000",   // map with more pairs than an uint64
+	}
+	for _, c := range cases {
+		in, _ := hex.DecodeString(c)
+		_, err := NewDecoder(bytes.NewReader(in)).DecodeGeneric()
+		expect(err != nil, true, t, "TestDecodeGenericErrors "+c)
+	}
+	_, err := NewDecoder(bytes.NewReader(nil)).DecodeGeneric()
+	expect(err, io.EOF, t, "TestDecodeGenericErrors")
+
+	invalid := []Value{
+		{Major: cborUnsignedInt, Info: 1, Arg: 2},
+		{Major: cborUnsignedInt, Info: cborUint8, Arg: 256},
+		{Major: cborDataArray, Info: 1, Arg: 1},
+		{Major: cborTextString, Info: 2, Arg: 2, Bytes: []byte("a")},
+		{Major: cborTag, Info: 1, Arg: 1},
+		{Major: cborUnsignedInt, Info: cborIndefinite},
+		{Major: cborByteString, Info: cborIndefinite, Items: []Value{{Major: cborTextString}}},
+	}
+	for i, v := range invalid {
+		err := NewEncoder(bytes.NewBuffer(nil)).Encode(v)
+		expect(err != nil, true, t, fmt.Sprintf("TestDecodeGenericErrors %d", i))
+	}
+}
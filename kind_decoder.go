@@ -20,7 +20,12 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"math/big"
+	"net"
+	"net/netip"
 	"reflect"
+	"strconv"
 	"strings"
 )
 
@@ -34,7 +39,25 @@ const (
 )
 
 func (dec *Decoder) decodekInt(rv reflect.Value) error {
-	rv.SetInt(^int64(dec.parser.buflen()))
+	return dec.decodeAnyInt(rv, "integer")
+}
+
+// returns n as a signed integer, applying the -1 - n
+// conversion when the current header is a negative integer
+func (dec *Decoder) signed(n uint64) int64 {
+	if major, _ := dec.parser.parseHeader(); major == cborNegativeInt {
+		return ^int64(n)
+	}
+	return int64(n)
+}
+
+// sets n read with the size of the signed integer kind of rv,
+// it fails if the value doesn't fit into it
+func (dec *Decoder) setSigned(rv reflect.Value, n uint64) error {
+	if n > math.MaxInt64 || rv.OverflowInt(dec.signed(n)) {
+		return dec.overflowError(n, "integer", rv.Type())
+	}
+	rv.SetInt(dec.signed(n))
 	return nil
 }
 
@@ -44,8 +67,10 @@ func (dec *Decoder) decodekUint(rv reflect.Value) error {
 }
 
 func (dec *Decoder) decodekInt8(rv reflect.Value) error {
-	rv.SetInt(int64(dec.decodeInt8()))
-	return nil
+	if len(dec.parser.buf) > 1 { // wider values may still fit
+		return dec.decodeAnyInt(rv, "integer")
+	}
+	return dec.setSigned(rv, uint64(dec.decodeUint8()))
 }
 
 func (dec *Decoder) decodekUint8(rv reflect.Value) error {
@@ -54,8 +79,10 @@ func (dec *Decoder) decodekUint8(rv reflect.Value) error {
 }
 
 func (dec *Decoder) decodekInt16(rv reflect.Value) error {
-	rv.SetInt(int64(dec.decodeInt16()))
-	return nil
+	if len(dec.parser.buf) > 2 { // wider values may still fit
+		return dec.decodeAnyInt(rv, "integer")
+	}
+	return dec.setSigned(rv, uint64(dec.decodeUint16()))
 }
 
 func (dec *Decoder) decodekUint16(rv reflect.Value) error {
@@ -64,8 +91,10 @@ func (dec *Decoder) decodekUint16(rv reflect.Value) error {
 }
 
 func (dec *Decoder) decodekInt32(rv reflect.Value) error {
-	rv.SetInt(int64(dec.decodeInt32()))
-	return nil
+	if len(dec.parser.buf) > 4 { // wider values may still fit
+		return dec.decodeAnyInt(rv, "integer")
+	}
+	return dec.setSigned(rv, uint64(dec.decodeUint32()))
 }
 
 func (dec *Decoder) decodekUint32(rv reflect.Value) error {
@@ -74,8 +103,7 @@ func (dec *Decoder) decodekUint32(rv reflect.Value) error {
 }
 
 func (dec *Decoder) decodekInt64(rv reflect.Value) error {
-	rv.SetInt(int64(dec.decodeInt64()))
-	return nil
+	return dec.setSigned(rv, uint64(dec.decodeUint64()))
 }
 
 func (dec *Decoder) decodekUint64(rv reflect.Value) error {
@@ -84,15 +112,41 @@ func (dec *Decoder) decodekUint64(rv reflect.Value) error {
 }
 
 func (dec *Decoder) decodekFloat32(rv reflect.Value) error {
+	if major, _ := dec.parser.parseHeader(); major != cborNC {
+		return dec.decodeIntAsFloat(rv, 24)
+	}
 	rv.SetFloat(float64(dec.decodeFloat32()))
 	return nil
 }
 
 func (dec *Decoder) decodekFloat64(rv reflect.Value) error {
+	if major, _ := dec.parser.parseHeader(); major != cborNC {
+		return dec.decodeIntAsFloat(rv, 53)
+	}
 	rv.SetFloat(dec.decodeFloat64())
 	return nil
 }
 
+// decodes an integer into a float of the given precision if
+// the decoder accepts it and it can be represented exactly
+func (dec *Decoder) decodeIntAsFloat(rv reflect.Value, prec uint) error {
+	major, _ := dec.parser.parseHeader()
+	if !dec.intFloats || major != cborUnsignedInt && major != cborNegativeInt {
+		return fmt.Errorf("can't decode %s into %s", major, rv.Type())
+	}
+	n := new(big.Int).SetUint64(dec.parser.buflen())
+	if major == cborNegativeInt {
+		n.Add(n, big.NewInt(1)).Neg(n)
+	}
+	f := new(big.Float).SetPrec(prec).SetInt(n)
+	if f.Acc() != big.Exact {
+		return fmt.Errorf("can't decode %s into %s without losing precision", n, rv.Type())
+	}
+	v, _ := f.Float64()
+	rv.SetFloat(v)
+	return nil
+}
+
 func (dec *Decoder) decodekString(rv reflect.Value) error {
 	rv.SetString(dec.decodeString())
 	return nil
@@ -103,11 +157,221 @@ func (dec *Decoder) decodekBool(rv reflect.Value) error {
 	return nil
 }
 
+func (dec *Decoder) decodekBigInt(rv reflect.Value) error {
+	rv.Set(reflect.ValueOf(*dec.decodeBigInt()))
+	return nil
+}
+
+func (dec *Decoder) decodekBigRat(rv reflect.Value) error {
+	if dec.parser.header != absoluteBigFloat {
+		major, _ := dec.parser.parseHeader()
+		return fmt.Errorf("can't decode %s as big float", major)
+	}
+	rv.Set(reflect.ValueOf(*dec.decodeBigFloat()))
+	return nil
+}
+
+func (dec *Decoder) decodekBigFloat(rv reflect.Value) error {
+	if dec.parser.header != absoluteBigFloat {
+		major, _ := dec.parser.parseHeader()
+		return fmt.Errorf("can't decode %s as big float", major)
+	}
+	rv.Set(reflect.ValueOf(*dec.decodeBigFloatValue()))
+	return nil
+}
+
+// decodes the raw bytes of a byte string tagged with the expected
+// base encoding of the destination type, the tag can be omitted
+func (dec *Decoder) decodekExpectedBase(rv reflect.Value) error {
+	expected := map[reflect.Type]byte{
+		typeBase64URLBytes: absoluteBase64Url,
+		typeBase64Bytes:    absoluteBase64String,
+		typeBase16Bytes:    absoluteBase16String,
+	}[rv.Type()]
+	if major, _ := dec.parser.parseHeader(); major == cborTag {
+		if dec.parser.header != expected {
+			return fmt.Errorf("can't decode tag %d into %s", dec.parser.buflen(), rv.Type())
+		}
+		if _, _, err := dec.parser.parseInformation(); err != nil {
+			return err
+		}
+	}
+	if major, _ := dec.parser.parseHeader(); major != cborByteString {
+		return fmt.Errorf("can't decode %s into %s", major, rv.Type())
+	}
+	rv.SetBytes(dec.decodeBytes())
+	return nil
+}
+
+// decodes an IP address from a byte string of 4 or 16 bytes (and
+// zone) tagged as IPv4 or IPv6, untagged byte strings are accepted
+func (dec *Decoder) decodekAddr(rv reflect.Value) error {
+	size, err := dec.parseIPTag()
+	if err != nil {
+		return err
+	}
+	if major, _ := dec.parser.parseHeader(); major != cborByteString {
+		return fmt.Errorf("can't decode %s into %s", major, rv.Type())
+	}
+	b := dec.decodeBytes()
+	if size > 0 && len(b) != size {
+		return fmt.Errorf("can't decode an IP address of %d bytes, expected %d", len(b), size)
+	}
+	var addr netip.Addr
+	if err := addr.UnmarshalBinary(b); err != nil {
+		return err
+	}
+	rv.Set(reflect.ValueOf(addr))
+	return nil
+}
+
+// reads the IPv4 or IPv6 tag of an address or a prefix if present,
+// it returns the size of the tagged address or 0 if it is untagged
+func (dec *Decoder) parseIPTag() (int, error) {
+	if major, _ := dec.parser.parseHeader(); major != cborTag {
+		return 0, nil
+	}
+	size := net.IPv6len
+	switch tag := dec.parser.buflen(); tag {
+	case cborIPv4:
+		size = net.IPv4len
+	case cborIPv6:
+	default:
+		return 0, fmt.Errorf("can't decode tag %d as an IP address", tag)
+	}
+	if _, _, err := dec.parser.parseInformation(); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// decodes a net.IP from a byte string of 4 or 16 bytes, tagged
+// as an IPv4 or IPv6 address or not
+func (dec *Decoder) decodekIP(rv reflect.Value) error {
+	size, err := dec.parseIPTag()
+	if err != nil {
+		return err
+	}
+	if major, _ := dec.parser.parseHeader(); major != cborByteString {
+		return fmt.Errorf("can't decode %s into %s", major, rv.Type())
+	}
+	b := dec.decodeBytes()
+	if size > 0 && len(b) != size || len(b) != net.IPv4len && len(b) != net.IPv6len {
+		return fmt.Errorf("can't decode an IP address of %d bytes", len(b))
+	}
+	rv.SetBytes(b)
+	return nil
+}
+
+// decodes a time.Duration from an integer number of nanoseconds
+func (dec *Decoder) decodekDuration(rv reflect.Value) error {
+	if major, _ := dec.parser.parseHeader(); major != cborUnsignedInt && major != cborNegativeInt {
+		return fmt.Errorf("can't decode %s into %s", major, rv.Type())
+	}
+	return dec.decodeAnyInt(rv, "duration")
+}
+
+// decodes an IP prefix from an array of its length in bits and its
+// address, tagged prefixes may omit the trailing zero bytes of it
+func (dec *Decoder) decodekPrefix(rv reflect.Value) error {
+	size, err := dec.parseIPTag()
+	if err != nil {
+		return err
+	}
+	major, info := dec.parser.parseHeader()
+	if major != cborDataArray || info == cborIndefinite || dec.parser.buflen() != 2 {
+		return fmt.Errorf("can't decode %s into %s, expected an array of two elements", major, rv.Type())
+	}
+	if major, _, err := dec.parser.parseInformation(); err != nil {
+		return err
+	} else if major != cborUnsignedInt {
+		return fmt.Errorf("can't decode %s as a prefix length", major)
+	}
+	bits := dec.parser.buflen()
+	if major, _, err := dec.parser.parseInformation(); err != nil {
+		return err
+	} else if major != cborByteString {
+		return fmt.Errorf("can't decode %s as a prefix address", major)
+	}
+	b := dec.decodeBytes()
+	if size > 0 {
+		// the trailing zero bytes of the address may be omitted
+		if len(b) > size {
+			return fmt.Errorf("can't decode a prefix address of %d bytes, expected at most %d", len(b), size)
+		}
+		b = append(b, make([]byte, size-len(b))...)
+	}
+	addr, ok := netip.AddrFromSlice(b)
+	if !ok {
+		return fmt.Errorf("can't decode a prefix address of %d bytes", len(b))
+	}
+	prefix := netip.PrefixFrom(addr, int(bits))
+	if bits > 128 || !prefix.IsValid() {
+		return fmt.Errorf("invalid prefix length %d for address %s", bits, addr)
+	}
+	rv.Set(reflect.ValueOf(prefix))
+	return nil
+}
+
+func (dec *Decoder) decodekDecimalFraction(rv reflect.Value) error {
+	if dec.parser.header != absoluteDecimalFraction {
+		major, _ := dec.parser.parseHeader()
+		return fmt.Errorf("can't decode %s as decimal fraction", major)
+	}
+	rv.Set(reflect.ValueOf(dec.decodeDecimalFraction()))
+	return nil
+}
+
+func (dec *Decoder) decodekExactBigFloat(rv reflect.Value) error {
+	if dec.parser.header != absoluteBigFloat {
+		major, _ := dec.parser.parseHeader()
+		return fmt.Errorf("can't decode %s as big float", major)
+	}
+	rv.Set(reflect.ValueOf(dec.decodeExactBigFloat()))
+	return nil
+}
+
+func (dec *Decoder) decodekRawMessage(rv reflect.Value) error {
+	raw, err := dec.parser.raw()
+	if err != nil {
+		return err
+	}
+	rv.SetBytes(raw)
+	return nil
+}
+
+func (dec *Decoder) decodekTime(rv reflect.Value) error {
+	rv.Set(reflect.ValueOf(dec.decodeTime()))
+	return nil
+}
+
+// Decode into the value pointed by a pointer, allocating it if needed
+func (dec *Decoder) decodekPtr(rv reflect.Value) error {
+	if rv.IsNil() {
+		rv.Set(reflect.New(rv.Type().Elem()))
+	}
+	return dec.decode(rv.Elem())
+}
+
 func (dec *Decoder) decodekInterface(rv reflect.Value) error {
-	if !rv.IsNil() {
+	// only pointers can be decoded in place, any other value is replaced
+	if !rv.IsNil() && rv.Elem().Kind() == reflect.Ptr && !rv.Elem().IsNil() {
 		return dec.decode(rv.Elem())
 	}
 
+	// user registered types for the major
+	if dec.majorTypes {
+		major, _ := dec.parser.parseHeader()
+		if t, ok := majorTypesDec[major]; ok && t.AssignableTo(rv.Type()) {
+			v := reflect.New(t).Elem()
+			if err := dec.decode(v); err != nil {
+				return err
+			}
+			rv.Set(v)
+			return nil
+		}
+	}
+
 	// blind decoding
 	v, vk, err := dec.blind()
 	if err != nil {
@@ -133,35 +397,108 @@ func (dec *Decoder) decodekInterface(rv reflect.Value) error {
 
 	if decodeFurther {
 		if v != nil {
-			dec.decode(reflect.ValueOf(v).Elem())
+			if err := dec.decode(reflect.ValueOf(v).Elem()); err != nil {
+				return err
+			}
 		}
 	}
 	if v != nil {
+		if t := reflect.TypeOf(v); !t.AssignableTo(rv.Type()) {
+			return fmt.Errorf("can't assign %s to %s", t, rv.Type())
+		}
 		rv.Set(reflect.ValueOf(v))
 	}
 	return nil
 }
 
+// increments the containers nesting level and returns an
+// error if it goes beyond the maximum depth of the decoder
+func (dec *Decoder) enterContainer() error {
+	dec.depth++
+	if dec.maxDepth > 0 && dec.depth > dec.maxDepth {
+		return fmt.Errorf("maximum nesting depth of %d exceeded", dec.maxDepth)
+	}
+	return nil
+}
+
+// decrements the containers nesting level
+func (dec *Decoder) leaveContainer() {
+	dec.depth--
+}
+
 // Decoce into a slice
 func (dec *Decoder) decodekSlice(rv reflect.Value) error {
-	_, info := dec.parser.parseHeader()
+	if err := dec.enterContainer(); err != nil {
+		return err
+	}
+	defer dec.leaveContainer()
+	major, info := dec.parser.parseHeader()
 	rvt := rv.Type()
+	if (major == cborByteString || major == cborTextString) && rvt.Elem().Kind() == reflect.Uint8 {
+		if !rv.CanSet() { // slice of an array
+			b := dec.decodeBytes()
+			if len(b) != rv.Len() {
+				return fmt.Errorf("can't decode %d bytes into [%d]%s", len(b), rv.Len(), rvt.Elem())
+			}
+			reflect.Copy(rv, reflect.ValueOf(b))
+			return nil
+		}
+		rv.SetBytes(dec.decodeBytes())
+		return nil
+	}
+	rvti := rvt.Elem() // elements type for the slice
+	if dec.scalarSlice && major != cborDataArray && major != cborDataMap && major != cborTag {
+		// the single value is decoded as the only element
+		if !rv.CanSet() {
+			if rv.Len() == 0 {
+				return fmt.Errorf("can't decode a value into [0]%s", rvti)
+			}
+		} else if rv.IsNil() || rv.Cap() < 1 {
+			rv.Set(reflect.MakeSlice(rvt, 1, 1))
+		} else {
+			rv.SetLen(1)
+			rv.Index(0).Set(reflect.Zero(rvti))
+		}
+		return dec.decodeOrRecord(rv.Index(0), "index 0")
+	}
+	if major != cborDataArray {
+		// tags are not scalars and map headers are not array lengths
+		return fmt.Errorf("can't decode %s into %s", major, rvt)
+	}
 	if info != cborIndefinite {
 		length := int(dec.parser.buflen())
-		if rv.IsNil() {
-			rv.Set(reflect.MakeSlice(rvt, length, length))
+		reused := false
+		if !rv.CanSet() && length > rv.Len() {
+			return fmt.Errorf("can't decode an array of %d elements into [%d]%s", length, rv.Len(), rvti)
+		}
+		if rv.CanSet() { // slices of arrays have a fixed length
+			// like encoding/json, the backing array of the destination
+			// is reused when it has enough capacity for the elements
+			if rv.IsNil() || rv.Cap() < length {
+				rv.Set(reflect.MakeSlice(rvt, length, length))
+			} else {
+				rv.SetLen(length)
+				reused = true
+			}
 		}
 		for i := 0; i < length; i++ {
 			if _, _, err := dec.parser.parseInformation(); err != nil {
 				return err
 			}
-			if err := dec.decode(rv.Index(i)); err != nil {
+			if reused {
+				rv.Index(i).Set(reflect.Zero(rvti))
+			}
+			if err := dec.decodeOrRecord(rv.Index(i), fmt.Sprintf("index %d", i)); err != nil {
 				return err
 			}
 		}
 	} else {
-		rvti := rvt.Elem() // elements type for the slice
-		rv.Set(reflect.MakeSlice(rvt, 0, 0))
+		fixed := !rv.CanSet() // slices of arrays can't grow
+		if !fixed && rv.IsNil() {
+			rv.Set(reflect.MakeSlice(rvt, 0, 0))
+		} else if !fixed {
+			rv.SetLen(0)
+		}
 		for i := 0; ; i++ {
 			if _, _, err := dec.parser.parseInformation(); err != nil {
 				return err
@@ -169,8 +506,17 @@ func (dec *Decoder) decodekSlice(rv reflect.Value) error {
 			if dec.parser.isBreak() {
 				break
 			}
-			rv.Set(reflect.Append(rv, reflect.Zero(rvti)))
-			if err := dec.decode(rv.Index(i)); err != nil {
+			if fixed {
+				if i >= rv.Len() {
+					return fmt.Errorf("can't decode more than %d elements into [%d]%s", i, rv.Len(), rvti)
+				}
+			} else if i < rv.Cap() {
+				rv.SetLen(i + 1)
+				rv.Index(i).Set(reflect.Zero(rvti))
+			} else {
+				rv.Set(reflect.Append(rv, reflect.Zero(rvti)))
+			}
+			if err := dec.decodeOrRecord(rv.Index(i), fmt.Sprintf("index %d", i)); err != nil {
 				return err
 			}
 		}
@@ -189,27 +535,53 @@ func (dec *Decoder) decodekArray(rv reflect.Value) error {
 // interfaces, then probably the first value assigned to the
 // key will be stuck but there is nothing that guarantee this
 //
+// If the destination map is not nil, the decoded entries are
+// merged into it, existing keys that are not present in the
+// CBOR data are preserved and the ones that are present get
+// overwritten, unless the decoder has been configured to
+// clear the map before decoding into it
+//
 // For more information about the strict mode take a look at
 // the RFC7049 in the secton 3.10. Strict Mode
 func (dec *Decoder) decodekMap(rv reflect.Value) error {
+	if err := dec.enterContainer(); err != nil {
+		return err
+	}
+	defer dec.leaveContainer()
 	rvt := rv.Type()
 	if rv.IsNil() {
 		rv.Set(reflect.MakeMap(rvt))
+	} else if dec.clearMaps {
+		for _, key := range rv.MapKeys() {
+			rv.SetMapIndex(key, reflect.Value{})
+		}
 	}
 	keytype := rvt.Key()
 	valtype := rvt.Elem()
+	shownKeys := map[interface{}]struct{}{}
 
-	_, info := dec.parser.parseHeader()
+	major, info := dec.parser.parseHeader()
+	if isSetType(rvt) {
+		if major == cborTag && dec.parser.buflen() == cborSet {
+			var err error
+			if major, info, err = dec.parser.parseInformation(); err != nil {
+				return err
+			}
+		}
+		if major == cborDataArray {
+			return dec.decodeSet(rv, info)
+		}
+	}
 	if info != cborIndefinite {
 		lenght := int(dec.parser.buflen())
 		for i := 0; i < lenght; i++ {
-			if err := dec.generateKeyValue(keytype, valtype, rv); err != nil {
+			if err := dec.generateKeyValue(keytype, valtype, rv, shownKeys); err != nil {
 				return err
 			}
 		}
 	} else {
 		for {
-			if err := dec.generateKeyValue(keytype, valtype, rv); err != nil {
+			if err := dec.generateKeyValue(keytype, valtype, rv, shownKeys); err != nil {
 				if err != io.EOF {
 					return err
 				}
@@ -252,29 +624,94 @@ func (dec *Decoder) decodekMap(rv reflect.Value) error {
 //
 // For more information about the strict mode take a look at
 // the RFC7049 in the secton 3.10. Strict Mode
+//
+// A RawMessage or []byte field tagged with the `raw` option
+// receives the whole encoded map (or array) the struct is
+// decoded from, so it can be verified or written again later
+//		type Signed struct {
+//			Payload string
+//			Raw     RawMessage `cbor:",raw"`
+//		}
 func (dec *Decoder) decodekStruct(rv reflect.Value) error {
-	rv.Set(reflect.New(rv.Type()).Elem())
-	major, _ := dec.parser.parseHeader()
+	if err := dec.enterContainer(); err != nil {
+		return err
+	}
+	defer dec.leaveContainer()
+	if i := rawField(rv.Type(), dec.jsonTags); i >= 0 {
+		return dec.decodeRawStruct(rv, i)
+	}
+	return dec.decodeStruct(rv)
+}
+
+// decodes the struct capturing the whole encoded item
+// into the raw field with the index i
+func (dec *Decoder) decodeRawStruct(rv reflect.Value, i int) error {
+	mark, stop := dec.parser.startCapture()
+	defer stop()
+	if err := dec.decodeStruct(rv); err != nil {
+		return err
+	}
+	rv.Field(i).SetBytes(append([]byte(nil), dec.parser.capture[mark:]...))
+	return nil
+}
+
+// returns the index of the exported field tagged with
+// the `raw` option or -1 if the struct doesn't have it
+func rawField(t reflect.Type, json bool) int {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		if _, opts := parseTag(fieldTag(field, json)); !opts.Contains("raw") {
+			continue
+		}
+		if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Uint8 {
+			return i
+		}
+	}
+	return -1
+}
+
+// decodes the map or array which header has been already parsed into rv
+func (dec *Decoder) decodeStruct(rv reflect.Value) error {
+	if !dec.mergeStructs {
+		rv.Set(reflect.New(rv.Type()).Elem())
+	}
+	major, info := dec.parser.parseHeader()
+	// the length of nested items doesn't matter, only the struct one
+	indefinite := info == cborIndefinite
 	length := 0
 	numFields := rv.NumField()
 	array := true
 	if major == cborDataMap {
 		array = false
 	}
-	err := dec.checkStructLength(numFields, &length, array)
+	if fields := indexedFields(rv); array && len(fields) > 0 {
+		return dec.decodeIndexedStruct(rv, fields)
+	}
+	err := dec.checkStructLength(numFields, &length, array, indefinite)
 	if err != nil {
 		return err
 	}
-	return dec.decodeInner(rv, numFields, length, array)
+	// only the outermost struct records the keys it finds
+	present := dec.presence
+	dec.presence = nil
+	shownKeys := map[string]struct{}{}
+	if err := dec.decodeInner(rv, numFields, length, array, indefinite, shownKeys, present); err != nil {
+		return err
+	}
+	return setStructDefaults(rv, shownKeys)
 }
 
-func (dec *Decoder) decodeInner(rv reflect.Value, nf, length int, array bool) error {
-	shownKeys := map[string]struct{}{}
+func (dec *Decoder) decodeInner(rv reflect.Value, nf, length int, array, indefinite bool, shownKeys map[string]struct{}, present map[string]bool) error {
+	intKeys := !array && dec.hasIntKeys(rv)
+	seen := map[interface{}]struct{}{}
 	for i := 0; ; i++ {
-		if length == 0 && !dec.parser.indefinite {
+		if length == 0 && !indefinite {
 			break
 		}
-		op, err := dec.checkRtStructLength(i, nf)
+		op, err := dec.checkRtStructLength(i, nf, indefinite)
 		if err != nil {
 			return err
 		}
@@ -289,82 +726,263 @@ func (dec *Decoder) decodeInner(rv reflect.Value, nf, length int, array bool) er
 		if err != nil {
 			return err
 		}
-		if dec.parser.indefinite && dec.parser.isBreak() {
+		if indefinite && dec.parser.isBreak() {
 			break
 		}
 
-		// key must be a string
-		if major < cborByteString || major > cborTextString {
+		// key must be a string or an integer matching a `keyasint` field
+		intKey := intKeys && (major == cborUnsignedInt || major == cborNegativeInt)
+		if !intKey && (major < cborByteString || major > cborTextString) {
 			t := "map"
 			if array {
 				t = "array"
 			}
 			return fmt.Errorf("%s keys must be string, %s received", t, major)
 		}
-		key, err := dec.decodeStructFieldKey(shownKeys)
+		key, err := dec.decodeStructFieldKey(shownKeys, seen)
 		if err != nil {
 			return err
 		}
 
 		// let's decode the value and assign it to the struct field
-		if err := dec.decodeStructFieldValue(rv, key, array); err != nil {
+		if err := dec.decodeStructFieldValue(rv, key, array, intKey); err != nil {
 			if err == forceContinueError && !dec.strict {
 				length--
 				continue
 			}
 			return err
 		}
+		if present != nil {
+			present[key] = true
+		}
 		length--
 	}
 	return nil
 }
 
+// decodes the elements of an array as the keys of a map[K]struct{} set
+func (dec *Decoder) decodeSet(rv reflect.Value, info byte) error {
+	keytype, empty := rv.Type().Key(), reflect.Zero(rv.Type().Elem())
+	length := -1
+	if info != cborIndefinite {
+		length = int(dec.parser.buflen())
+	}
+	for i := 0; i != length; i++ {
+		if _, _, err := dec.parser.parseInformation(); err != nil {
+			return err
+		}
+		if length < 0 && dec.parser.isBreak() {
+			break
+		}
+		key := reflect.New(keytype).Elem()
+		if err := dec.decode(key); err != nil {
+			return err
+		}
+		if dec.strict && rv.MapIndex(key).IsValid() {
+			return NewStrictModeError(fmt.Sprintf("duplicated element %v in set", key))
+		}
+		rv.SetMapIndex(key, empty)
+	}
+	return nil
+}
+
+// returns true if any field of the struct has the `keyasint` option
+func (dec *Decoder) hasIntKeys(rv reflect.Value) bool {
+	for i := 0; i < rv.NumField(); i++ {
+		if _, opts := parseTag(fieldTag(rv.Type().Field(i), dec.jsonTags)); opts.Contains("keyasint") {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the fields of a struct tagged with the `index` option
+// mapped by the position of the array element they receive
+func indexedFields(rv reflect.Value) map[int]int {
+	fields := map[int]int{}
+	for i := 0; i < rv.NumField(); i++ {
+		name, opts := parseTag(rv.Type().Field(i).Tag.Get("cbor"))
+		if !opts.Contains("index") {
+			continue
+		}
+		if n, err := strconv.Atoi(name); err == nil {
+			fields[n] = i
+		}
+	}
+	return fields
+}
+
+// decodes the elements of an array into the struct fields
+// that are tagged with the index of the element position,
+// the fields missing in the array get their default value
+func (dec *Decoder) decodeIndexedStruct(rv reflect.Value, fields map[int]int) error {
+	_, info := dec.parser.parseHeader()
+	shownKeys := map[string]struct{}{}
+	length := -1
+	if info != cborIndefinite {
+		length = int(dec.parser.buflen())
+	}
+	for i := 0; i != length; i++ {
+		if _, _, err := dec.parser.parseInformation(); err != nil {
+			return err
+		}
+		if length < 0 && dec.parser.isBreak() {
+			break
+		}
+		f, ok := fields[i]
+		if !ok {
+			msg := fmt.Sprintf("array element %d doesn't match with any field", i)
+			if dec.strict {
+				return NewStrictModeError(msg)
+			}
+			log.Printf("warning strict-mode: %s skipping...\n", msg)
+			if err := dec.parser.skip(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := dec.decode(rv.Field(f)); err != nil {
+			return err
+		}
+		shownKeys[rv.Type().Field(f).Name] = struct{}{}
+	}
+	return setStructDefaults(rv, shownKeys)
+}
+
+// decodes the data item which header has been already parsed into rv,
+// when the decoder continues on errors the item is captured while it is
+// decoded so a failure can be recorded (prefixed with the path of the
+// item and its offset) and the rest of the item skipped
+func (dec *Decoder) decodeOrRecord(rv reflect.Value, context string) error {
+	if dec.errs == nil {
+		return dec.decode(rv)
+	}
+	mark, stop := dec.parser.startCapture()
+	defer stop()
+	dec.path = append(dec.path, context)
+	defer func() {
+		dec.path = dec.path[:len(dec.path)-1]
+	}()
+	err := dec.decode(rv)
+	if err == nil {
+		return nil
+	}
+	err = fmt.Errorf("%s: %w", strings.Join(dec.path, ": "), dec.positionError(err))
+	if err := dec.parser.skipCaptured(mark); err != nil {
+		return err
+	}
+	*dec.errs = append(*dec.errs, err)
+	return nil
+}
+
 // helper function to generate a pair key, value to decode into maps
-func (dec *Decoder) generateKeyValue(ktype, vtype reflect.Type, rv reflect.Value) error {
-	if _, _, err := dec.parser.parseInformation(); err != nil {
+func (dec *Decoder) generateKeyValue(ktype, vtype reflect.Type, rv reflect.Value, shownKeys map[interface{}]struct{}) error {
+	major, _, err := dec.parser.parseInformation()
+	if err != nil {
 		return err
 	}
 	if dec.parser.isBreak() {
 		return io.EOF
 	}
+	// in strict mode string keyed maps only accept text string keys
+	if dec.strict && ktype.Kind() == reflect.String && major != cborTextString {
+		return NewStrictModeError(fmt.Sprintf("map key of type %s can't be decoded into %s", major, ktype))
+	}
 	key := reflect.New(ktype).Elem()
-	dec.decode(key)
-	// check if the key exists when we are in strict mode
-	if dec.strict {
-		if rv.MapIndex(key).IsValid() {
-			return NewStrictModeError(fmt.Sprintf("duplicated key %s in map", key))
+	if ktype.PkgPath() == "" && (major == cborUnsignedInt || major == cborNegativeInt) && isIntKind(ktype.Kind()) {
+		err = dec.decodeAnyInt(key, "map key")
+	} else {
+		err = dec.decode(key)
+	}
+	if err != nil {
+		return err
+	}
+	// check if the key has been already decoded when we are in strict mode
+	if marker, ok := keyMarker(key); dec.strict && ok {
+		if _, ok := shownKeys[marker]; ok {
+			return NewStrictModeError(fmt.Sprintf("duplicated key %v in map", key.Interface()))
 		}
+		shownKeys[marker] = struct{}{}
 	}
 	if _, _, err := dec.parser.parseInformation(); err != nil {
 		return err
 	}
-	val := rv.MapIndex(key)
-	if !val.IsValid() {
-		val = reflect.New(vtype).Elem()
+	// map values are not addressable so we decode into a copy
+	// of the existing value (if any) and store it back later
+	val := reflect.New(vtype).Elem()
+	if old := rv.MapIndex(key); old.IsValid() {
+		val.Set(old)
+	}
+	if err := dec.decodeOrRecord(val, fmt.Sprintf("key %v", key.Interface())); err != nil {
+		return err
 	}
-	dec.decode(val)
 	rv.SetMapIndex(key, val)
 	return nil
 }
 
+// decodes an integer into any integer type whatever the width it
+// was encoded with, it fails if the integer doesn't fit into it
+func (dec *Decoder) decodeAnyInt(rv reflect.Value, what string) error {
+	major, _ := dec.parser.parseHeader()
+	n := dec.parser.buflen()
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n <= math.MaxInt64 && !rv.OverflowInt(dec.signed(n)) {
+			rv.SetInt(dec.signed(n))
+			return nil
+		}
+	default:
+		if major == cborUnsignedInt && !rv.OverflowUint(n) {
+			rv.SetUint(n)
+			return nil
+		}
+	}
+	return dec.overflowError(n, what, rv.Type())
+}
+
+// returns the error for the integer argument n of the current
+// header which value doesn't fit into the type t
+func (dec *Decoder) overflowError(n uint64, what string, t reflect.Type) error {
+	v := new(big.Int).SetUint64(n)
+	if major, _ := dec.parser.parseHeader(); major == cborNegativeInt {
+		v.Add(v, big.NewInt(1)).Neg(v)
+	}
+	return fmt.Errorf("%s %s doesn't fit into %s", what, v, t)
+}
+
+// returns true if k is a signed or unsigned integer kind
+func isIntKind(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Uintptr
+}
+
 // helper function that iterates over the fields
-// of a struct looking for a specific tag
-func (dec *Decoder) lookupStructTag(st reflect.Value, tag string, array bool) string {
+// of a struct looking for a specific tag, integer
+// keys only match fields with the `keyasint` option
+func (dec *Decoder) lookupStructTag(st reflect.Value, tag string, array, intKey bool) string {
 	for i := 0; i < st.NumField(); i++ {
 		field := st.Type().Field(i)
-		t := field.Tag.Get("cbor")
-		if t != "" {
-			if strings.Contains(t, tag) {
-				return field.Name
-			}
+		name, opts := parseTag(fieldTag(field, dec.jsonTags))
+		if name != "" && name != "-" && name == tag && (!intKey || opts.Contains("keyasint")) {
+			return field.Name
 		}
 	}
 	return ""
 }
 
+// returns true if the field with the given name is tagged with "-",
+// such fields are never decoded, like they are never encoded
+func (dec *Decoder) isSkippedField(st reflect.Type, name string) bool {
+	field, ok := st.FieldByName(name)
+	if !ok {
+		return false
+	}
+	tag, _ := parseTag(fieldTag(field, dec.jsonTags))
+	return tag == "-"
+}
+
 // common length checks for struct decoders
-func (dec *Decoder) checkStructLength(nf int, length *int, array bool) error {
-	if !dec.parser.indefinite {
+func (dec *Decoder) checkStructLength(nf int, length *int, array, indefinite bool) error {
+	if !indefinite {
 		l := int(dec.parser.buflen())
 		nlen := l
 		if array {
@@ -385,7 +1003,7 @@ func (dec *Decoder) checkStructLength(nf int, length *int, array bool) error {
 }
 
 // common length in runtime check for struct decoders
-func (dec *Decoder) checkRtStructLength(i, nf int) (uint, error) {
+func (dec *Decoder) checkRtStructLength(i, nf int, indefinite bool) (uint, error) {
 	if i > nf {
 		// if strict mode is on, check for the right number of fields
 		msg := fmt.Sprintf(
@@ -394,7 +1012,7 @@ func (dec *Decoder) checkRtStructLength(i, nf int) (uint, error) {
 			return d_NOP, NewStrictModeError(msg)
 		}
 		log.Printf("warning strict-mode: %s\n", msg)
-		if dec.parser.indefinite && dec.parser.isBreak() {
+		if indefinite && dec.parser.isBreak() {
 			return d_BREAK, nil
 		}
 		if _, _, err := dec.parser.parseInformation(); err != nil {
@@ -406,27 +1024,119 @@ func (dec *Decoder) checkRtStructLength(i, nf int) (uint, error) {
 }
 
 // decodes a key to be used as a struct field in struct decoders
-func (dec *Decoder) decodeStructFieldKey(shownKeys map[string]struct{}) (string, error) {
-	key := dec.decodeString()
-	if dec.strict {
-		if _, ok := shownKeys[key]; ok {
-			return "", NewStrictModeError(
-				fmt.Sprintf("duplicated key %s in map", key))
-		}
-		shownKeys[key] = struct{}{}
+func (dec *Decoder) decodeStructFieldKey(shownKeys map[string]struct{}, seen map[interface{}]struct{}) (string, error) {
+	var key string
+	var marker interface{} // integer and text keys are different keys
+	switch major, _ := dec.parser.parseHeader(); major {
+	case cborUnsignedInt:
+		n := dec.parser.buflen()
+		key, marker = strconv.FormatUint(n, 10), n
+	case cborNegativeInt:
+		n := ^int64(dec.parser.buflen())
+		key, marker = strconv.FormatInt(n, 10), n
+	default:
+		key = dec.decodeString()
+		marker = key
+	}
+	if _, ok := seen[marker]; ok && dec.strict {
+		return "", NewStrictModeError(
+			fmt.Sprintf("duplicated key %s in map", key))
 	}
+	seen[marker] = struct{}{}
+	shownKeys[key] = struct{}{}
 	return key, nil
 }
 
+// returns the value used to detect duplicated map keys, integers are
+// widened so the same integer encoded with different widths is the
+// same key, false is returned for keys that can't be compared
+func keyMarker(key reflect.Value) (interface{}, bool) {
+	if key.Kind() == reflect.Interface {
+		key = key.Elem()
+	}
+	if !key.IsValid() {
+		return nil, true
+	}
+	if !key.Type().Comparable() {
+		return nil, false
+	}
+	marker, _ := widenInt(key.Interface(), key.Kind())
+	return marker, true
+}
+
+// sets the fields with a `default=value` tag option that
+// were not present in the decoded keys to its default value
+func setStructDefaults(rv reflect.Value, shownKeys map[string]struct{}) error {
+	for i := 0; i < rv.NumField(); i++ {
+		field := rv.Type().Field(i)
+		name, opts := parseTag(field.Tag.Get("cbor"))
+		def, ok := opts.Value("default")
+		if !ok {
+			continue
+		}
+		if _, ok := shownKeys[field.Name]; ok {
+			continue
+		}
+		if _, ok := shownKeys[name]; ok && name != "" {
+			continue
+		}
+		if err := setDefault(rv.Field(i), def); err != nil {
+			return fmt.Errorf("invalid default value %q for field %s: %s",
+				def, field.Name, err)
+		}
+	}
+	return nil
+}
+
+// parses the default value s depending on the kind of v and sets it
+func setDefault(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.String:
+		v.SetString(s)
+	default:
+		return fmt.Errorf("defaults are not supported for %s fields", v.Kind())
+	}
+	return nil
+}
+
 // decode a value to be used as a struct field value in struct decoders
-func (dec *Decoder) decodeStructFieldValue(rv reflect.Value, key string, array bool) error {
-	var field reflect.Value
-	if field = rv.FieldByName(key); !field.IsValid() {
-		if field = rv.FieldByName(dec.lookupStructTag(rv, key, array)); !field.IsValid() {
+func (dec *Decoder) decodeStructFieldValue(rv reflect.Value, key string, array, intKey bool) error {
+	name := key
+	field := rv.FieldByName(name)
+	if !field.IsValid() || dec.isSkippedField(rv.Type(), name) {
+		name = dec.lookupStructTag(rv, key, array, intKey)
+		if field = rv.FieldByName(name); !field.IsValid() {
 			msg := fmt.Sprintf("key %s doesn't match with any field", key)
 			if dec.strict {
 				return NewStrictModeError(msg)
 			}
+			if dec.noUnknown {
+				return errors.New(msg)
+			}
 			log.Printf("warning strict-mode: %s skipping...\n", msg)
 			if _, _, err := dec.parser.parseInformation(); err != nil {
 				return err
@@ -437,6 +1147,14 @@ func (dec *Decoder) decodeStructFieldValue(rv reflect.Value, key string, array b
 	if _, _, err := dec.parser.parseInformation(); err != nil {
 		return err
 	}
-	err := dec.decode(field)
-	return err
+	if dec.beforeField != nil {
+		dec.beforeField(name, field)
+	}
+	if err := dec.decodeOrRecord(field, "field "+name); err != nil {
+		return err
+	}
+	if dec.afterField != nil {
+		dec.afterField(name, field)
+	}
+	return nil
 }
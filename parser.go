@@ -43,17 +43,40 @@ func NewParseErr(msg string) ParserErr {
 // the well-formedness of the 'data item' and to store
 // data to be processed later
 type Parser struct {
-	header     byte
-	r          io.Reader
-	indefinite bool
-	buf        []byte
-	off        int // the offset inside the buf
+	header byte
+	r      io.Reader
+	buf    []byte
+	off    int  // the offset inside the buf
+	inItem bool // bytes of the current top level item have been scanned
+
+	capturing bool
+	capture   []byte // the bytes scanned while capturing
+
+	peeked  []byte // bytes read by more but not scanned yet
+	peekErr error  // error found by more, returned by the next scan
+
+	pos     int64 // number of bytes scanned from the io.Reader
+	itemPos int64 // offset of the last parsed header
+
+	depth    int // current nesting level of skipped containers
+	maxDepth int // maximum nesting level of skipped containers
 }
 
+// size of the first chunk read by scan, big lengths are read in
+// growing chunks so a forged length can't allocate more memory
+// than the data that is really available in the io.Reader
+const scanChunkSize = 64 << 10
+
 // Create a new Parser with the given
 // io.Reader and resturns back it's address
 func NewParser(r io.Reader) *Parser {
-	return &Parser{r: r}
+	return &Parser{r: r, maxDepth: defaultMaxDepth}
+}
+
+// Marks the start of a new top level 'data item', running out of
+// input there is the end of the input (io.EOF) and not an error
+func (p *Parser) startItem() {
+	p.inItem = false
 }
 
 // Returns true if the header is the
@@ -79,6 +102,7 @@ func (p *Parser) isUndef() bool {
 // It also populates the internal buffer if major is not Tag (6) and the
 // additional information is not an undefinite (streamed data) type (31)
 func (p *Parser) parseInformation() (major Major, info byte, err error) {
+	p.itemPos = p.pos
 	p.header, err = p.scan1()
 	if err != nil {
 		return 0, 0, err
@@ -93,7 +117,6 @@ func (p *Parser) parseInformation() (major Major, info byte, err error) {
 			return major, info, NewParseErr(fmt.Sprintf(
 				"received additional info 31 (indefinite) for wrong major %d\n", major))
 		}
-		p.indefinite = true
 		return major, infotype, nil
 	}
 	if (infotype >= 28 && infotype <= 30) || infotype > 31 {
@@ -131,6 +154,16 @@ func (p *Parser) buflen() uint64 {
 	return v
 }
 
+// returns back the length of the buffer as an int, it fails for
+// lengths that don't fit in an int and can't be scanned at all
+func (p *Parser) length() (int, error) {
+	n := p.buflen()
+	if n > math.MaxInt {
+		return 0, NewParseErr(fmt.Sprintf("length %d is too big", n))
+	}
+	return int(n), nil
+}
+
 // Read N bytes from the internal buffer
 // If the buffer doesn't contains that many
 // bytes, the function just panic (as it had
@@ -154,18 +187,60 @@ func (p *Parser) scan(n int) (numbytes int, data []byte, err error) {
 	if n <= 0 {
 		return
 	}
-	data = make([]byte, n)
-	if numbytes, err = p.r.Read(data); err != nil {
+	if p.peekErr != nil {
+		err, p.peekErr = p.peekErr, nil
 		return 0, nil, err
 	}
-	if numbytes < n {
-		return 0, nil, NewParseErr(fmt.Sprintf(
-			"can't scan %d bytes from buffer as only %d are available\n", n, numbytes))
+	data = make([]byte, min(n, scanChunkSize))
+	numbytes = copy(data, p.peeked)
+	p.peeked = p.peeked[numbytes:]
+	for {
+		k, err := io.ReadFull(p.r, data[numbytes:])
+		numbytes += k
+		if err == io.ErrUnexpectedEOF || err == io.EOF && numbytes > 0 {
+			return 0, nil, NewParseErr(fmt.Sprintf(
+				"can't scan %d bytes from buffer as only %d are available\n", n, numbytes))
+		}
+		if err == io.EOF && p.inItem {
+			return 0, nil, NewParseErr("unexpected end of data")
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		if numbytes == n {
+			break
+		}
+		data = append(data, make([]byte, min(n-numbytes, len(data)))...)
 	}
 	p.off = 0
+	p.inItem = true
+	p.pos += int64(numbytes)
+	if p.capturing {
+		p.capture = append(p.capture, data...)
+	}
 	return numbytes, data, nil
 }
 
+// Returns true if there is at least one more byte to scan from the
+// io.Reader, the byte is kept to be returned by the next scan call.
+// Read errors other than io.EOF are kept for the next scan as well
+func (p *Parser) more() bool {
+	if len(p.peeked) > 0 || p.peekErr != nil {
+		return true
+	}
+	b := make([]byte, 1)
+	n, err := io.ReadFull(p.r, b)
+	if n == 1 {
+		p.peeked = b
+		return true
+	}
+	if err == io.EOF {
+		return false
+	}
+	p.peekErr = err
+	return true
+}
+
 // Reads a single byte from the parser io.Reader
 func (p *Parser) scan1() (byte, error) {
 	_, tmpdata, err := p.scan(1)
@@ -175,6 +250,137 @@ func (p *Parser) scan1() (byte, error) {
 	return tmpdata[0], nil
 }
 
+// increments the nesting level of skipped containers and returns
+// an error if it goes beyond the maximum depth of the parser
+func (p *Parser) enterContainer() error {
+	p.depth++
+	if p.maxDepth > 0 && p.depth > p.maxDepth {
+		return fmt.Errorf("maximum nesting depth of %d exceeded", p.maxDepth)
+	}
+	return nil
+}
+
+// decrements the nesting level of skipped containers
+func (p *Parser) leaveContainer() {
+	p.depth--
+}
+
+// Skips the rest of the 'data item' which header has been already parsed
+//
+// Strings contents, array elements, map keys and values and tags contents
+// are consumed from the io.Reader (recursively when needed)
+func (p *Parser) skip() error {
+	major, info := p.parseHeader()
+	var n, items uint64 = 0, 1
+	switch major {
+	case cborByteString, cborTextString:
+		if info == cborIndefinite {
+			return p.skipUntilBreak()
+		}
+		size, err := p.length()
+		if err != nil {
+			return err
+		}
+		_, _, err = p.scan(size)
+		return err
+	case cborDataArray, cborDataMap:
+		if info == cborIndefinite {
+			return p.skipUntilBreak()
+		}
+		n = p.buflen()
+		if major == cborDataMap {
+			items = 2
+		}
+	case cborTag:
+		n = 1
+	default:
+		return nil
+	}
+	defer p.leaveContainer()
+	if err := p.enterContainer(); err != nil {
+		return err
+	}
+	for i := uint64(0); i < n; i++ {
+		for j := uint64(0); j < items; j++ {
+			if _, _, err := p.parseInformation(); err != nil {
+				return err
+			}
+			if p.isBreak() {
+				return NewParseErr("unexpected break stop code in definite length item")
+			}
+			if err := p.skip(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Skips 'data items' until a break stop code is found
+func (p *Parser) skipUntilBreak() error {
+	defer p.leaveContainer()
+	if err := p.enterContainer(); err != nil {
+		return err
+	}
+	for {
+		if _, _, err := p.parseInformation(); err != nil {
+			return err
+		}
+		if p.isBreak() {
+			return nil
+		}
+		if err := p.skip(); err != nil {
+			return err
+		}
+	}
+}
+
+// Returns back the raw bytes of the 'data item' which header has been
+// already parsed, the rest of the item is consumed from the io.Reader
+func (p *Parser) raw() ([]byte, error) {
+	mark, stop := p.startCapture()
+	defer stop()
+	if err := p.skip(); err != nil {
+		return nil, err
+	}
+	if mark == 0 {
+		return p.capture, nil
+	}
+	return append([]byte(nil), p.capture[mark:]...), nil
+}
+
+// Starts capturing the bytes of the 'data item' which header has been
+// already parsed, it returns back the position of the header inside the
+// captured bytes and a function that stops capturing. Nested captures
+// share the bytes of the outermost one, which is the only one stopped
+func (p *Parser) startCapture() (int, func()) {
+	header := 1
+	if info := p.header & 0x1f; info > cborSmallInt && info < 28 {
+		header += 1 << (info - cborUint8)
+	}
+	if p.capturing {
+		return len(p.capture) - header, func() {}
+	}
+	p.capturing, p.capture = true, append([]byte{p.header}, p.buf[:header-1]...)
+	return 0, func() {
+		p.capturing, p.capture = false, nil
+	}
+}
+
+// Skips the whole captured 'data item' which header is at mark, after
+// it has been partially consumed, the consumed bytes are scanned again
+// so the item is skipped with the same checks than any other one
+func (p *Parser) skipCaptured(mark int) error {
+	item := p.capture[mark:]
+	p.peeked = append(append([]byte(nil), item...), p.peeked...)
+	p.capture = p.capture[:mark]
+	p.pos -= int64(len(item))
+	if _, _, err := p.parseInformation(); err != nil {
+		return err
+	}
+	return p.skip()
+}
+
 // Read a single byte from the internal
 // buffer and returns it back as an uint8
 func (p *Parser) parseUint8() uint8 {
@@ -51,6 +51,38 @@ func TestScan(t *testing.T) {
 	expect(err, NewParseErr("can't scan 5 bytes from buffer as only 4 are available\n"), t, "TestScan")
 }
 
+// io.Reader that returns a single byte per Read call
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (o *oneByteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return o.r.Read(p[:1])
+}
+
+func TestScanShortReads(t *testing.T) {
+	buf := []byte{'t', 'e', 's', 't'}
+	p := NewParser(&oneByteReader{bytes.NewBuffer(buf)})
+	n, value, err := p.scan(4)
+	check(err)
+	expect(4, n, t, "TestScanShortReads")
+	expect(string(buf), string(value), t, "TestScanShortReads")
+
+	p = NewParser(&oneByteReader{bytes.NewBuffer(buf)})
+	_, _, err = p.scan(5)
+	expect(err, NewParseErr("can't scan 5 bytes from buffer as only 4 are available\n"), t, "TestScanShortReads")
+
+	data := []byte{0xa2, 0x63, 0x46, 0x75, 0x6e, 0xf5, 0x63, 0x41, 0x6d, 0x74, 0x1a, 0x45, 0xab, 0x23, 0x00}
+	d := NewDecoder(&oneByteReader{bytes.NewReader(data)})
+	var a map[string]interface{}
+	check(d.Decode(&a))
+	expect(a["Fun"], true, t, "TestScanShortReads")
+	expect(a["Amt"], uint32(1168843520), t, "TestScanShortReads")
+}
+
 func TestScan1(t *testing.T) {
 	buf := []byte{'t', 'e', 's', 't'}
 	r := bytes.NewBuffer(buf)
@@ -69,7 +101,12 @@ func TestScan1(t *testing.T) {
 	check(err)
 	expect(byte('t'), value, t, "TestScan1")
 
-	// read beyond limits returns io.EOF
+	// read beyond limits in the middle of an item is an error
+	_, err = p.scan1()
+	expect(err, NewParseErr("unexpected end of data"), t, "TestScan1")
+
+	// read beyond limits on an item boundary returns io.EOF
+	p.startItem()
 	_, err = p.scan1()
 	expect(err, io.EOF, t, "TestScan1")
 }
@@ -0,0 +1,173 @@
+// A Golang RFC7049 implementation
+// Copyright (C) 2015 Oscar Campos
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbor
+
+import (
+	"fmt"
+	"math"
+)
+
+// token is a single data item header read by a tokenizer, it is a
+// Value without Items, containers, tags and indefinite length strings
+// are followed by the tokens of its contents and closed by an end token
+type token struct {
+	Value
+	end bool // closes the innermost container, tag or indefinite string
+}
+
+// opens returns true if the token is followed by the tokens of its
+// contents, that is an array, a map, a tag or an indefinite string
+func (t token) opens() bool {
+	switch t.Major {
+	case cborDataArray, cborDataMap, cborTag:
+		return true
+	case cborByteString, cborTextString:
+		return t.IsIndefinite()
+	}
+	return false
+}
+
+// an open container, tag or indefinite length string
+type tokenFrame struct {
+	major Major
+	left  uint64 // items left in a definite length container
+	count uint64 // items read so far
+	open  bool   // indefinite length, closed by a break stop code
+}
+
+// tokenizer reads the data items of a parser one header at a time and
+// checks that they are well-formed: lengths don't go beyond the int
+// range, break stop codes only close indefinite length items, maps
+// have a value for every key, chunks of indefinite strings are definite
+// strings of the same major and containers don't nest too deep. It is
+// shared by Valid, Walk, Diagnose, Canonicalize and DecodeGeneric
+type tokenizer struct {
+	p        *Parser
+	maxDepth int // a value of zero or less disables the check
+
+	// discards the contents of strings instead of reading them
+	skip func(n uint64) error
+
+	frames []tokenFrame
+}
+
+// creates a new tokenizer that reads from the given parser
+func newTokenizer(p *Parser, maxDepth int) *tokenizer {
+	return &tokenizer{p: p, maxDepth: maxDepth}
+}
+
+// reads the next token, at the end of every open item it returns an
+// end token, io.EOF is returned as it is before the first token
+func (tz *tokenizer) next() (token, error) {
+	var top *tokenFrame
+	if len(tz.frames) > 0 {
+		top = &tz.frames[len(tz.frames)-1]
+		if !top.open && top.left == 0 {
+			tz.frames = tz.frames[:len(tz.frames)-1]
+			return token{end: true}, nil
+		}
+	}
+	major, info, err := tz.p.parseInformation()
+	if err != nil {
+		return token{}, err
+	}
+	if tz.p.isBreak() {
+		if top == nil || !top.open {
+			return token{}, NewParseErr("unexpected break stop code outside indefinite item")
+		}
+		if top.major == cborDataMap && top.count%2 != 0 {
+			return token{}, NewParseErr("break stop code found before the map value")
+		}
+		tz.frames = tz.frames[:len(tz.frames)-1]
+		return token{end: true}, nil
+	}
+	if top != nil {
+		if top.open && top.major <= cborTextString && (major != top.major || info == cborIndefinite) {
+			return token{}, NewParseErr(fmt.Sprintf(
+				"invalid chunk of major %d inside indefinite string of major %d", major, top.major))
+		}
+		if !top.open {
+			top.left--
+		}
+		top.count++
+	}
+	t := token{Value: Value{Major: major, Info: info}}
+	if info == cborIndefinite {
+		return t, tz.push(tokenFrame{major: major, open: true})
+	}
+	t.Arg = tz.p.buflen()
+	switch major {
+	case cborByteString, cborTextString:
+		if tz.skip != nil {
+			return t, tz.skip(t.Arg)
+		}
+		if t.Arg > math.MaxInt {
+			return token{}, NewParseErr(fmt.Sprintf("length %d is too big", t.Arg))
+		}
+		_, t.Bytes, err = tz.p.scan(int(t.Arg))
+		return t, err
+	case cborDataArray:
+		return t, tz.push(tokenFrame{major: major, left: t.Arg})
+	case cborDataMap:
+		if t.Arg > math.MaxUint64/2 {
+			return token{}, NewParseErr(fmt.Sprintf("map of %d pairs is too big", t.Arg))
+		}
+		return t, tz.push(tokenFrame{major: major, left: t.Arg * 2})
+	case cborTag:
+		return t, tz.push(tokenFrame{major: major, left: 1})
+	}
+	return t, nil
+}
+
+// opens a new item checking the maximum nesting depth
+func (tz *tokenizer) push(f tokenFrame) error {
+	if tz.maxDepth > 0 && len(tz.frames) >= tz.maxDepth {
+		return fmt.Errorf("maximum nesting depth of %d exceeded", tz.maxDepth)
+	}
+	tz.frames = append(tz.frames, f)
+	return nil
+}
+
+// calls fn with the position and the token of every item inside the
+// item just opened by a token, until its end token is read
+func (tz *tokenizer) each(fn func(n int, t token) error) error {
+	for n := 0; ; n++ {
+		t, err := tz.next()
+		if err != nil {
+			return err
+		}
+		if t.end {
+			return nil
+		}
+		if err := fn(n, t); err != nil {
+			return err
+		}
+	}
+}
+
+// returns the contents of a string token, joining together all
+// the chunks of an indefinite length string
+func (tz *tokenizer) join(t token) ([]byte, error) {
+	if !t.IsIndefinite() {
+		return t.Bytes, nil
+	}
+	var data []byte
+	err := tz.each(func(_ int, chunk token) error {
+		data = append(data, chunk.Bytes...)
+		return nil
+	})
+	return data, err
+}
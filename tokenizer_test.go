@@ -0,0 +1,85 @@
+// A Golang RFC7049 implementation
+// Copyright (C) 2015 Oscar Campos
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// returns the headers read by a tokenizer from the hex encoded data,
+// end tokens are written as ")"
+func tokenize(in string, maxDepth int) (string, error) {
+	data, _ := hex.DecodeString(in)
+	tz := newTokenizer(NewParser(bytes.NewReader(data)), maxDepth)
+	var out []string
+	for {
+		t, err := tz.next()
+		if err != nil {
+			return strings.Join(out, " "), err
+		}
+		if t.end {
+			out = append(out, ")")
+		} else {
+			out = append(out, fmt.Sprintf("%d/%d", t.Major, t.Arg))
+		}
+		if len(tz.frames) == 0 {
+			return strings.Join(out, " "), nil
+		}
+	}
+}
+
+func TestTokenizer(t *testing.T) {
+	cases := []struct{ in, out string }{
+		{"01", "0/1"},
+		{"820102", "4/2 0/1 0/2 )"},
+		{"9f8101ff", "4/0 4/1 0/1 ) )"},
+		{"a1c10102", "5/1 6/1 0/1 ) 0/2 )"},
+		{"5f41614162ff", "2/0 2/1 2/1 )"},
+	}
+	for _, c := range cases {
+		out, err := tokenize(c.in, defaultMaxDepth)
+		check(err)
+		expect(out, c.out, t, "TestTokenizer "+c.in)
+	}
+}
+
+func TestTokenizerErrors(t *testing.T) {
+	cases := []struct{ in, msg string }{
+		{"82ff", "outside indefinite item"},
+		{"9fc1ff", "outside indefinite item"},
+		{"bf01ff", "before the map value"},
+		{"5f6161ff", "invalid chunk"},
+		{"5f5f4161ffff", "invalid chunk"},
+		{"5bffffffffffffffff", "too big"},
+		{"bb8000000000000000", "too big"},
+	}
+	for _, c := range cases {
+		_, err := tokenize(c.in, defaultMaxDepth)
+		expect(err != nil && strings.Contains(err.Error(), c.msg), true, t, fmt.Sprint("TestTokenizerErrors ", c.in, ": ", err))
+	}
+
+	// strings don't nest, indefinite strings do
+	_, err := tokenize("818101", 1)
+	expect(fmt.Sprint(err), "maximum nesting depth of 1 exceeded", t, "TestTokenizerErrors")
+	_, err = tokenize("815f4161ff", 1)
+	expect(fmt.Sprint(err), "maximum nesting depth of 1 exceeded", t, "TestTokenizerErrors")
+	_, err = tokenize("814161", 1)
+	check(err)
+}
@@ -0,0 +1,82 @@
+// A Golang RFC7049 implementation
+// Copyright (C) 2015 Oscar Campos
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Valid checks that data contains exactly one well-formed CBOR data item:
+// every header uses a valid additional information, lengths don't go
+// beyond the end of data, indefinite length items are closed by a break
+// stop code and there is no trailing data after the item. It returns nil
+// when data is well-formed, string contents are skipped and no Go values
+// are constructed while walking it
+func Valid(data []byte) error {
+	r := bytes.NewReader(data)
+	tz := newTokenizer(NewParser(r), defaultMaxDepth)
+	tz.skip = func(n uint64) error {
+		return validSkip(r, n)
+	}
+	t, err := tz.next()
+	if err == nil {
+		err = validToken(tz, t)
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = NewParseErr("unexpected end of data")
+		}
+		return err
+	}
+	if r.Len() > 0 {
+		return NewParseErr(fmt.Sprintf(
+			"%d bytes of trailing data after the first data item", r.Len()))
+	}
+	return nil
+}
+
+// checks the simple values of the data item started by t and its
+// contents, everything else is checked by the tokenizer already
+func validToken(tz *tokenizer, t token) error {
+	if t.Major == cborNC && t.Info == absoluteSimple&0x1f {
+		if t.Arg < 24 {
+			return NewParseErr(fmt.Sprintf(
+				"simple value %d must be encoded in the header", t.Arg))
+		} else if t.Arg < 32 {
+			// RFC 8949 section 3.3, values 24 to 31 are not well-formed
+			return NewParseErr(fmt.Sprintf(
+				"simple value %d is not well-formed in two bytes", t.Arg))
+		}
+	}
+	if !t.opens() {
+		return nil
+	}
+	return tz.each(func(_ int, t token) error {
+		return validToken(tz, t)
+	})
+}
+
+// skips n bytes of string contents, failing if there aren't enough
+func validSkip(r *bytes.Reader, n uint64) error {
+	if n > uint64(r.Len()) {
+		return NewParseErr(fmt.Sprintf(
+			"string of %d bytes but only %d are available", n, r.Len()))
+	}
+	_, err := r.Seek(int64(n), io.SeekCurrent)
+	return err
+}
@@ -0,0 +1,79 @@
+// A Golang RFC7049 implementation
+// Copyright (C) 2015 Oscar Campos
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbor
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestValid(t *testing.T) {
+	// every example of the RFC7049 Appendix A is well-formed but simple(24),
+	// two bytes simple values below 32 are not well-formed under RFC 8949
+	for _, c := range rfc7049Diagnostics {
+		if c.in == "f818" {
+			continue
+		}
+		in, _ := hex.DecodeString(c.in)
+		expect(Valid(in), nil, t, "TestValid "+c.in)
+	}
+	nested := []string{
+		"a1616183a10102809f9f01ffff", // {"a": [{1: 2}, [], [_ [_ 1]]]}
+		"d9d9f7c1bf6161c482200361629f5f40ffff" + // 55799(1({_ "a": 4([-1, 3]), "b": [_ (_ h'')]}))
+			"ff",
+	}
+	for _, c := range nested {
+		in, _ := hex.DecodeString(c)
+		expect(Valid(in), nil, t, "TestValid "+c)
+	}
+}
+
+func TestValidErrors(t *testing.T) {
+	cases := []struct{ in, msg string }{
+		{"", "unexpected end of data"},
+		{"18", "unexpected end of data"},                 // truncated header argument
+		{"1a0001", "can't scan"},                         // truncated header argument
+		{"1c", "invalid additional info"},                // reserved additional info
+		{"1f", "indefinite"},                             // indefinite integer
+		{"5a00000010", "only 0 are available"},           // string longer than data
+		{"5bffffffffffffffff01", "only 1 are available"}, // huge string length
+		{"ff", "outside indefinite item"},                // unmatched break
+		{"82ff01", "outside indefinite item"},            // break in a definite array
+		{"9f01", "unexpected end of data"},               // unclosed indefinite array
+		{"bf01ff", "before the map value"},               // break in place of the value
+		{"5f6161ff", "invalid chunk"},                    // text chunk in a byte string
+		{"7f7f6161ffff", "invalid chunk"},                // nested indefinite chunk
+		{"f801", "must be encoded in the header"},        // two bytes simple value
+		{"f818", "not well-formed"},                      // reserved simple value
+		{"f81f", "not well-formed"},                      // reserved simple value
+		{"0102", "trailing data"},                        // trailing data
+		{"9fc1ff", "outside indefinite item"},            // break as a tag content
+		{"bb8000000000000000", "too big"},                // more pairs than an uint64
+	}
+	for _, c := range cases {
+		in, _ := hex.DecodeString(c.in)
+		err := Valid(in)
+		expect(err != nil, true, t, "TestValidErrors "+c.in)
+		if err != nil {
+			expect(strings.Contains(err.Error(), c.msg), true, t, "TestValidErrors "+c.in+": "+err.Error())
+		}
+	}
+
+	nested := strings.Repeat("81", defaultMaxDepth+2) + "01"
+	in, _ := hex.DecodeString(nested)
+	expect(Valid(in) != nil, true, t, "TestValidErrors nested")
+}
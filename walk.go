@@ -0,0 +1,151 @@
+// A Golang RFC7049 implementation
+// Copyright (C) 2015 Oscar Campos
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Visitor receives the data items found by Walk in the same order they
+// are encoded, the walk stops at the first error returned by any method.
+// Containers are notified with its length (-1 for indefinite length ones)
+// before its elements (keys and values alternate for maps) and tags are
+// notified with its number before its content
+type Visitor interface {
+	// OnInt receives an integer which value is n or -1-n if negative
+	OnInt(negative bool, n uint64) error
+	OnFloat(f float64) error
+	// OnBytes and OnString receive indefinite length strings joined
+	OnBytes(b []byte) error
+	OnString(s string) error
+	OnBool(b bool) error
+	// OnNull receives both null and undefined values
+	OnNull() error
+	OnSimple(v Simple) error
+	OnArrayStart(length int) error
+	OnArrayEnd() error
+	OnMapStart(length int) error
+	OnMapEnd() error
+	OnTag(number uint64) error
+}
+
+// NopVisitor is a Visitor that does nothing, it can be embedded
+// by visitors that are interested in just a few data items
+type NopVisitor struct{}
+
+func (NopVisitor) OnInt(bool, uint64) error { return nil }
+func (NopVisitor) OnFloat(float64) error    { return nil }
+func (NopVisitor) OnBytes([]byte) error     { return nil }
+func (NopVisitor) OnString(string) error    { return nil }
+func (NopVisitor) OnBool(bool) error        { return nil }
+func (NopVisitor) OnNull() error            { return nil }
+func (NopVisitor) OnSimple(Simple) error    { return nil }
+func (NopVisitor) OnArrayStart(int) error   { return nil }
+func (NopVisitor) OnArrayEnd() error        { return nil }
+func (NopVisitor) OnMapStart(int) error     { return nil }
+func (NopVisitor) OnMapEnd() error          { return nil }
+func (NopVisitor) OnTag(uint64) error       { return nil }
+
+// Walk traverses the CBOR data item in data notifying the visitor about
+// every data item it contains without decoding them into Go values, data
+// must contain exactly one well-formed data item
+func Walk(data []byte, visitor Visitor) error {
+	p := NewParser(bytes.NewReader(data))
+	tz := newTokenizer(p, defaultMaxDepth)
+	t, err := tz.next()
+	if err == nil {
+		err = walkToken(tz, visitor, t)
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = NewParseErr("unexpected end of data")
+		}
+		return err
+	}
+	p.startItem()
+	if _, err := p.scan1(); err != io.EOF {
+		return NewParseErr("trailing data after the first data item")
+	}
+	return nil
+}
+
+// notifies the data item started by t and its contents to the visitor
+func walkToken(tz *tokenizer, v Visitor, t token) error {
+	switch t.Major {
+	case cborUnsignedInt, cborNegativeInt:
+		return v.OnInt(t.Major == cborNegativeInt, t.Arg)
+	case cborByteString, cborTextString:
+		data, err := tz.join(t)
+		if err != nil {
+			return err
+		}
+		if t.Major == cborByteString {
+			return v.OnBytes(data)
+		}
+		return v.OnString(string(data))
+	case cborDataArray:
+		return walkItems(tz, v, t, v.OnArrayStart, v.OnArrayEnd)
+	case cborDataMap:
+		return walkItems(tz, v, t, v.OnMapStart, v.OnMapEnd)
+	case cborTag:
+		if err := v.OnTag(t.Arg); err != nil {
+			return err
+		}
+		return tz.each(func(_ int, t token) error {
+			return walkToken(tz, v, t)
+		})
+	}
+	return walkSimple(v, t)
+}
+
+// walks every element of an array or every key and value
+// of a map, definite length or not
+func walkItems(tz *tokenizer, v Visitor, t token, start func(int) error, end func() error) error {
+	length := -1
+	if !t.IsIndefinite() {
+		if t.Arg > math.MaxInt32 {
+			return NewParseErr(fmt.Sprintf("container length %d is too big", t.Arg))
+		}
+		length = int(t.Arg)
+	}
+	if err := start(length); err != nil {
+		return err
+	}
+	err := tz.each(func(_ int, t token) error {
+		return walkToken(tz, v, t)
+	})
+	if err != nil {
+		return err
+	}
+	return end()
+}
+
+// notifies simple values and floats to the visitor
+func walkSimple(v Visitor, t token) error {
+	switch t.Info {
+	case cborFalse, cborTrue:
+		return v.OnBool(t.Info == cborTrue)
+	case cborNil, cborUndef:
+		return v.OnNull()
+	case absoluteFloat16 & 0x1f, absoluteFloat32 & 0x1f, absoluteFloat64 & 0x1f:
+		f, _ := t.Float()
+		return v.OnFloat(f)
+	}
+	return v.OnSimple(Simple(t.Arg))
+}
@@ -0,0 +1,112 @@
+// A Golang RFC7049 implementation
+// Copyright (C) 2015 Oscar Campos
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbor
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type intCounter struct {
+	NopVisitor
+	ints int
+}
+
+func (c *intCounter) OnInt(bool, uint64) error {
+	c.ints++
+	return nil
+}
+
+// records every visited item in a diagnostic like trace
+type traceVisitor struct {
+	events []string
+}
+
+func (v *traceVisitor) add(format string, args ...interface{}) error {
+	v.events = append(v.events, fmt.Sprintf(format, args...))
+	return nil
+}
+
+func (v *traceVisitor) OnInt(neg bool, n uint64) error {
+	if neg {
+		return v.add("-%d", n+1)
+	}
+	return v.add("%d", n)
+}
+func (v *traceVisitor) OnFloat(f float64) error  { return v.add("%v", f) }
+func (v *traceVisitor) OnBytes(b []byte) error   { return v.add("h'%x'", b) }
+func (v *traceVisitor) OnString(s string) error  { return v.add("%q", s) }
+func (v *traceVisitor) OnBool(b bool) error      { return v.add("%t", b) }
+func (v *traceVisitor) OnNull() error            { return v.add("null") }
+func (v *traceVisitor) OnSimple(s Simple) error  { return v.add("simple(%d)", s) }
+func (v *traceVisitor) OnArrayStart(l int) error { return v.add("[%d", l) }
+func (v *traceVisitor) OnArrayEnd() error        { return v.add("]") }
+func (v *traceVisitor) OnMapStart(l int) error   { return v.add("{%d", l) }
+func (v *traceVisitor) OnMapEnd() error          { return v.add("}") }
+func (v *traceVisitor) OnTag(n uint64) error     { return v.add("tag(%d)", n) }
+
+func TestWalkCountInts(t *testing.T) {
+	// {"a": [1, -2, {3: 4}], "b": [_ 5, 1(6), 1.5, "x"]}
+	data, _ := hex.DecodeString("a26161830121a1030461629f05c106f93e006178ff")
+	c := &intCounter{}
+	check(Walk(data, c))
+	expect(c.ints, 6, t, "TestWalkCountInts")
+}
+
+func TestWalkEvents(t *testing.T) {
+	// {_ "a": [1, -2], "b": 1(h'01'), "c": [true, null, simple(16)]}
+	data, _ := hex.DecodeString("bf61618201216162c14101616383f5f6f0ff")
+	v := &traceVisitor{}
+	check(Walk(data, v))
+	expect(strings.Join(v.events, " "),
+		`{-1 "a" [2 1 -2 ] "b" tag(1) h'01' "c" [3 true null simple(16) ] }`, t, "TestWalkEvents")
+
+	// indefinite length strings are joined
+	data, _ = hex.DecodeString("7f61786179ff")
+	v = &traceVisitor{}
+	check(Walk(data, v))
+	expect(strings.Join(v.events, " "), `"xy"`, t, "TestWalkEvents")
+}
+
+type failingVisitor struct {
+	NopVisitor
+	visited int
+}
+
+var errStopWalk = errors.New("stop")
+
+func (v *failingVisitor) OnString(string) error {
+	v.visited++
+	return errStopWalk
+}
+
+func TestWalkErrors(t *testing.T) {
+	// visitor errors stop the walk
+	data, _ := hex.DecodeString("8361616162f6")
+	v := &failingVisitor{}
+	expect(Walk(data, v), errStopWalk, t, "TestWalkErrors")
+	expect(v.visited, 1, t, "TestWalkErrors")
+
+	for _, c := range []string{"", "ff", "8201", "9f01", "0102", "bf01ff",
+		"5b7fffffffffffffff", "5bffffffffffffffff", "5f5bffffffffffffffffff", "bb8000000000000000",
+		"9fc1ff", "5f6161ff"} {
+		data, _ := hex.DecodeString(c)
+		expect(Walk(data, NopVisitor{}) != nil, true, t, "TestWalkErrors "+c)
+	}
+}